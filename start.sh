@@ -54,8 +54,7 @@ else
    echo "success!"
 fi
 
-# Wait briefly for Caddy API to be ready
-sleep 1
+# The Web UI waits for the Caddy API itself (caddy_admin.ready_timeout)
 
 # Start Web UI
 echo -n "Starting Tailrelay Web UI... "
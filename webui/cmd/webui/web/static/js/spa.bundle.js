@@ -1,7 +1,7 @@
-(()=>{(()=>{let s={relays:[],proxies:[],showRelays:!0,showProxies:!0,tailnetFQDN:"",logs:[],logLevel:"INFO",logStream:null,currentEditItem:null,currentEditType:null,deleteTarget:null,removeTlsCert:!1},o={items:document.getElementById("items"),lastUpdated:document.getElementById("last-updated"),itemCount:document.getElementById("item-count"),alertContainer:document.getElementById("alert-container"),logOutput:document.getElementById("log-output"),logLevel:document.getElementById("log-level"),logLevelSelect:document.getElementById("log-level-select"),refresh:document.getElementById("refresh"),clearLogs:document.getElementById("clear-logs"),filterRelay:document.getElementById("filter-relay"),filterProxy:document.getElementById("filter-proxy"),themeToggle:document.getElementById("theme-toggle"),addRelayBtn:document.getElementById("add-relay-btn"),addProxyBtn:document.getElementById("add-proxy-btn"),saveRelayBtn:document.getElementById("save-relay-btn"),saveProxyBtn:document.getElementById("save-proxy-btn"),confirmDeleteBtn:document.getElementById("confirm-delete-btn"),removeTlsCertBtn:document.getElementById("proxy-tls-cert-remove")},x=[],k=()=>{let e=localStorage.getItem("theme");return e||(window.matchMedia("(prefers-color-scheme: dark)").matches?"dark":"light")},B=e=>{document.documentElement.setAttribute("data-bs-theme",e),localStorage.setItem("theme",e),S(e)},S=e=>{if(!o.themeToggle)return;let a=e==="dark"?"bi-moon-stars-fill":"bi-sun-fill";o.themeToggle.querySelector("use").setAttribute("href",`/static/vendor/bootstrap-icons/bootstrap-icons.svg#${a}`)},C=()=>{let a=(document.documentElement.getAttribute("data-bs-theme")||"light")==="dark"?"light":"dark";B(a)},u=async(e,a={})=>{let t=await fetch(e,{credentials:"same-origin",headers:{"Content-Type":"application/json",...a.headers||{}},...a});if(!t.ok){let n=await t.text();throw new Error(n||`Request failed: ${t.status}`)}return t.json()},R=()=>{let e=new Date;o.lastUpdated.textContent=e.toLocaleTimeString()},c=(e,a)=>{let t=document.createElement("div");t.className=`alert alert-${e} alert-dismissible fade show`,t.setAttribute("role","alert"),t.innerHTML=`
+(()=>{(()=>{let s={relays:[],proxies:[],foreignRoutes:[],showRelays:!0,showProxies:!0,tailnetFQDN:"",logs:[],logLevel:"INFO",logStream:null,currentEditItem:null,currentEditType:null,deleteTarget:null,removeTlsCert:!1,pinnedCert:null},o={items:document.getElementById("items"),lastUpdated:document.getElementById("last-updated"),itemCount:document.getElementById("item-count"),alertContainer:document.getElementById("alert-container"),logOutput:document.getElementById("log-output"),logLevel:document.getElementById("log-level"),logLevelSelect:document.getElementById("log-level-select"),refresh:document.getElementById("refresh"),clearLogs:document.getElementById("clear-logs"),filterRelay:document.getElementById("filter-relay"),filterProxy:document.getElementById("filter-proxy"),themeToggle:document.getElementById("theme-toggle"),addRelayBtn:document.getElementById("add-relay-btn"),addProxyBtn:document.getElementById("add-proxy-btn"),saveRelayBtn:document.getElementById("save-relay-btn"),saveProxyBtn:document.getElementById("save-proxy-btn"),confirmDeleteBtn:document.getElementById("confirm-delete-btn"),removeTlsCertBtn:document.getElementById("proxy-tls-cert-remove"),fetchTlsCertBtn:document.getElementById("proxy-tls-cert-fetch"),frontendTlsMode:document.getElementById("proxy-frontend-tls-mode")},x=[],k=()=>{let e=localStorage.getItem("theme");return e||(window.matchMedia("(prefers-color-scheme: dark)").matches?"dark":"light")},B=e=>{document.documentElement.setAttribute("data-bs-theme",e),localStorage.setItem("theme",e),S(e)},S=e=>{if(!o.themeToggle)return;let a=e==="dark"?"bi-moon-stars-fill":"bi-sun-fill";o.themeToggle.querySelector("use").setAttribute("href",`/static/vendor/bootstrap-icons/bootstrap-icons.svg#${a}`)},C=()=>{let a=(document.documentElement.getAttribute("data-bs-theme")||"light")==="dark"?"light":"dark";B(a)},u=async(e,a={})=>{let t=await fetch(e,{credentials:"same-origin",headers:{"Content-Type":"application/json",...a.headers||{}},...a});if(!t.ok){let n=await t.text();throw new Error(n||`Request failed: ${t.status}`)}return t.json()},R=()=>{let e=new Date;o.lastUpdated.textContent=e.toLocaleTimeString()},c=(e,a)=>{let t=document.createElement("div");t.className=`alert alert-${e} alert-dismissible fade show`,t.setAttribute("role","alert"),t.innerHTML=`
       <div>${a}</div>
       <button type="button" class="btn-close" data-bs-dismiss="alert" aria-label="Close"></button>
-    `,o.alertContainer.appendChild(t),setTimeout(()=>{t.classList.remove("show"),t.addEventListener("transitionend",()=>t.remove())},6e3)},D=e=>`tcp://${s.tailnetFQDN||"unknown"}:${e.listen_port} \u2192 ${e.target_host}:${e.target_port}`,M=e=>{let a=e.port?`:${e.port}`:"",t=`https://${e.hostname}${a}`;return`<a class="proxy-link" href="${t}" target="_blank" rel="noopener">${t}</a>`},b=e=>{o.items.innerHTML=`
+    `,o.alertContainer.appendChild(t),setTimeout(()=>{t.classList.remove("show"),t.addEventListener("transitionend",()=>t.remove())},6e3)},D=e=>`tcp://${s.tailnetFQDN||"unknown"}:${e.listen_port} \u2192 ${e.target_host}:${e.target_port}`,M=e=>{let a=e.port?`:${e.port}`:"",t=`https://${e.hostname}${a}`;return`<a class="proxy-link" href="${t}" target="_blank" rel="noopener">${t}</a>`},He=e=>String(e).replace(/&/g,"&amp;").replace(/"/g,"&quot;").replace(/</g,"&lt;"),Ve=e=>!e.running||!e.upstream_health||!e.upstream_health.length?"":e.upstream_health.map(a=>{let t=`${a.num_requests} requests, ${a.fails} recent failures`;return a.active_healthy===!0?t+="; health check passing":a.active_healthy===!1&&(t+=`; health check failing: ${a.active_error}`),`<span class="badge ${a.healthy?"text-bg-success":"text-bg-danger"}" data-bs-toggle="tooltip" title="${He(t)}">${a.address} ${a.healthy?"healthy":"unhealthy"}</span>`}).join(""),b=e=>{o.items.innerHTML=`
       <div class="col-12">
         <div class="card">
           <div class="card-body text-center text-muted">
@@ -9,7 +9,7 @@
           </div>
         </div>
       </div>
-    `},E=()=>{_();let a=[...s.relays.map(t=>({type:"relay",relay:t.relay,running:t.running})),...s.proxies.map(t=>({type:"proxy",proxy:t}))].filter(t=>t.type==="relay"?s.showRelays:s.showProxies);if(o.itemCount.textContent=`${a.length} item${a.length===1?"":"s"}`,!a.length){!s.showRelays&&!s.showProxies?b("Enable TCP relays or HTTPS proxies to view items."):s.showRelays&&!s.showProxies?b("No TCP relays configured."):!s.showRelays&&s.showProxies?b("No HTTPS proxies configured."):b("No relays or proxies configured.");return}o.items.innerHTML=a.map(t=>{var m,f,h;if(t.type==="relay"){let p=t.relay,v=t.running,K=v?"text-bg-success":"text-bg-secondary",X=(m=p.autostart)!=null?m:!1;return`
+    `},E=()=>{_();let a=[...s.relays.map(t=>({type:"relay",relay:t.relay,running:t.running})),...s.proxies.map(t=>({type:"proxy",proxy:t})),...s.foreignRoutes.map(t=>({type:"foreign",foreign:t}))].filter(t=>t.type==="relay"?s.showRelays:s.showProxies);if(o.itemCount.textContent=`${a.length} item${a.length===1?"":"s"}`,!a.length){!s.showRelays&&!s.showProxies?b("Enable TCP relays or HTTPS proxies to view items."):s.showRelays&&!s.showProxies?b("No TCP relays configured."):!s.showRelays&&s.showProxies?b("No HTTPS proxies configured."):b("No relays or proxies configured.");return}o.items.innerHTML=a.map(t=>{var m,f,h;if(t.type==="relay"){let p=t.relay,v=t.running,K=v?"text-bg-success":"text-bg-secondary",X=(m=p.autostart)!=null?m:!1;return`
             <div class="col-12">
               <div class="card h-100">
                 <div class="card-body d-flex flex-column flex-lg-row align-items-lg-center gap-3">
@@ -31,6 +31,9 @@
                       <svg class="bi me-1" aria-hidden="true"><use href="/static/vendor/bootstrap-icons/bootstrap-icons.svg#${v?"bi-pause-fill":"bi-play-fill"}"></use></svg>
                       ${v?"Pause":"Start"}
                     </button>
+                    <button class="btn btn-outline-secondary btn-sm test-btn" data-type="relay" data-id="${p.id}" data-bs-toggle="tooltip" title="Test connectivity to the upstream">
+                      <svg class="bi" aria-hidden="true"><use href="/static/vendor/bootstrap-icons/bootstrap-icons.svg#bi-lightning-charge"></use></svg>
+                    </button>
                     <button class="btn btn-outline-primary btn-sm edit-btn" data-type="relay" data-id="${p.id}">
                       <svg class="bi" aria-hidden="true"><use href="/static/vendor/bootstrap-icons/bootstrap-icons.svg#bi-pencil"></use></svg>
                     </button>
@@ -41,6 +44,26 @@
                 </div>
               </div>
             </div>
+          `}if(t.type==="foreign"){let n=t.foreign.proxy;return`
+            <div class="col-12">
+              <div class="card h-100 border-warning-subtle">
+                <div class="card-body d-flex flex-column flex-lg-row align-items-lg-center gap-3">
+                  <div class="flex-grow-1">
+                    <div class="d-flex align-items-center gap-2 flex-wrap">
+                      <svg class="bi text-warning" data-bs-toggle="tooltip" title="Caddy route managed outside tailrelay (read-only)" aria-hidden="true" style="width: 1.25em; height: 1.25em;"><use href="/static/vendor/bootstrap-icons/bootstrap-icons.svg#bi-link-45deg"></use></svg>
+                      <span class="fw-semibold">${M(n)} \u2192 ${n.target||n.kind||"?"}</span>
+                    </div>
+                  </div>
+                  <div class="d-flex align-items-center gap-2">
+                    <span class="badge text-bg-warning">External</span>
+                    <button class="btn btn-outline-warning btn-sm adopt-btn" data-key="${t.foreign.key}" data-bs-toggle="tooltip" title="Manage this route from tailrelay">
+                      <svg class="bi me-1" aria-hidden="true"><use href="/static/vendor/bootstrap-icons/bootstrap-icons.svg#bi-box-arrow-in-right"></use></svg>
+                      Adopt
+                    </button>
+                  </div>
+                </div>
+              </div>
+            </div>
           `}let n=t.proxy,r=(f=n.running)!=null?f:n.Running,l=r?"text-bg-success":"text-bg-secondary",d=r?"Running":"Stopped",i=(h=n.autostart)!=null?h:!1,y=n.port?`${n.hostname}:${n.port}`:n.hostname;return`
           <div class="col-12">
             <div class="card h-100">
@@ -53,6 +76,8 @@
                 </div>
                 <div class="d-flex align-items-center gap-2">
                   <span class="badge ${l}">${d}</span>
+                  ${Ve(n)}
+                  ${n.tls_pin_changed?'<span class="badge text-bg-danger" data-bs-toggle="tooltip" title="The upstream presents a different certificate than the pinned one">Certificate changed</span>':""}
                   <div class="form-check form-switch m-0" data-bs-toggle="tooltip" title="Start automatically on container boot">
                     <input class="form-check-input autostart-toggle" type="checkbox" role="switch" 
                            ${i?"checked":""} 
@@ -63,6 +88,9 @@
                     <svg class="bi me-1" aria-hidden="true"><use href="/static/vendor/bootstrap-icons/bootstrap-icons.svg#${n.enabled?"bi-pause-fill":"bi-play-fill"}"></use></svg>
                     ${n.enabled?"Pause":"Start"}
                   </button>
+                  <button class="btn btn-outline-secondary btn-sm test-btn" data-type="proxy" data-id="${n.id}" data-bs-toggle="tooltip" title="Test connectivity to the upstream">
+                    <svg class="bi" aria-hidden="true"><use href="/static/vendor/bootstrap-icons/bootstrap-icons.svg#bi-lightning-charge"></use></svg>
+                  </button>
                   <button class="btn btn-outline-primary btn-sm edit-btn" data-type="proxy" data-id="${n.id}">
                     <svg class="bi" aria-hidden="true"><use href="/static/vendor/bootstrap-icons/bootstrap-icons.svg#bi-pencil"></use></svg>
                   </button>
@@ -73,5 +101,5 @@
               </div>
             </div>
           </div>
-        `}).join(""),N()},N=()=>{document.querySelectorAll('[data-bs-toggle="tooltip"]').forEach(e=>{x.push(new bootstrap.Tooltip(e))})},_=()=>{for(;x.length;)x.pop().dispose()},g=async()=>{try{let[e,a,t]=await Promise.all([u("/api/socat/relays"),u("/api/caddy/proxies"),u("/api/tailscale/status")]);s.relays=e.map(n=>{var r;return{relay:n.Relay||n.relay,running:(r=n.Running)!=null?r:n.running}}),s.proxies=a.map(n=>{var r;return{...n,running:(r=n.running)!=null?r:n.Running}}),s.tailnetFQDN=t.MagicDNSName||t.magicDNSName||"",E(),R()}catch(e){c("danger",e.message)}},O=async(e,a)=>{let t=a?`/api/socat/stop?id=${encodeURIComponent(e)}`:`/api/socat/start?id=${encodeURIComponent(e)}`;await u(t,{method:"POST"})},A=async(e,a)=>{await u("/api/caddy/toggle",{method:"POST",body:JSON.stringify({id:e,enabled:!a})})},F=async(e,a,t)=>{var d;let n=e==="relay"?"/api/socat/update":"/api/caddy/update",r=e==="relay"?(d=s.relays.find(i=>i.relay.id===a))==null?void 0:d.relay:s.proxies.find(i=>i.id===a);if(!r)throw new Error(`${e} not found`);let l={...r,autostart:t};await u(n,{method:"POST",body:JSON.stringify(l)})},H=async e=>{let a=e.target.closest(".action-btn");if(!a)return;a.disabled=!0;let t=a.dataset.type;try{if(t==="relay"){let n=a.dataset.running==="true";await O(a.dataset.id,n)}else{let n=a.dataset.enabled==="true";await A(a.dataset.id,n)}await g()}catch(n){c("danger",n.message)}finally{a.disabled=!1}},q=async e=>{let a=e.target;if(!a.classList.contains("autostart-toggle"))return;let{type:t,id:n}=a.dataset,r=a.checked;a.disabled=!0;try{await F(t,n,r),await g()}catch(l){c("danger",l.message),a.checked=!r}finally{a.disabled=!1}},w=e=>{if(!e||!e.message)return;let t=(e.timestamp?new Date(e.timestamp):new Date).toLocaleTimeString(),n=e.source?` [${e.source}]`:"",r=`${t} [${e.level}]${n} ${e.message}`,l=o.logOutput,d=l.scrollTop+l.clientHeight>=l.scrollHeight-8;l.textContent+=`${r}
-`,d&&(l.scrollTop=l.scrollHeight)},U=async()=>{try{let e=await u("/api/logs");s.logs=e.logs||[],s.logLevel=e.level||"INFO",o.logLevel.textContent=s.logLevel,o.logLevelSelect&&(o.logLevelSelect.value=s.logLevel),o.logOutput.textContent="",s.logs.forEach(w)}catch(e){c("warning",e.message)}},J=async e=>{try{let a=await u("/api/logs/level",{method:"POST",body:JSON.stringify({level:e})});s.logLevel=a.level||e,o.logLevel.textContent=s.logLevel,o.logLevelSelect&&(o.logLevelSelect.value=s.logLevel)}catch(a){c("warning",a.message)}},Q=()=>{s.logStream&&s.logStream.close();let e=new EventSource("/api/logs/stream");e.onmessage=a=>{try{let t=JSON.parse(a.data);if(t.connected)return;w(t)}catch{}},e.onerror=()=>{c("warning","Log stream disconnected. Retrying...")},s.logStream=e},I=(e=null)=>{var n;let a=new bootstrap.Modal(document.getElementById("relayModal")),t=document.querySelector("#relayModal .modal-title");s.currentEditItem=e,s.currentEditType="relay",e?(t.textContent="Edit Relay",document.getElementById("relay-id").value=e.id,document.getElementById("relay-listen-port").value=e.listen_port,document.getElementById("relay-target-host").value=e.target_host,document.getElementById("relay-target-port").value=e.target_port,document.getElementById("relay-autostart").checked=(n=e.autostart)!=null?n:!1):(t.textContent="Add Relay",document.getElementById("relayForm").reset(),document.getElementById("relay-id").value="",document.getElementById("relay-autostart").checked=!0),a.show()},$=(e=null)=>{var d,i;let a=new bootstrap.Modal(document.getElementById("proxyModal")),t=document.querySelector("#proxyModal .modal-title"),n=document.getElementById("proxy-tls-cert-current"),r=document.getElementById("proxy-tls-cert-filename"),l=document.getElementById("proxy-tls-cert");if(s.currentEditItem=e,s.currentEditType="proxy",s.removeTlsCert=!1,e)if(t.textContent="Edit Proxy",document.getElementById("proxy-id").value=e.id,document.getElementById("proxy-port").value=e.port||"",document.getElementById("proxy-target").value=e.target,document.getElementById("proxy-trusted-proxies").checked=(d=e.trusted_proxies)!=null?d:!1,document.getElementById("proxy-autostart").checked=(i=e.autostart)!=null?i:!1,l.value="",e.tls_cert_file){let y=e.tls_cert_file.split("/").pop();r.textContent=y,n.style.display="flex"}else n.style.display="none";else t.textContent="Add Proxy",document.getElementById("proxyForm").reset(),document.getElementById("proxy-id").value="",document.getElementById("proxy-autostart").checked=!0,l.value="",n.style.display="none";a.show()},L=async()=>{let e=document.getElementById("relay-id").value,a=parseInt(document.getElementById("relay-listen-port").value),t=document.getElementById("relay-target-host").value.trim(),n=parseInt(document.getElementById("relay-target-port").value),r=document.getElementById("relay-autostart").checked;if(!a||!t||!n){c("danger","Please fill in all required fields");return}let l={listen_port:a,target_host:t,target_port:n,autostart:r,enabled:!0};e&&(l.id=e);try{o.saveRelayBtn.disabled=!0,await u(e?"/api/socat/update":"/api/socat/create",{method:"POST",body:JSON.stringify(l)}),bootstrap.Modal.getInstance(document.getElementById("relayModal")).hide(),c("success",`Relay ${e?"updated":"created"} successfully`),await g()}catch(d){c("danger",d.message)}finally{o.saveRelayBtn.disabled=!1}},T=async()=>{let e=document.getElementById("proxy-id").value,a=document.getElementById("proxy-port").value.trim(),t=document.getElementById("proxy-target").value.trim(),n=document.getElementById("proxy-trusted-proxies").checked,r=document.getElementById("proxy-autostart").checked,l=document.getElementById("proxy-tls-cert").files[0],d=s.tailnetFQDN.replace(/\.$/,"");if(!d){c("danger","MagicDNS hostname not available. Please ensure Tailscale is connected.");return}if(!t){c("danger","Please fill in the target URL");return}if(l){let y=[".pem",".crt",".cer"],m=l.name.toLowerCase();if(!y.some(h=>m.endsWith(h))){c("danger","Invalid certificate file. Please upload a .pem, .crt, or .cer file.");return}if(l.size>1024*1024){c("danger","Certificate file too large. Maximum size is 1MB.");return}}let i=new FormData;i.append("hostname",d),i.append("target",t),i.append("trusted_proxies",n.toString()),i.append("autostart",r.toString()),i.append("enabled","true"),a&&i.append("port",a),e&&i.append("id",e),l&&i.append("tls_cert_upload",l),s.removeTlsCert&&i.append("remove_tls_cert","true");try{o.saveProxyBtn.disabled=!0;let m=await fetch(e?"/api/caddy/update":"/api/caddy/create",{method:"POST",credentials:"same-origin",body:i});if(!m.ok){let f=await m.text();throw new Error(f||`Request failed: ${m.status}`)}await m.json(),bootstrap.Modal.getInstance(document.getElementById("proxyModal")).hide(),c("success",`Proxy ${e?"updated":"created"} successfully`),await g()}catch(y){c("danger",y.message)}finally{o.saveProxyBtn.disabled=!1}},j=(e,a,t)=>{let n=new bootstrap.Modal(document.getElementById("deleteModal")),r=document.getElementById("delete-message");s.deleteTarget={type:e,id:a},r.textContent=`Are you sure you want to delete ${e==="relay"?"relay":"proxy"} "${t}"? This action cannot be undone.`,n.show()},z=async()=>{if(!s.deleteTarget)return;let{type:e,id:a}=s.deleteTarget;try{o.confirmDeleteBtn.disabled=!0;let t=e==="relay"?`/api/socat/delete?id=${encodeURIComponent(a)}`:`/api/caddy/delete?id=${encodeURIComponent(a)}`;await u(t,{method:"POST"}),bootstrap.Modal.getInstance(document.getElementById("deleteModal")).hide(),c("success",`${e==="relay"?"Relay":"Proxy"} deleted successfully`),await g()}catch(t){c("danger",t.message)}finally{o.confirmDeleteBtn.disabled=!1,s.deleteTarget=null}},V=async e=>{var r;let a=e.target.closest(".edit-btn");if(!a)return;let t=a.dataset.type,n=a.dataset.id;if(t==="relay"){let l=(r=s.relays.find(d=>d.relay.id===n))==null?void 0:r.relay;l&&I(l)}else if(t==="proxy"){let l=s.proxies.find(d=>d.id===n);l&&$(l)}},W=async e=>{let a=e.target.closest(".delete-btn");if(!a)return;let t=a.dataset.type,n=a.dataset.id,r=a.dataset.name;j(t,n,r)},G=()=>{var e,a;o.items.addEventListener("click",H),o.items.addEventListener("click",V),o.items.addEventListener("click",W),o.items.addEventListener("change",q),o.filterRelay.addEventListener("change",()=>{s.showRelays=o.filterRelay.checked,E()}),o.filterProxy.addEventListener("change",()=>{s.showProxies=o.filterProxy.checked,E()}),o.themeToggle&&o.themeToggle.addEventListener("click",C),o.refresh.addEventListener("click",g),o.clearLogs.addEventListener("click",()=>{o.logOutput.textContent=""}),o.logLevelSelect&&o.logLevelSelect.addEventListener("change",t=>{J(t.target.value)}),o.addRelayBtn&&o.addRelayBtn.addEventListener("click",t=>{t.preventDefault(),I()}),o.addProxyBtn&&o.addProxyBtn.addEventListener("click",t=>{t.preventDefault(),$()}),o.saveRelayBtn&&o.saveRelayBtn.addEventListener("click",L),o.saveProxyBtn&&o.saveProxyBtn.addEventListener("click",T),o.confirmDeleteBtn&&o.confirmDeleteBtn.addEventListener("click",z),o.removeTlsCertBtn&&o.removeTlsCertBtn.addEventListener("click",()=>{s.removeTlsCert=!0,document.getElementById("proxy-tls-cert-current").style.display="none",c("info","Certificate will be removed when you save the proxy.")}),(e=document.getElementById("relayForm"))==null||e.addEventListener("submit",t=>{t.preventDefault(),L()}),(a=document.getElementById("proxyForm"))==null||a.addEventListener("submit",t=>{t.preventDefault(),T()})},P=async()=>{B(k()),G(),await g(),await U(),Q(),setInterval(g,15e3)};document.readyState==="loading"?document.addEventListener("DOMContentLoaded",P):P()})();})();
+        `}).join(""),N()},N=()=>{document.querySelectorAll('[data-bs-toggle="tooltip"]').forEach(e=>{x.push(new bootstrap.Tooltip(e))})},_=()=>{for(;x.length;)x.pop().dispose()},g=async()=>{try{let[e,a,r,t]=await Promise.all([u("/api/socat/relays"),u("/api/caddy/proxies"),u("/api/caddy/foreign").catch(()=>[]),u("/api/tailscale/status")]);s.relays=e.map(n=>{var r;return{relay:n.Relay||n.relay,running:(r=n.Running)!=null?r:n.running}}),s.proxies=a.map(n=>{var r;return{...n,running:(r=n.running)!=null?r:n.Running}}),s.foreignRoutes=r,s.tailnetFQDN=t.MagicDNSName||t.magicDNSName||"",E(),R()}catch(e){c("danger",e.message)}},O=async(e,a)=>{let t=a?`/api/socat/stop?id=${encodeURIComponent(e)}`:`/api/socat/start?id=${encodeURIComponent(e)}`;await u(t,{method:"POST"})},A=async(e,a)=>{await u("/api/caddy/toggle",{method:"POST",body:JSON.stringify({id:e,enabled:!a})})},F=async(e,a,t)=>{var d;let n=e==="relay"?"/api/socat/update":"/api/caddy/update",r=e==="relay"?(d=s.relays.find(i=>i.relay.id===a))==null?void 0:d.relay:s.proxies.find(i=>i.id===a);if(!r)throw new Error(`${e} not found`);let l={...r,autostart:t};await u(n,{method:"POST",body:JSON.stringify(l)})},Ue=async()=>{let e=document.getElementById("proxy-target").value.trim();if(!e){c("danger","Please fill in the target URL");return}o.fetchTlsCertBtn.disabled=!0;try{let a=await u("/api/caddy/tls/fetch",{method:"POST",body:JSON.stringify({target:e})}),r=a.certificates[0];if(!window.confirm(`Trust the certificate presented by ${a.target}?\n\nSubject: ${r.subject}\nIssuer: ${r.issuer}\nExpires: ${new Date(r.not_after).toLocaleDateString()} (${r.expires_in_days} days)\nSHA-256: ${a.fingerprint}`))return;let t=await u("/api/caddy/tls/pin",{method:"POST",body:JSON.stringify({target:e,fingerprint:a.fingerprint})});s.pinnedCert={file:t.tls_cert_file,fingerprint:t.fingerprint},s.removeTlsCert=!1,document.getElementById("proxy-tls-cert").value="",document.getElementById("proxy-tls-cert-filename").textContent=t.tls_cert_file.split("/").pop(),document.getElementById("proxy-tls-cert-current").style.display="flex",c("info","Certificate pinned; it will be used when you save the proxy.")}catch(a){c("danger",a.message)}finally{o.fetchTlsCertBtn.disabled=!1}},Pe=e=>{let a=e.steps.filter(t=>!t.skipped).map(t=>t.ok?`${t.name} ${t.duration_ms.toFixed(1)}ms`:`${t.name} failed: ${t.error}`),r=e.certificates?.[0];return r&&a.push(`certificate expires in ${r.expires_in_days} days`),`${e.address}: ${a.join(" \xB7 ")}`},Te=async e=>{let a=e.target.closest(".test-btn");if(!a)return;let r=a.dataset.type==="relay"?{relay_id:a.dataset.id}:{proxy_id:a.dataset.id};a.disabled=!0;try{let t=await u("/api/diagnostics/probe",{method:"POST",body:JSON.stringify(r)}),n=t.reports.map(Pe).join("<br>");c(t.ok?"success":"danger",`${t.ok?"Test passed":"Test failed"}<br>${n}`)}catch(t){c("danger",t.message)}finally{a.disabled=!1}},ae=async e=>{let a=e.target.closest(".adopt-btn");if(a){a.disabled=!0;try{await u("/api/caddy/adopt",{method:"POST",body:JSON.stringify({key:a.dataset.key})}),c("success","Route adopted; it can now be managed here"),await g()}catch(t){c("danger",t.message)}finally{a.disabled=!1}}},H=async e=>{let a=e.target.closest(".action-btn");if(!a)return;a.disabled=!0;let t=a.dataset.type;try{if(t==="relay"){let n=a.dataset.running==="true";await O(a.dataset.id,n)}else{let n=a.dataset.enabled==="true";await A(a.dataset.id,n)}await g()}catch(n){c("danger",n.message)}finally{a.disabled=!1}},q=async e=>{let a=e.target;if(!a.classList.contains("autostart-toggle"))return;let{type:t,id:n}=a.dataset,r=a.checked;a.disabled=!0;try{await F(t,n,r),await g()}catch(l){c("danger",l.message),a.checked=!r}finally{a.disabled=!1}},w=e=>{if(!e||!e.message)return;let t=(e.timestamp?new Date(e.timestamp):new Date).toLocaleTimeString(),n=e.source?` [${e.source}]`:"",r=`${t} [${e.level}]${n} ${e.message}`,l=o.logOutput,d=l.scrollTop+l.clientHeight>=l.scrollHeight-8;l.textContent+=`${r}
+`,d&&(l.scrollTop=l.scrollHeight)},U=async()=>{try{let e=await u("/api/logs");s.logs=e.logs||[],s.logLevel=e.level||"INFO",o.logLevel.textContent=s.logLevel,o.logLevelSelect&&(o.logLevelSelect.value=s.logLevel),o.logOutput.textContent="",s.logs.forEach(w)}catch(e){c("warning",e.message)}},J=async e=>{try{let a=await u("/api/logs/level",{method:"POST",body:JSON.stringify({level:e})});s.logLevel=a.level||e,o.logLevel.textContent=s.logLevel,o.logLevelSelect&&(o.logLevelSelect.value=s.logLevel)}catch(a){c("warning",a.message)}},Q=()=>{s.logStream&&s.logStream.close();let e=new EventSource("/api/logs/stream");e.onmessage=a=>{try{let t=JSON.parse(a.data);if(t.connected)return;w(t)}catch{}},e.onerror=()=>{c("warning","Log stream disconnected. Retrying...")},s.logStream=e},I=(e=null)=>{var n;let a=new bootstrap.Modal(document.getElementById("relayModal")),t=document.querySelector("#relayModal .modal-title");s.currentEditItem=e,s.currentEditType="relay",e?(t.textContent="Edit Relay",document.getElementById("relay-id").value=e.id,document.getElementById("relay-listen-port").value=e.listen_port,document.getElementById("relay-target-host").value=e.target_host,document.getElementById("relay-target-port").value=e.target_port,document.getElementById("relay-autostart").checked=(n=e.autostart)!=null?n:!1):(t.textContent="Add Relay",document.getElementById("relayForm").reset(),document.getElementById("relay-id").value="",document.getElementById("relay-autostart").checked=!0),a.show()},$=(e=null)=>{var d,i;let a=new bootstrap.Modal(document.getElementById("proxyModal")),t=document.querySelector("#proxyModal .modal-title"),n=document.getElementById("proxy-tls-cert-current"),r=document.getElementById("proxy-tls-cert-filename"),l=document.getElementById("proxy-tls-cert");if(s.currentEditItem=e,s.currentEditType="proxy",s.removeTlsCert=!1,s.pinnedCert=null,e){if(t.textContent="Edit Proxy",document.getElementById("proxy-id").value=e.id,document.getElementById("proxy-port").value=e.port||"",document.getElementById("proxy-target").value=e.target,document.getElementById("proxy-trusted-proxies").checked=(d=e.trusted_proxies)!=null?d:!1,document.getElementById("proxy-autostart").checked=(i=e.autostart)!=null?i:!1,l.value="",e.tls_cert_file){let y=e.tls_cert_file.split("/").pop();r.textContent=y,n.style.display="flex"}else n.style.display="none";o.frontendTlsMode.value=e.frontend_tls_mode||"",document.getElementById("proxy-frontend-tls-cert").value="",document.getElementById("proxy-frontend-tls-key").value="",document.getElementById("proxy-frontend-tls-current").textContent=e.frontend_tls_cert_file?`Current: ${e.frontend_tls_cert_file.split("/").pop()}`:"",Je(e.health_checks||{})}else t.textContent="Add Proxy",document.getElementById("proxyForm").reset(),document.getElementById("proxy-id").value="",document.getElementById("proxy-autostart").checked=!0,l.value="",n.style.display="none",document.getElementById("proxy-frontend-tls-current").textContent="",Je({});Ne(),a.show()},Je=e=>{document.getElementById("proxy-health-uri").value=e.uri||"",document.getElementById("proxy-health-interval").value=e.interval||"",document.getElementById("proxy-health-timeout").value=e.timeout||"",document.getElementById("proxy-health-expect-status").value=e.expect_status||"",document.getElementById("proxy-health-max-fails").value=e.max_fails||"",document.getElementById("proxy-health-fail-duration").value=e.fail_duration||""},Qe=e=>{let a={...e,uri:document.getElementById("proxy-health-uri").value.trim(),interval:document.getElementById("proxy-health-interval").value.trim(),timeout:document.getElementById("proxy-health-timeout").value.trim(),expect_status:parseInt(document.getElementById("proxy-health-expect-status").value)||0,max_fails:parseInt(document.getElementById("proxy-health-max-fails").value)||0,fail_duration:document.getElementById("proxy-health-fail-duration").value.trim()};return Object.keys(a).forEach(t=>{a[t]||delete a[t]}),Object.keys(a).length?a:null},Ne=()=>{let e=o.frontendTlsMode.value;document.getElementById("proxy-frontend-tls-files").style.display=e==="load_files"?"block":"none",document.getElementById("proxy-internal-ca-download").style.display=e==="internal"?"inline-block":"none"},L=async()=>{let e=document.getElementById("relay-id").value,a=parseInt(document.getElementById("relay-listen-port").value),t=document.getElementById("relay-target-host").value.trim(),n=parseInt(document.getElementById("relay-target-port").value),r=document.getElementById("relay-autostart").checked;if(!a||!t||!n){c("danger","Please fill in all required fields");return}let l={listen_port:a,target_host:t,target_port:n,autostart:r,enabled:!0};e&&(l.id=e);try{o.saveRelayBtn.disabled=!0,await u(e?"/api/socat/update":"/api/socat/create",{method:"POST",body:JSON.stringify(l)}),bootstrap.Modal.getInstance(document.getElementById("relayModal")).hide(),c("success",`Relay ${e?"updated":"created"} successfully`),await g()}catch(d){c("danger",d.message)}finally{o.saveRelayBtn.disabled=!1}},T=async()=>{let e=document.getElementById("proxy-id").value,a=document.getElementById("proxy-port").value.trim(),t=document.getElementById("proxy-target").value.trim(),n=document.getElementById("proxy-trusted-proxies").checked,r=document.getElementById("proxy-autostart").checked,l=document.getElementById("proxy-tls-cert").files[0],x=o.frontendTlsMode.value,v=document.getElementById("proxy-frontend-tls-cert").files[0],b=document.getElementById("proxy-frontend-tls-key").files[0],existing=s.currentEditItem,healthChecks=Qe(existing&&existing.health_checks||{}),d=s.tailnetFQDN.replace(/\.$/,"");if(!d){c("danger","MagicDNS hostname not available. Please ensure Tailscale is connected.");return}if(!t){c("danger","Please fill in the target URL");return}if(l){let y=[".pem",".crt",".cer"],m=l.name.toLowerCase();if(!y.some(h=>m.endsWith(h))){c("danger","Invalid certificate file. Please upload a .pem, .crt, or .cer file.");return}if(l.size>1024*1024){c("danger","Certificate file too large. Maximum size is 1MB.");return}}let i=new FormData;i.append("hostname",d),i.append("target",t),i.append("trusted_proxies",n.toString()),i.append("autostart",r.toString()),i.append("enabled","true"),i.append("frontend_tls_mode",x),i.append("health_checks",healthChecks?JSON.stringify(healthChecks):""),a&&i.append("port",a),e&&i.append("id",e),l&&i.append("tls_cert_upload",l),s.pinnedCert&&!l&&(i.append("tls","true"),i.append("tls_cert_file",s.pinnedCert.file),i.append("tls_pinned_sha256",s.pinnedCert.fingerprint)),s.removeTlsCert&&i.append("remove_tls_cert","true"),x==="load_files"&&(v&&i.append("frontend_tls_cert_upload",v),b&&i.append("frontend_tls_key_upload",b));try{o.saveProxyBtn.disabled=!0;let m=await fetch(e?"/api/caddy/update":"/api/caddy/create",{method:"POST",credentials:"same-origin",body:i});if(!m.ok){let f=await m.text();throw new Error(f||`Request failed: ${m.status}`)}await m.json(),bootstrap.Modal.getInstance(document.getElementById("proxyModal")).hide(),c("success",`Proxy ${e?"updated":"created"} successfully`),await g()}catch(y){c("danger",y.message)}finally{o.saveProxyBtn.disabled=!1}},j=(e,a,t)=>{let n=new bootstrap.Modal(document.getElementById("deleteModal")),r=document.getElementById("delete-message");s.deleteTarget={type:e,id:a},r.textContent=`Are you sure you want to delete ${e==="relay"?"relay":"proxy"} "${t}"? This action cannot be undone.`,n.show()},z=async()=>{if(!s.deleteTarget)return;let{type:e,id:a}=s.deleteTarget;try{o.confirmDeleteBtn.disabled=!0;let t=e==="relay"?`/api/socat/delete?id=${encodeURIComponent(a)}`:`/api/caddy/delete?id=${encodeURIComponent(a)}`;await u(t,{method:"POST"}),bootstrap.Modal.getInstance(document.getElementById("deleteModal")).hide(),c("success",`${e==="relay"?"Relay":"Proxy"} deleted successfully`),await g()}catch(t){c("danger",t.message)}finally{o.confirmDeleteBtn.disabled=!1,s.deleteTarget=null}},V=async e=>{var r;let a=e.target.closest(".edit-btn");if(!a)return;let t=a.dataset.type,n=a.dataset.id;if(t==="relay"){let l=(r=s.relays.find(d=>d.relay.id===n))==null?void 0:r.relay;l&&I(l)}else if(t==="proxy"){let l=s.proxies.find(d=>d.id===n);l&&$(l)}},W=async e=>{let a=e.target.closest(".delete-btn");if(!a)return;let t=a.dataset.type,n=a.dataset.id,r=a.dataset.name;j(t,n,r)},G=()=>{var e,a;o.items.addEventListener("click",H),o.items.addEventListener("click",V),o.items.addEventListener("click",W),o.items.addEventListener("click",ae),o.items.addEventListener("click",Te),o.items.addEventListener("change",q),o.filterRelay.addEventListener("change",()=>{s.showRelays=o.filterRelay.checked,E()}),o.filterProxy.addEventListener("change",()=>{s.showProxies=o.filterProxy.checked,E()}),o.themeToggle&&o.themeToggle.addEventListener("click",C),o.refresh.addEventListener("click",g),o.clearLogs.addEventListener("click",()=>{o.logOutput.textContent=""}),o.logLevelSelect&&o.logLevelSelect.addEventListener("change",t=>{J(t.target.value)}),o.addRelayBtn&&o.addRelayBtn.addEventListener("click",t=>{t.preventDefault(),I()}),o.addProxyBtn&&o.addProxyBtn.addEventListener("click",t=>{t.preventDefault(),$()}),o.saveRelayBtn&&o.saveRelayBtn.addEventListener("click",L),o.saveProxyBtn&&o.saveProxyBtn.addEventListener("click",T),o.frontendTlsMode&&o.frontendTlsMode.addEventListener("change",Ne),o.fetchTlsCertBtn&&o.fetchTlsCertBtn.addEventListener("click",Ue),o.confirmDeleteBtn&&o.confirmDeleteBtn.addEventListener("click",z),o.removeTlsCertBtn&&o.removeTlsCertBtn.addEventListener("click",()=>{s.removeTlsCert=!0,s.pinnedCert=null,document.getElementById("proxy-tls-cert-current").style.display="none",c("info","Certificate will be removed when you save the proxy.")}),(e=document.getElementById("relayForm"))==null||e.addEventListener("submit",t=>{t.preventDefault(),L()}),(a=document.getElementById("proxyForm"))==null||a.addEventListener("submit",t=>{t.preventDefault(),T()})},Ce=async()=>{try{(await u("/api/certificates")).filter(a=>a.status==="expiring"||a.status==="expired").forEach(a=>{let r=new Date(a.not_after).toLocaleDateString(),t=a.status==="expired"?"expired on":"expires on";c("warning",`Certificate ${a.name} ${t} ${r}`)})}catch(e){c("warning",e.message)}},Fe=async()=>{try{let e=await u("/api/tailscale/certs");if(!e.enabled)return;e.error&&c("warning",`Tailscale HTTPS certificates: ${e.error}`),(e.certificates||[]).filter(a=>a.error).forEach(a=>{c("warning",`Tailscale HTTPS certificate for ${a.domain}: ${a.error}`)})}catch(e){c("warning",e.message)}},P=async()=>{B(k()),G(),await g(),await Ce(),await Fe(),await U(),Q(),setInterval(g,15e3)};document.readyState==="loading"?document.addEventListener("DOMContentLoaded",P):P()})();})();
@@ -24,17 +24,7 @@ func main() {
 	}
 	fmt.Printf("Caddy API accessible: %v\n\n", running)
 
-	// Example 2: Initialize server (one-time setup)
-	fmt.Println("=== Initializing Server ===")
-	err = manager.InitializeServer([]string{":80", ":443"})
-	if err != nil {
-		log.Printf("Server initialization: %v (may already exist)\n", err)
-	} else {
-		fmt.Println("Server initialized successfully")
-	}
-	fmt.Println()
-
-	// Example 3: Add a new proxy
+	// Example 2: Add a new proxy
 	fmt.Println("=== Adding New Proxy ===")
 	proxy := config.CaddyProxy{
 		Hostname: "myserver.tailnet.ts.net",
@@ -54,7 +44,7 @@ func main() {
 	}
 	fmt.Println()
 
-	// Example 4: List all proxies
+	// Example 3: List all proxies
 	fmt.Println("=== Listing All Proxies ===")
 	proxies, err := manager.ListProxies()
 	if err != nil {
@@ -67,7 +57,7 @@ func main() {
 	}
 	fmt.Println()
 
-	// Example 5: Get a specific proxy
+	// Example 4: Get a specific proxy
 	fmt.Println("=== Getting Specific Proxy ===")
 	retrievedProxy, err := manager.GetProxy(createdProxy.ID)
 	if err != nil {
@@ -77,7 +67,7 @@ func main() {
 	}
 	fmt.Println()
 
-	// Example 6: Update proxy
+	// Example 5: Update proxy
 	fmt.Println("=== Updating Proxy ===")
 	if retrievedProxy != nil {
 		retrievedProxy.Target = "localhost:9001"
@@ -90,7 +80,7 @@ func main() {
 	}
 	fmt.Println()
 
-	// Example 7: Toggle proxy (disable)
+	// Example 6: Toggle proxy (disable)
 	fmt.Println("=== Toggling Proxy ===")
 	err = manager.ToggleProxy(createdProxy.ID, false)
 	if err != nil {
@@ -100,7 +90,7 @@ func main() {
 	}
 	fmt.Println()
 
-	// Example 8: Get upstream status
+	// Example 7: Get upstream status
 	fmt.Println("=== Getting Upstream Status ===")
 	upstreams, err := manager.GetUpstreams()
 	if err != nil {
@@ -114,7 +104,7 @@ func main() {
 	}
 	fmt.Println()
 
-	// Example 9: Delete proxy
+	// Example 8: Delete proxy
 	fmt.Println("=== Deleting Proxy ===")
 	err = manager.DeleteProxy(createdProxy.ID)
 	if err != nil {
@@ -124,7 +114,7 @@ func main() {
 	}
 	fmt.Println()
 
-	// Example 10: Add HTTPS proxy with TLS
+	// Example 9: Add HTTPS proxy with TLS
 	fmt.Println("=== Adding HTTPS Proxy ===")
 	httpsProxy := config.CaddyProxy{
 		Hostname: "secure.tailnet.ts.net",
@@ -2,6 +2,7 @@
   const state = {
     relays: [],
     proxies: [],
+    foreignRoutes: [],
     showRelays: true,
     showProxies: true,
     tailnetFQDN: "",
@@ -12,6 +13,7 @@
     currentEditType: null,
     deleteTarget: null,
     removeTlsCert: false,
+    pinnedCert: null,
   };
 
   const elements = {
@@ -33,6 +35,8 @@
     saveProxyBtn: document.getElementById("save-proxy-btn"),
     confirmDeleteBtn: document.getElementById("confirm-delete-btn"),
     removeTlsCertBtn: document.getElementById("proxy-tls-cert-remove"),
+    fetchTlsCertBtn: document.getElementById("proxy-tls-cert-fetch"),
+    frontendTlsMode: document.getElementById("proxy-frontend-tls-mode"),
   };
 
   const tooltips = [];
@@ -113,6 +117,29 @@
     return `<a class="proxy-link" href="${url}" target="_blank" rel="noopener">${url}</a>`;
   };
 
+  const escapeAttr = (value) =>
+    String(value).replace(/&/g, "&amp;").replace(/"/g, "&quot;").replace(/</g, "&lt;");
+
+  // One badge per upstream. Caddy reports request and failure counts; the
+  // active check result comes from the web UI running the same check.
+  const formatUpstreamHealth = (proxy) => {
+    if (!proxy.running || !proxy.upstream_health || !proxy.upstream_health.length) {
+      return "";
+    }
+    return proxy.upstream_health
+      .map((upstream) => {
+        let title = `${upstream.num_requests} requests, ${upstream.fails} recent failures`;
+        if (upstream.active_healthy === true) {
+          title += "; health check passing";
+        } else if (upstream.active_healthy === false) {
+          title += `; health check failing: ${upstream.active_error}`;
+        }
+        const badge = upstream.healthy ? "text-bg-success" : "text-bg-danger";
+        return `<span class="badge ${badge}" data-bs-toggle="tooltip" title="${escapeAttr(title)}">${upstream.address} ${upstream.healthy ? "healthy" : "unhealthy"}</span>`;
+      })
+      .join("");
+  };
+
   const renderEmpty = (message) => {
     elements.items.innerHTML = `
       <div class="col-12">
@@ -138,6 +165,10 @@
         type: "proxy",
         proxy: item,
       })),
+      ...state.foreignRoutes.map((item) => ({
+        type: "foreign",
+        foreign: item,
+      })),
     ];
 
     const filtered = combined.filter((item) =>
@@ -188,6 +219,9 @@
                       <svg class="bi me-1" aria-hidden="true"><use href="/static/vendor/bootstrap-icons/bootstrap-icons.svg#${running ? "bi-pause-fill" : "bi-play-fill"}"></use></svg>
                       ${running ? "Pause" : "Start"}
                     </button>
+                    <button class="btn btn-outline-secondary btn-sm test-btn" data-type="relay" data-id="${relay.id}" data-bs-toggle="tooltip" title="Test connectivity to the upstream">
+                      <svg class="bi" aria-hidden="true"><use href="/static/vendor/bootstrap-icons/bootstrap-icons.svg#bi-lightning-charge"></use></svg>
+                    </button>
                     <button class="btn btn-outline-primary btn-sm edit-btn" data-type="relay" data-id="${relay.id}">
                       <svg class="bi" aria-hidden="true"><use href="/static/vendor/bootstrap-icons/bootstrap-icons.svg#bi-pencil"></use></svg>
                     </button>
@@ -201,6 +235,31 @@
           `;
         }
 
+        if (item.type === "foreign") {
+          const proxy = item.foreign.proxy;
+          return `
+            <div class="col-12">
+              <div class="card h-100 border-warning-subtle">
+                <div class="card-body d-flex flex-column flex-lg-row align-items-lg-center gap-3">
+                  <div class="flex-grow-1">
+                    <div class="d-flex align-items-center gap-2 flex-wrap">
+                      <svg class="bi text-warning" data-bs-toggle="tooltip" title="Caddy route managed outside tailrelay (read-only)" aria-hidden="true" style="width: 1.25em; height: 1.25em;"><use href="/static/vendor/bootstrap-icons/bootstrap-icons.svg#bi-link-45deg"></use></svg>
+                      <span class="fw-semibold">${formatProxyLink(proxy)} → ${proxy.target || proxy.kind || "?"}</span>
+                    </div>
+                  </div>
+                  <div class="d-flex align-items-center gap-2">
+                    <span class="badge text-bg-warning">External</span>
+                    <button class="btn btn-outline-warning btn-sm adopt-btn" data-key="${item.foreign.key}" data-bs-toggle="tooltip" title="Manage this route from tailrelay">
+                      <svg class="bi me-1" aria-hidden="true"><use href="/static/vendor/bootstrap-icons/bootstrap-icons.svg#bi-box-arrow-in-right"></use></svg>
+                      Adopt
+                    </button>
+                  </div>
+                </div>
+              </div>
+            </div>
+          `;
+        }
+
         const proxy = item.proxy;
         const running = proxy.running ?? proxy.Running;
         const runningBadge = running ? "text-bg-success" : "text-bg-secondary";
@@ -219,6 +278,8 @@
                 </div>
                 <div class="d-flex align-items-center gap-2">
                   <span class="badge ${runningBadge}">${runningLabel}</span>
+                  ${formatUpstreamHealth(proxy)}
+                  ${proxy.tls_pin_changed ? '<span class="badge text-bg-danger" data-bs-toggle="tooltip" title="The upstream presents a different certificate than the pinned one">Certificate changed</span>' : ""}
                   <div class="form-check form-switch m-0" data-bs-toggle="tooltip" title="Start automatically on container boot">
                     <input class="form-check-input autostart-toggle" type="checkbox" role="switch" 
                            ${autostart ? "checked" : ""} 
@@ -229,6 +290,9 @@
                     <svg class="bi me-1" aria-hidden="true"><use href="/static/vendor/bootstrap-icons/bootstrap-icons.svg#${proxy.enabled ? "bi-pause-fill" : "bi-play-fill"}"></use></svg>
                     ${proxy.enabled ? "Pause" : "Start"}
                   </button>
+                  <button class="btn btn-outline-secondary btn-sm test-btn" data-type="proxy" data-id="${proxy.id}" data-bs-toggle="tooltip" title="Test connectivity to the upstream">
+                    <svg class="bi" aria-hidden="true"><use href="/static/vendor/bootstrap-icons/bootstrap-icons.svg#bi-lightning-charge"></use></svg>
+                  </button>
                   <button class="btn btn-outline-primary btn-sm edit-btn" data-type="proxy" data-id="${proxy.id}">
                     <svg class="bi" aria-hidden="true"><use href="/static/vendor/bootstrap-icons/bootstrap-icons.svg#bi-pencil"></use></svg>
                   </button>
@@ -261,9 +325,10 @@
 
   const refreshData = async () => {
     try {
-      const [relays, proxies, status] = await Promise.all([
+      const [relays, proxies, foreignRoutes, status] = await Promise.all([
         fetchJSON("/api/socat/relays"),
         fetchJSON("/api/caddy/proxies"),
+        fetchJSON("/api/caddy/foreign").catch(() => []),
         fetchJSON("/api/tailscale/status"),
       ]);
 
@@ -275,6 +340,7 @@
         ...proxy,
         running: proxy.running ?? proxy.Running,
       }));
+      state.foreignRoutes = foreignRoutes;
       state.tailnetFQDN = status.MagicDNSName || status.magicDNSName || "";
 
       renderItems();
@@ -343,6 +409,60 @@
     }
   };
 
+  const handleAdoptClick = async (event) => {
+    const button = event.target.closest(".adopt-btn");
+    if (!button) {
+      return;
+    }
+
+    button.disabled = true;
+    try {
+      await fetchJSON("/api/caddy/adopt", {
+        method: "POST",
+        body: JSON.stringify({ key: button.dataset.key }),
+      });
+      showAlert("success", "Route adopted; it can now be managed here");
+      await refreshData();
+    } catch (error) {
+      showAlert("danger", error.message);
+    } finally {
+      button.disabled = false;
+    }
+  };
+
+  const formatProbeReport = (report) => {
+    const steps = report.steps
+      .filter((step) => !step.skipped)
+      .map((step) => (step.ok ? `${step.name} ${step.duration_ms.toFixed(1)}ms` : `${step.name} failed: ${step.error}`));
+    const leaf = report.certificates?.[0];
+    if (leaf) {
+      steps.push(`certificate expires in ${leaf.expires_in_days} days`);
+    }
+    return `${report.address}: ${steps.join(" · ")}`;
+  };
+
+  const handleTestClick = async (event) => {
+    const button = event.target.closest(".test-btn");
+    if (!button) {
+      return;
+    }
+
+    const body = button.dataset.type === "relay" ? { relay_id: button.dataset.id } : { proxy_id: button.dataset.id };
+    button.disabled = true;
+    try {
+      const result = await fetchJSON("/api/diagnostics/probe", {
+        method: "POST",
+        body: JSON.stringify(body),
+      });
+      const lines = result.reports.map(formatProbeReport).join("<br>");
+      showAlert(result.ok ? "success" : "danger", `${result.ok ? "Test passed" : "Test failed"}<br>${lines}`);
+    } catch (error) {
+      showAlert("danger", error.message);
+    } finally {
+      button.disabled = false;
+    }
+  };
+
   const handleAutostartToggle = async (event) => {
     const toggle = event.target;
     if (!toggle.classList.contains("autostart-toggle")) {
@@ -477,6 +597,7 @@
     state.currentEditItem = proxy;
     state.currentEditType = "proxy";
     state.removeTlsCert = false; // Reset remove flag
+    state.pinnedCert = null;
     
     if (proxy) {
       // Edit mode
@@ -496,6 +617,14 @@
       } else {
         certCurrent.style.display = "none";
       }
+
+      elements.frontendTlsMode.value = proxy.frontend_tls_mode || "";
+      document.getElementById("proxy-frontend-tls-cert").value = "";
+      document.getElementById("proxy-frontend-tls-key").value = "";
+      document.getElementById("proxy-frontend-tls-current").textContent = proxy.frontend_tls_cert_file
+        ? `Current: ${proxy.frontend_tls_cert_file.split('/').pop()}`
+        : "";
+      setHealthCheckFields(proxy.health_checks || {});
     } else {
       // Add mode
       modalTitle.textContent = "Add Proxy";
@@ -504,11 +633,92 @@
       document.getElementById("proxy-autostart").checked = true;
       certFileInput.value = "";
       certCurrent.style.display = "none";
+      document.getElementById("proxy-frontend-tls-current").textContent = "";
+      setHealthCheckFields({});
     }
-    
+
+    updateFrontendTlsFields();
     modal.show();
   };
 
+  const setHealthCheckFields = (healthChecks) => {
+    document.getElementById("proxy-health-uri").value = healthChecks.uri || "";
+    document.getElementById("proxy-health-interval").value = healthChecks.interval || "";
+    document.getElementById("proxy-health-timeout").value = healthChecks.timeout || "";
+    document.getElementById("proxy-health-expect-status").value = healthChecks.expect_status || "";
+    document.getElementById("proxy-health-max-fails").value = healthChecks.max_fails || "";
+    document.getElementById("proxy-health-fail-duration").value = healthChecks.fail_duration || "";
+  };
+
+  // Health check settings from the form, keeping ones the form doesn't show
+  // (expect_body, unhealthy_latency). Returns null when nothing is set.
+  const readHealthCheckFields = (existing) => {
+    const healthChecks = {
+      ...existing,
+      uri: document.getElementById("proxy-health-uri").value.trim(),
+      interval: document.getElementById("proxy-health-interval").value.trim(),
+      timeout: document.getElementById("proxy-health-timeout").value.trim(),
+      expect_status: parseInt(document.getElementById("proxy-health-expect-status").value) || 0,
+      max_fails: parseInt(document.getElementById("proxy-health-max-fails").value) || 0,
+      fail_duration: document.getElementById("proxy-health-fail-duration").value.trim(),
+    };
+    Object.keys(healthChecks).forEach((key) => {
+      if (!healthChecks[key]) {
+        delete healthChecks[key];
+      }
+    });
+    return Object.keys(healthChecks).length ? healthChecks : null;
+  };
+
+  // Upload fields and the CA download only apply to some client TLS modes
+  const updateFrontendTlsFields = () => {
+    const mode = elements.frontendTlsMode.value;
+    document.getElementById("proxy-frontend-tls-files").style.display = mode === "load_files" ? "block" : "none";
+    document.getElementById("proxy-internal-ca-download").style.display = mode === "internal" ? "inline-block" : "none";
+  };
+
+  const trustUpstreamCert = async () => {
+    const target = document.getElementById("proxy-target").value.trim();
+    if (!target) {
+      showAlert("danger", "Please fill in the target URL");
+      return;
+    }
+
+    elements.fetchTlsCertBtn.disabled = true;
+    try {
+      const fetched = await fetchJSON("/api/caddy/tls/fetch", {
+        method: "POST",
+        body: JSON.stringify({ target }),
+      });
+      const leaf = fetched.certificates[0];
+      const confirmed = window.confirm(
+        `Trust the certificate presented by ${fetched.target}?\n\n` +
+          `Subject: ${leaf.subject}\n` +
+          `Issuer: ${leaf.issuer}\n` +
+          `Expires: ${new Date(leaf.not_after).toLocaleDateString()} (${leaf.expires_in_days} days)\n` +
+          `SHA-256: ${fetched.fingerprint}`
+      );
+      if (!confirmed) {
+        return;
+      }
+
+      const pinned = await fetchJSON("/api/caddy/tls/pin", {
+        method: "POST",
+        body: JSON.stringify({ target, fingerprint: fetched.fingerprint }),
+      });
+      state.pinnedCert = { file: pinned.tls_cert_file, fingerprint: pinned.fingerprint };
+      state.removeTlsCert = false;
+      document.getElementById("proxy-tls-cert").value = "";
+      document.getElementById("proxy-tls-cert-filename").textContent = pinned.tls_cert_file.split("/").pop();
+      document.getElementById("proxy-tls-cert-current").style.display = "flex";
+      showAlert("info", "Certificate pinned; it will be used when you save the proxy.");
+    } catch (error) {
+      showAlert("danger", error.message);
+    } finally {
+      elements.fetchTlsCertBtn.disabled = false;
+    }
+  };
+
   const saveRelay = async () => {
     const id = document.getElementById("relay-id").value;
     const listenPort = parseInt(document.getElementById("relay-listen-port").value);
@@ -558,6 +768,11 @@
     const trustedProxies = document.getElementById("proxy-trusted-proxies").checked;
     const autostart = document.getElementById("proxy-autostart").checked;
     const tlsCertFile = document.getElementById("proxy-tls-cert").files[0];
+    const frontendTlsMode = elements.frontendTlsMode.value;
+    const frontendCertFile = document.getElementById("proxy-frontend-tls-cert").files[0];
+    const frontendKeyFile = document.getElementById("proxy-frontend-tls-key").files[0];
+    const existing = state.currentEditItem;
+    const healthChecks = readHealthCheckFields((existing && existing.health_checks) || {});
 
     // Always use MagicDNS hostname (strip trailing dot)
     const hostname = state.tailnetFQDN.replace(/\.$/, '');
@@ -596,6 +811,8 @@
     formData.append("trusted_proxies", trustedProxies.toString());
     formData.append("autostart", autostart.toString());
     formData.append("enabled", "true");
+    formData.append("frontend_tls_mode", frontendTlsMode);
+    formData.append("health_checks", healthChecks ? JSON.stringify(healthChecks) : "");
 
     if (port) {
       formData.append("port", port);
@@ -610,11 +827,28 @@
       formData.append("tls_cert_upload", tlsCertFile);
     }
 
+    // Certificate pinned from the upstream, unless an upload replaces it
+    if (state.pinnedCert && !tlsCertFile) {
+      formData.append("tls", "true");
+      formData.append("tls_cert_file", state.pinnedCert.file);
+      formData.append("tls_pinned_sha256", state.pinnedCert.fingerprint);
+    }
+
     // Flag to remove existing cert
     if (state.removeTlsCert) {
       formData.append("remove_tls_cert", "true");
     }
 
+    // Certificate and key clients see, for the load_files mode
+    if (frontendTlsMode === "load_files") {
+      if (frontendCertFile) {
+        formData.append("frontend_tls_cert_upload", frontendCertFile);
+      }
+      if (frontendKeyFile) {
+        formData.append("frontend_tls_key_upload", frontendKeyFile);
+      }
+    }
+
     try {
       elements.saveProxyBtn.disabled = true;
       const url = id ? "/api/caddy/update" : "/api/caddy/create";
@@ -718,6 +952,8 @@
     elements.items.addEventListener("click", handleActionClick);
     elements.items.addEventListener("click", handleEditClick);
     elements.items.addEventListener("click", handleDeleteClick);
+    elements.items.addEventListener("click", handleAdoptClick);
+    elements.items.addEventListener("click", handleTestClick);
     elements.items.addEventListener("change", handleAutostartToggle);
 
     elements.filterRelay.addEventListener("change", () => {
@@ -776,11 +1012,20 @@
     if (elements.removeTlsCertBtn) {
       elements.removeTlsCertBtn.addEventListener("click", () => {
         state.removeTlsCert = true;
+        state.pinnedCert = null;
         document.getElementById("proxy-tls-cert-current").style.display = "none";
         showAlert("info", "Certificate will be removed when you save the proxy.");
       });
     }
 
+    if (elements.frontendTlsMode) {
+      elements.frontendTlsMode.addEventListener("change", updateFrontendTlsFields);
+    }
+
+    if (elements.fetchTlsCertBtn) {
+      elements.fetchTlsCertBtn.addEventListener("click", trustUpstreamCert);
+    }
+
     // Handle Enter key in forms
     document.getElementById("relayForm")?.addEventListener("submit", (e) => {
       e.preventDefault();
@@ -793,12 +1038,46 @@
     });
   };
 
+  const warnExpiringCertificates = async () => {
+    try {
+      const certificates = await fetchJSON("/api/certificates");
+      certificates
+        .filter((cert) => cert.status === "expiring" || cert.status === "expired")
+        .forEach((cert) => {
+          const when = new Date(cert.not_after).toLocaleDateString();
+          const verb = cert.status === "expired" ? "expired on" : "expires on";
+          showAlert("warning", `Certificate ${cert.name} ${verb} ${when}`);
+        });
+    } catch (error) {
+      showAlert("warning", error.message);
+    }
+  };
+
+  const warnTailscaleCertificates = async () => {
+    try {
+      const data = await fetchJSON("/api/tailscale/certs");
+      if (!data.enabled) return;
+      if (data.error) {
+        showAlert("warning", `Tailscale HTTPS certificates: ${data.error}`);
+      }
+      (data.certificates || [])
+        .filter((cert) => cert.error)
+        .forEach((cert) => {
+          showAlert("warning", `Tailscale HTTPS certificate for ${cert.domain}: ${cert.error}`);
+        });
+    } catch (error) {
+      showAlert("warning", error.message);
+    }
+  };
+
   const init = async () => {
     // Set theme before content loads to prevent flash
     setTheme(getPreferredTheme());
     
     bindEvents();
     await refreshData();
+    await warnExpiringCertificates();
+    await warnTailscaleCertificates();
     await loadLogs();
     startLogStream();
     setInterval(refreshData, 15000);
@@ -3,3 +3,5 @@ module github.com/sudocarlos/tailrelay-webui
 go 1.21
 
 require gopkg.in/yaml.v3 v3.0.1
+
+require golang.org/x/crypto v0.17.0
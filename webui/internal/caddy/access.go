@@ -0,0 +1,151 @@
+package caddy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// forbiddenStatus is returned to clients rejected by a proxy's IP lists
+const forbiddenStatus = 403
+
+// buildAccessRoutes builds the subroute entries that reject clients by IP.
+// Denied ranges are checked first; when an allow list is set, any client
+// outside it is rejected as well.
+func buildAccessRoutes(allow, deny []string) ([]Route, error) {
+	allowRanges, err := normalizeCIDRs(allow)
+	if err != nil {
+		return nil, fmt.Errorf("allow list: %w", err)
+	}
+	denyRanges, err := normalizeCIDRs(deny)
+	if err != nil {
+		return nil, fmt.Errorf("deny list: %w", err)
+	}
+
+	var routes []Route
+	if len(denyRanges) > 0 {
+		routes = append(routes, Route{
+			Match: []MatcherSet{
+				{ClientIP: &IPMatcher{Ranges: denyRanges}},
+			},
+			Handle:   []Handler{forbiddenHandler()},
+			Terminal: true,
+		})
+	}
+	if len(allowRanges) > 0 {
+		routes = append(routes, Route{
+			Match: []MatcherSet{
+				{Not: []MatcherSet{{ClientIP: &IPMatcher{Ranges: allowRanges}}}},
+			},
+			Handle:   []Handler{forbiddenHandler()},
+			Terminal: true,
+		})
+	}
+
+	return routes, nil
+}
+
+func forbiddenHandler() Handler {
+	return Handler{
+		"handler":     "static_response",
+		"status_code": forbiddenStatus,
+		"body":        "Forbidden",
+	}
+}
+
+// normalizeCIDRs validates IPs and CIDR ranges, turning bare IPs into host ranges
+func normalizeCIDRs(values []string) ([]string, error) {
+	var ranges []string
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if strings.Contains(value, "/") {
+			if _, _, err := net.ParseCIDR(value); err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q", value)
+			}
+			ranges = append(ranges, value)
+			continue
+		}
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP %q", value)
+		}
+		if ip.To4() != nil {
+			ranges = append(ranges, value+"/32")
+		} else {
+			ranges = append(ranges, value+"/128")
+		}
+	}
+	return ranges, nil
+}
+
+// extractAccessLists reads allow and deny ranges back from a route's subroute
+func extractAccessLists(route Route) (allow, deny []string) {
+	if len(route.Handle) == 0 || route.Handle[0]["handler"] != "subroute" {
+		return nil, nil
+	}
+	routesRaw, _ := route.Handle[0]["routes"].([]interface{})
+
+	for _, routeRaw := range routesRaw {
+		routeMap, ok := routeRaw.(map[string]interface{})
+		if !ok || !isForbiddenRoute(routeMap) {
+			continue
+		}
+		matchers, _ := routeMap["match"].([]interface{})
+		for _, matcherRaw := range matchers {
+			matcher, ok := matcherRaw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if ranges := ipMatcherRanges(matcher); len(ranges) > 0 {
+				deny = append(deny, ranges...)
+			}
+			negated, _ := matcher["not"].([]interface{})
+			for _, notRaw := range negated {
+				if notMatcher, ok := notRaw.(map[string]interface{}); ok {
+					allow = append(allow, ipMatcherRanges(notMatcher)...)
+				}
+			}
+		}
+	}
+
+	return allow, deny
+}
+
+// isForbiddenRoute reports whether a raw subroute entry only returns 403
+func isForbiddenRoute(routeMap map[string]interface{}) bool {
+	handles, _ := routeMap["handle"].([]interface{})
+	if len(handles) != 1 {
+		return false
+	}
+	handler, ok := handles[0].(map[string]interface{})
+	if !ok || handler["handler"] != "static_response" {
+		return false
+	}
+	switch status := handler["status_code"].(type) {
+	case float64:
+		return int(status) == forbiddenStatus
+	case string:
+		return status == fmt.Sprint(forbiddenStatus)
+	}
+	return false
+}
+
+func ipMatcherRanges(matcher map[string]interface{}) []string {
+	var ranges []string
+	for _, key := range []string{"client_ip", "remote_ip"} {
+		ipMatcher, ok := matcher[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		values, _ := ipMatcher["ranges"].([]interface{})
+		for _, value := range values {
+			if r, ok := value.(string); ok {
+				ranges = append(ranges, r)
+			}
+		}
+	}
+	return ranges
+}
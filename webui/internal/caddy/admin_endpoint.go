@@ -0,0 +1,69 @@
+package caddy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// unixAdminPrefix marks a unix socket admin address, as in Caddy's own
+	// "admin unix//path/to/admin.sock" syntax
+	unixAdminPrefix = "unix/"
+	// unixAdminBaseURL is the URL requests over a unix socket are addressed
+	// to; Caddy expects a loopback Host for them
+	unixAdminBaseURL = "http://127.0.0.1"
+)
+
+// AdminEndpoint is a parsed Caddy admin API address
+type AdminEndpoint struct {
+	BaseURL    string // URL requests are sent to
+	SocketPath string // Set when the admin API listens on a unix socket
+}
+
+// ParseAdminAddress accepts a unix socket ("unix//run/caddy/admin.sock"),
+// a host:port ("localhost:2019") or a full URL ("http://localhost:2019")
+func ParseAdminAddress(address string) (AdminEndpoint, error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return AdminEndpoint{BaseURL: DefaultAdminAPI}, nil
+	}
+
+	if strings.HasPrefix(address, unixAdminPrefix) {
+		socketPath := strings.TrimPrefix(address, unixAdminPrefix)
+		// Caddy allows socket permissions after a pipe, e.g. "unix//admin.sock|0220"
+		if idx := strings.LastIndex(socketPath, "|"); idx >= 0 {
+			socketPath = socketPath[:idx]
+		}
+		if socketPath == "" {
+			return AdminEndpoint{}, fmt.Errorf("admin address %q has no socket path", address)
+		}
+		return AdminEndpoint{BaseURL: unixAdminBaseURL, SocketPath: socketPath}, nil
+	}
+
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	return AdminEndpoint{BaseURL: strings.TrimRight(address, "/")}, nil
+}
+
+// newAdminHTTPClient builds the HTTP client for an admin endpoint, dialing
+// its unix socket when it has one
+func newAdminHTTPClient(endpoint AdminEndpoint) *http.Client {
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	if endpoint.SocketPath != "" {
+		socketPath := endpoint.SocketPath
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socketPath)
+			},
+		}
+	}
+	return client
+}
@@ -3,13 +3,13 @@ package caddy
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/sudocarlos/tailrelay-webui/internal/logger"
 )
@@ -26,12 +26,20 @@ type APIClient struct {
 	BaseURL         string
 	HTTPClient      *http.Client
 	MaxLogBodySize  int
+	Origin          string // Sent as the Origin header when set
+	breaker         *circuitBreaker
 }
 
-// NewAPIClient creates a new Caddy API client
-func NewAPIClient(baseURL string) *APIClient {
-	if baseURL == "" {
-		baseURL = DefaultAdminAPI
+// NewAPIClient creates a new Caddy API client. The address may be a unix
+// socket ("unix//run/caddy/admin.sock"), a host:port or a full URL.
+func NewAPIClient(address string) *APIClient {
+	endpoint, err := ParseAdminAddress(address)
+	if err != nil {
+		logger.Error("caddy", "Invalid Caddy admin address, using %s: %v", DefaultAdminAPI, err)
+		endpoint = AdminEndpoint{BaseURL: DefaultAdminAPI}
+	}
+	if endpoint.SocketPath != "" {
+		logger.Debug("caddy", "Using Caddy admin API on unix socket %s", endpoint.SocketPath)
 	}
 
 	maxLogBodySize := DefaultMaxLogBodySize
@@ -42,20 +50,60 @@ func NewAPIClient(baseURL string) *APIClient {
 	}
 
 	return &APIClient{
-		BaseURL:        baseURL,
+		BaseURL:        endpoint.BaseURL,
 		MaxLogBodySize: maxLogBodySize,
-		HTTPClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		HTTPClient:     newAdminHTTPClient(endpoint),
+		breaker:        newCircuitBreaker(),
 	}
 }
 
-// doRequestWithHeaders performs an HTTP request and returns the response body and headers
-func (c *APIClient) doRequestWithHeaders(method, path string, body interface{}) ([]byte, http.Header, error) {
+// ConflictError reports that Caddy rejected a change because the config
+// no longer matches the ETag it was based on
+type ConflictError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("config conflict on %s %s (%d): %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// IsConflict reports whether err is (or wraps) a ConflictError
+func IsConflict(err error) bool {
+	var conflict *ConflictError
+	return errors.As(err, &conflict)
+}
+
+// APIError is an error status returned by Caddy's admin API
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error %d: %s", e.StatusCode, e.Message)
+}
+
+// rawBody is a request body sent as is rather than marshaled to JSON
+type rawBody struct {
+	contentType string
+	data        []byte
+}
+
+// sendRequest performs a single HTTP request and returns the response body and headers.
+// Failures to reach Caddy are returned as unavailableError.
+func (c *APIClient) sendRequest(method, path string, body interface{}, ifMatch string) ([]byte, http.Header, error) {
 	var reqBody io.Reader
 	var bodyPreview string
 
-	if body != nil {
+	contentType := "application/json"
+	if raw, ok := body.(rawBody); ok {
+		contentType = raw.contentType
+		reqBody = bytes.NewReader(raw.data)
+		bodyPreview = c.formatBodyPreview(raw.data)
+	} else if body != nil {
 		data, err := json.Marshal(body)
 		if err != nil {
 			logger.Error("caddy", "Failed to marshal request body: %v", err)
@@ -78,20 +126,26 @@ func (c *APIClient) doRequestWithHeaders(method, path string, body interface{})
 	}
 
 	if body != nil {
-		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Type", contentType)
+	}
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	if c.Origin != "" {
+		req.Header.Set("Origin", c.Origin)
 	}
 
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
 		logger.Error("caddy", "HTTP request failed for %s %s: %v", method, url, err)
-		return nil, nil, fmt.Errorf("execute request: %w", err)
+		return nil, nil, &unavailableError{err: fmt.Errorf("execute request: %w", err)}
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
 		logger.Error("caddy", "Failed to read response body from %s %s: %v", method, url, err)
-		return nil, nil, fmt.Errorf("read response: %w", err)
+		return nil, nil, &unavailableError{err: fmt.Errorf("read response: %w", err)}
 	}
 
 	// Log response status and body preview
@@ -101,9 +155,25 @@ func (c *APIClient) doRequestWithHeaders(method, path string, body interface{})
 		logger.Debug("caddy", "Response body: %s", respPreview)
 	}
 
+	// Caddy answers a stale If-Match with 412. A 409 only means the same on a
+	// request that carried If-Match; otherwise it is e.g. "key already exists".
+	if resp.StatusCode == http.StatusPreconditionFailed || (resp.StatusCode == http.StatusConflict && ifMatch != "") {
+		logger.Warn("caddy", "Caddy API conflict %d for %s %s: %s", resp.StatusCode, method, url, string(respBody))
+		return nil, nil, &ConflictError{
+			Method:     method,
+			Path:       path,
+			StatusCode: resp.StatusCode,
+			Message:    strings.TrimSpace(string(respBody)),
+		}
+	}
+
 	if resp.StatusCode >= 400 {
 		logger.Error("caddy", "Caddy API error %d for %s %s: %s", resp.StatusCode, method, url, string(respBody))
-		return nil, nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(respBody))
+		apiErr := &APIError{StatusCode: resp.StatusCode, Message: string(respBody)}
+		if unavailableStatus(resp.StatusCode) {
+			return nil, nil, &unavailableError{err: apiErr}
+		}
+		return nil, nil, apiErr
 	}
 
 	return respBody, resp.Header, nil
@@ -127,7 +197,7 @@ func (c *APIClient) formatBodyPreview(data []byte) string {
 
 // doRequest performs an HTTP request and returns the response body
 func (c *APIClient) doRequest(method, path string, body interface{}) ([]byte, error) {
-	respBody, _, err := c.doRequestWithHeaders(method, path, body)
+	respBody, _, err := c.doRequestWithHeaders(method, path, body, "")
 	return respBody, err
 }
 
@@ -143,6 +213,44 @@ func (c *APIClient) GetConfig(path string) (json.RawMessage, error) {
 	return json.RawMessage(data), nil
 }
 
+// GetConfigWithETag retrieves configuration along with the ETag Caddy
+// reports for it, for use with the IfMatch variants
+func (c *APIClient) GetConfigWithETag(path string) (json.RawMessage, string, error) {
+	if path == "" {
+		path = "/"
+	}
+	data, headers, err := c.doRequestWithHeaders("GET", "/config"+path, nil, "")
+	if err != nil {
+		return nil, "", err
+	}
+	return json.RawMessage(data), headers.Get("Etag"), nil
+}
+
+// PostConfigIfMatch is PostConfig that fails with a ConflictError when the
+// config changed since etag was read
+func (c *APIClient) PostConfigIfMatch(path string, config interface{}, etag string) error {
+	_, _, err := c.doRequestWithHeaders("POST", "/config"+path, config, etag)
+	return err
+}
+
+// PatchConfigIfMatch is PatchConfig guarded by an ETag
+func (c *APIClient) PatchConfigIfMatch(path string, config interface{}, etag string) error {
+	_, _, err := c.doRequestWithHeaders("PATCH", "/config"+path, config, etag)
+	return err
+}
+
+// PutConfigIfMatch is PutConfig guarded by an ETag
+func (c *APIClient) PutConfigIfMatch(path string, config interface{}, etag string) error {
+	_, _, err := c.doRequestWithHeaders("PUT", "/config"+path, config, etag)
+	return err
+}
+
+// DeleteConfigIfMatch is DeleteConfig guarded by an ETag
+func (c *APIClient) DeleteConfigIfMatch(path string, etag string) error {
+	_, _, err := c.doRequestWithHeaders("DELETE", "/config"+path, nil, etag)
+	return err
+}
+
 // PostConfig adds or appends to configuration at the specified path
 // For arrays, this appends. For objects, this creates or replaces.
 func (c *APIClient) PostConfig(path string, config interface{}) error {
@@ -152,7 +260,7 @@ func (c *APIClient) PostConfig(path string, config interface{}) error {
 
 // PostConfigWithLocation adds or appends to configuration and returns Location header
 func (c *APIClient) PostConfigWithLocation(path string, config interface{}) (string, string, error) {
-	respBody, headers, err := c.doRequestWithHeaders("POST", "/config"+path, config)
+	respBody, headers, err := c.doRequestWithHeaders("POST", "/config"+path, config, "")
 	if err != nil {
 		return "", "", err
 	}
@@ -269,6 +377,21 @@ func (c *APIClient) LoadConfig(config interface{}) error {
 	return err
 }
 
+// Adapt converts a Caddyfile to Caddy's JSON config without loading it
+func (c *APIClient) Adapt(caddyfile string) (*AdaptResult, error) {
+	data, err := c.doRequest("POST", "/adapt", rawBody{contentType: "text/caddyfile", data: []byte(caddyfile)})
+	if err != nil {
+		return nil, err
+	}
+
+	var result AdaptResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("unmarshal adapted config: %w", err)
+	}
+
+	return &result, nil
+}
+
 // GetReverseProxyUpstreams returns the status of all reverse proxy upstreams
 func (c *APIClient) GetReverseProxyUpstreams() ([]UpstreamStatus, error) {
 	data, err := c.doRequest("GET", "/reverse_proxy/upstreams", nil)
@@ -284,6 +407,22 @@ func (c *APIClient) GetReverseProxyUpstreams() ([]UpstreamStatus, error) {
 	return upstreams, nil
 }
 
+// GetPKICA returns one of Caddy's certificate authorities, e.g. "local"
+// for the internal CA. Caddy only runs it once a config uses it.
+func (c *APIClient) GetPKICA(id string) (*PKICA, error) {
+	data, err := c.doRequest("GET", "/pki/ca/"+id, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var ca PKICA
+	if err := json.Unmarshal(data, &ca); err != nil {
+		return nil, fmt.Errorf("unmarshal CA: %w", err)
+	}
+
+	return &ca, nil
+}
+
 // DiscoverServerName discovers the first HTTP server name from Caddy config
 // Returns the first server name found, or empty string if none exist
 func (c *APIClient) DiscoverServerName() (string, error) {
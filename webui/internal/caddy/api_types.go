@@ -1,5 +1,7 @@
 package caddy
 
+import "encoding/json"
+
 // CaddyConfig represents the root Caddy configuration
 type CaddyConfig struct {
 	Apps CaddyApps `json:"apps"`
@@ -34,8 +36,20 @@ type Route struct {
 
 // MatcherSet represents a set of matchers (all must match)
 type MatcherSet struct {
-	Host []string `json:"host,omitempty"`
-	Path []string `json:"path,omitempty"`
+	Host     []string     `json:"host,omitempty"`
+	Path     []string     `json:"path,omitempty"`
+	ClientIP *IPMatcher   `json:"client_ip,omitempty"`
+	RemoteIP *IPMatcher   `json:"remote_ip,omitempty"`
+	Not      []MatcherSet `json:"not,omitempty"`
+
+	// extra holds matchers without a field above (e.g. "method"), so they
+	// survive decoding and re-encoding
+	extra map[string]interface{}
+}
+
+// IPMatcher matches requests by client or remote IP ranges
+type IPMatcher struct {
+	Ranges []string `json:"ranges"`
 }
 
 // Handler represents a Caddy handler (interface type)
@@ -100,11 +114,18 @@ type HeaderConfig struct {
 	Response *HeaderOps `json:"response,omitempty"`
 }
 
-// HeaderOps represents header operations (add, set, delete)
+// HeaderOps represents header operations (add, set, delete, replace)
 type HeaderOps struct {
-	Add    map[string][]string `json:"add,omitempty"`
-	Set    map[string][]string `json:"set,omitempty"`
-	Delete []string            `json:"delete,omitempty"`
+	Add     map[string][]string            `json:"add,omitempty"`
+	Set     map[string][]string            `json:"set,omitempty"`
+	Delete  []string                       `json:"delete,omitempty"`
+	Replace map[string][]HeaderReplacement `json:"replace,omitempty"`
+}
+
+// HeaderReplacement represents a substring replacement within a header value
+type HeaderReplacement struct {
+	Search  string `json:"search,omitempty"`
+	Replace string `json:"replace,omitempty"`
 }
 
 // HeaderOperation represents a single header operation
@@ -169,8 +190,10 @@ type TLSAutomation struct {
 
 // TLSPolicy represents a TLS automation policy
 type TLSPolicy struct {
-	Subjects []string    `json:"subjects,omitempty"`
-	Issuers  []TLSIssuer `json:"issuers,omitempty"`
+	ID             string           `json:"@id,omitempty"`
+	Subjects       []string         `json:"subjects,omitempty"`
+	Issuers        []TLSIssuer      `json:"issuers,omitempty"`
+	GetCertificate []TLSCertManager `json:"get_certificate,omitempty"`
 }
 
 // TLSIssuer represents a certificate issuer configuration
@@ -179,6 +202,22 @@ type TLSIssuer struct {
 	Config map[string]interface{} `json:"config,omitempty"`
 }
 
+// MarshalJSON writes the issuer the way Caddy expects it, with its config
+// inline next to the module name
+func (i TLSIssuer) MarshalJSON() ([]byte, error) {
+	issuer := make(map[string]interface{}, len(i.Config)+1)
+	for key, value := range i.Config {
+		issuer[key] = value
+	}
+	issuer["module"] = i.Module
+	return json.Marshal(issuer)
+}
+
+// TLSCertManager represents a get_certificate module, e.g. {"via": "tailscale"}
+type TLSCertManager struct {
+	Via string `json:"via"`
+}
+
 // TLSCertificates represents TLS certificate configuration
 type TLSCertificates struct {
 	LoadFiles []TLSCertificateFile `json:"load_files,omitempty"`
@@ -190,3 +229,27 @@ type TLSCertificateFile struct {
 	Key         string   `json:"key"`
 	Tags        []string `json:"tags,omitempty"`
 }
+
+// PKICA describes one of Caddy's certificate authorities
+type PKICA struct {
+	ID                      string `json:"id"`
+	Name                    string `json:"name"`
+	RootCommonName          string `json:"root_common_name"`
+	IntermediateCommonName  string `json:"intermediate_common_name"`
+	RootCertificate         string `json:"root_certificate"`         // PEM
+	IntermediateCertificate string `json:"intermediate_certificate"` // PEM
+}
+
+// AdaptResult is a Caddyfile adapted to Caddy's JSON config by /adapt
+type AdaptResult struct {
+	Result   json.RawMessage `json:"result"`
+	Warnings []AdaptWarning  `json:"warnings,omitempty"`
+}
+
+// AdaptWarning is something the Caddyfile adapter flagged but accepted
+type AdaptWarning struct {
+	File      string `json:"file,omitempty"`
+	Line      int    `json:"line,omitempty"`
+	Directive string `json:"directive,omitempty"`
+	Message   string `json:"message"`
+}
@@ -0,0 +1,388 @@
+package caddy
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/config"
+	"github.com/sudocarlos/tailrelay-webui/internal/logger"
+)
+
+// commitProxies makes a new set of proxies live and only then saves it as
+// metadata, so a failed apply leaves both Caddy and metadata untouched.
+// Callers must hold applyMu across loading, changing and committing metadata.
+func (pm *ProxyManager) commitProxies(proxies []config.CaddyProxy) error {
+	return pm.commitProxiesClaiming(proxies, nil)
+}
+
+// commitProxiesClaiming is commitProxies that also removes the claimed
+// foreign routes (by ForeignRoute key), for proxies adopted from them
+func (pm *ProxyManager) commitProxiesClaiming(proxies []config.CaddyProxy, claimed map[string]bool) error {
+	for i := range proxies {
+		normalizeExtra(&proxies[i])
+	}
+	if err := pm.applyProxies(proxies, claimed); err != nil {
+		return err
+	}
+	if err := SaveProxyMetadata(pm.metadataPath, proxies); err != nil {
+		logger.Error("caddy", "Failed to save proxy metadata after apply: %v", err)
+		return fmt.Errorf("save metadata: %w", err)
+	}
+	return nil
+}
+
+// maxApplyAttempts bounds how often an apply is re-rendered after losing a
+// race with another writer to the Caddy config
+const maxApplyAttempts = 3
+
+// applyProxies renders the complete desired Caddy config for a set of
+// proxies, replaces the live config in one request, and verifies every route
+// landed. The replace is guarded by the snapshot's ETag; if someone else
+// changed the config in between, the apply is re-rendered on top of their
+// change. If the replace or the verification fails the snapshot is restored.
+func (pm *ProxyManager) applyProxies(proxies []config.CaddyProxy, claimed map[string]bool) error {
+	var err error
+	for attempt := 1; attempt <= maxApplyAttempts; attempt++ {
+		err = pm.applyProxiesOnce(proxies, claimed)
+		if err == nil || !IsConflict(err) {
+			return err
+		}
+		logger.Warn("caddy", "Caddy config changed during apply (attempt %d/%d), retrying", attempt, maxApplyAttempts)
+	}
+	return err
+}
+
+func (pm *ProxyManager) applyProxiesOnce(proxies []config.CaddyProxy, claimed map[string]bool) error {
+	snapshot, etag, err := pm.client.GetConfigWithETag("/")
+	if err != nil {
+		return fmt.Errorf("snapshot config: %w", err)
+	}
+
+	current := make(map[string]interface{})
+	if trimmed := strings.TrimSpace(string(snapshot)); trimmed != "" && trimmed != "null" {
+		if err := json.Unmarshal(snapshot, &current); err != nil {
+			return fmt.Errorf("unmarshal config: %w", err)
+		}
+	}
+
+	owned := pm.ownedProxyIDs(proxies)
+	layout, listens, err := pm.renderConfig(current, proxies, owned, claimed)
+	if err != nil {
+		return fmt.Errorf("render config: %w", err)
+	}
+
+	logger.Debug("caddy", "Loading rendered config with %d proxies", len(proxies))
+	if etag != "" {
+		// POST to the config root replaces it whole, and unlike /load honours If-Match
+		err = pm.client.PostConfigIfMatch("/", current, etag)
+	} else {
+		err = pm.client.LoadConfig(current)
+	}
+	if err != nil {
+		if IsConflict(err) {
+			// Nothing was written, so there is nothing to roll back
+			return fmt.Errorf("load config: %w", err)
+		}
+		logger.Error("caddy", "Failed to load rendered config: %v", err)
+		pm.rollback(snapshot)
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	if err := pm.verifyApplied(proxies, owned); err != nil {
+		logger.Error("caddy", "Rendered config failed verification: %v", err)
+		pm.rollback(snapshot)
+		return fmt.Errorf("verify config: %w", err)
+	}
+
+	pm.recordLayout(layout, listens)
+	return nil
+}
+
+// rollback restores a config snapshot taken before an apply
+func (pm *ProxyManager) rollback(snapshot json.RawMessage) {
+	var previous interface{}
+	if err := json.Unmarshal(snapshot, &previous); err != nil || previous == nil {
+		previous = map[string]interface{}{}
+	}
+	if err := pm.client.LoadConfig(previous); err != nil {
+		logger.Error("caddy", "Failed to roll back to previous config: %v", err)
+		return
+	}
+	logger.Warn("caddy", "Rolled back Caddy to the previous config")
+}
+
+// renderConfig rewrites the HTTP servers of a full Caddy config in place so
+// they hold exactly the enabled proxies. Routes of owned proxies (and claimed
+// foreign routes) are removed first, then each enabled proxy is placed in the
+// server listening on its port; servers tailrelay created that are emptied by
+// this are dropped. The TLS app's managed automation policies and load_files
+// entries are replaced too. Everything else in the config is kept as-is.
+func (pm *ProxyManager) renderConfig(cfg map[string]interface{}, proxies []config.CaddyProxy, owned, claimed map[string]bool) (map[string]RouteLocation, map[string]string, error) {
+	servers := childMap(childMap(childMap(cfg, "apps"), "http"), "servers")
+
+	emptied := make(map[string]bool)
+	unclaimed := make(map[string]bool, len(claimed))
+	for key := range claimed {
+		unclaimed[key] = true
+	}
+	for name, serverRaw := range servers {
+		server, ok := serverRaw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		routes, _ := server["routes"].([]interface{})
+		kept := make([]interface{}, 0, len(routes))
+		for _, routeRaw := range routes {
+			if route, ok := decodeRoute(routeRaw); ok {
+				if routeProxyID(route, owned) != "" {
+					continue
+				}
+				if key := foreignRouteKey(name, route); claimed[key] {
+					delete(unclaimed, key)
+					continue
+				}
+			}
+			kept = append(kept, routeRaw)
+		}
+		if len(routes) > 0 && len(kept) == 0 && pm.ownsServer(name) {
+			emptied[name] = true
+		}
+		server["routes"] = kept
+	}
+	for key := range unclaimed {
+		// A claimed route's content is part of its key, so it changed or went away
+		return nil, nil, fmt.Errorf("%w: %s", ErrForeignRouteNotFound, key)
+	}
+
+	if err := validateFrontendTLSSet(proxies); err != nil {
+		return nil, nil, err
+	}
+
+	layout := make(map[string]RouteLocation)
+	listens := make(map[string]string)
+	placed := make(map[string][]config.CaddyProxy)
+	for _, proxy := range proxies {
+		if !proxy.Enabled {
+			continue
+		}
+
+		route, err := pm.buildRoute(proxy)
+		if err != nil {
+			return nil, nil, fmt.Errorf("proxy %s: %w", proxy.ID, err)
+		}
+		if err := validateFrontendTLS(proxy); err != nil {
+			return nil, nil, fmt.Errorf("proxy %s: %w", proxy.ID, err)
+		}
+
+		listen := listenAddress(proxy.Port)
+		serverName := pm.renderedServerForListen(servers, proxy.Port)
+		if serverName == "" {
+			serverName = pm.nextServerName(servers)
+			servers[serverName] = map[string]interface{}{
+				"listen": []interface{}{listen},
+				"routes": []interface{}{},
+			}
+		}
+
+		server := servers[serverName].(map[string]interface{})
+		routes, _ := server["routes"].([]interface{})
+		server["routes"] = insertRoute(routes, route)
+
+		layout[proxy.ID] = RouteLocation{Server: serverName, RouteID: route.ID}
+		listens[listen] = serverName
+		placed[serverName] = append(placed[serverName], proxy)
+		delete(emptied, serverName)
+	}
+
+	for name := range emptied {
+		delete(servers, name)
+	}
+	if err := pm.renderPlainHTTP(servers, placed); err != nil {
+		return nil, nil, err
+	}
+	renderTLSPolicies(cfg, proxies)
+	pm.renderCertificates(cfg, proxies)
+
+	return layout, listens, nil
+}
+
+// verifyApplied checks that every enabled proxy is served on its port and
+// that no other owned proxy still has a route
+func (pm *ProxyManager) verifyApplied(proxies []config.CaddyProxy, owned map[string]bool) error {
+	servers, err := pm.listServers()
+	if err != nil {
+		return fmt.Errorf("list servers: %w", err)
+	}
+
+	enabled := make(map[string]config.CaddyProxy)
+	for _, proxy := range proxies {
+		if proxy.Enabled {
+			enabled[proxy.ID] = proxy
+		}
+	}
+
+	var problems []string
+	for id := range owned {
+		proxy, wanted := enabled[id]
+		live := false
+		for _, server := range servers {
+			if !serverHasProxyRoute(server, id) {
+				continue
+			}
+			live = true
+			if wanted && !serverListensOn(server, proxy.Port) {
+				problems = append(problems, fmt.Sprintf("proxy %s is not listening on port %d", id, proxy.Port))
+			}
+		}
+		if wanted && !live {
+			problems = append(problems, fmt.Sprintf("proxy %s is missing", id))
+		}
+		if !wanted && live {
+			problems = append(problems, fmt.Sprintf("proxy %s should not be live", id))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// ownedProxyIDs lists every proxy the web UI manages: the ones being applied
+// plus any still in stored metadata or the server map, so routes of removed
+// proxies are cleaned up too
+func (pm *ProxyManager) ownedProxyIDs(proxies []config.CaddyProxy) map[string]bool {
+	owned := make(map[string]bool)
+	for _, proxy := range proxies {
+		owned[proxy.ID] = true
+	}
+	if stored, err := LoadProxyMetadata(pm.metadataPath); err == nil {
+		for _, proxy := range stored {
+			owned[proxy.ID] = true
+		}
+	}
+
+	pm.mapMu.Lock()
+	for id := range pm.serverMap.ByProxyID {
+		owned[id] = true
+	}
+	pm.mapMu.Unlock()
+
+	delete(owned, "")
+	return owned
+}
+
+// renderedServerForListen finds the rendered server listening on a port,
+// preferring the one recorded in the server map
+func (pm *ProxyManager) renderedServerForListen(servers map[string]interface{}, port int) string {
+	pm.mapMu.Lock()
+	mapped := pm.serverMap.ByListen[listenAddress(port)]
+	pm.mapMu.Unlock()
+
+	listensOn := func(name string) bool {
+		server, ok := servers[name].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		listenRaw, _ := server["listen"].([]interface{})
+		for _, addrRaw := range listenRaw {
+			addr, _ := addrRaw.(string)
+			if listenPort, ok := parseListenPort([]string{addr}); ok && listenPort == port {
+				return true
+			}
+		}
+		return false
+	}
+
+	if mapped != "" && listensOn(mapped) {
+		return mapped
+	}
+
+	names := make([]string, 0, len(servers))
+	for name := range servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if listensOn(name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// nextServerName picks an unused tailrelay server name for a rendered config
+func (pm *ProxyManager) nextServerName(servers map[string]interface{}) string {
+	pm.mapMu.Lock()
+	defer pm.mapMu.Unlock()
+
+	for i := pm.serverMap.NextIndex; ; i++ {
+		candidate := ownedServerName(i)
+		if _, taken := servers[candidate]; !taken {
+			pm.serverMap.NextIndex = i + 1
+			return candidate
+		}
+	}
+}
+
+// recordLayout replaces the server map with the layout of an applied config
+func (pm *ProxyManager) recordLayout(layout map[string]RouteLocation, listens map[string]string) {
+	pm.mapMu.Lock()
+	defer pm.mapMu.Unlock()
+
+	pm.serverMap.ByProxyID = layout
+	pm.serverMap.ByListen = listens
+
+	if err := SaveServerMap(pm.serverMapPath, pm.serverMap); err != nil {
+		logger.Error("caddy", "Failed to save server map: %v", err)
+	}
+}
+
+// insertRoute adds a route ahead of any catch-all routes so they don't
+// shadow host-matched ones
+func insertRoute(routes []interface{}, route *Route) []interface{} {
+	insertAt := len(routes)
+	for i, routeRaw := range routes {
+		if existing, ok := decodeRoute(routeRaw); ok && !routeHasHostMatcher(existing) {
+			insertAt = i
+			break
+		}
+	}
+	return append(routes[:insertAt], append([]interface{}{route}, routes[insertAt:]...)...)
+}
+
+// routeProxyID returns which of the known proxies a route belongs to, if any
+func routeProxyID(route Route, known map[string]bool) string {
+	for id := range known {
+		if routeHasID(route, id) {
+			return id
+		}
+	}
+	return ""
+}
+
+// decodeRoute converts a raw config value into a Route
+func decodeRoute(raw interface{}) (Route, bool) {
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return Route{}, false
+	}
+	var route Route
+	if err := json.Unmarshal(data, &route); err != nil {
+		return Route{}, false
+	}
+	return route, true
+}
+
+// childMap returns m[key] as a map, creating it when missing
+func childMap(m map[string]interface{}, key string) map[string]interface{} {
+	child, ok := m[key].(map[string]interface{})
+	if !ok {
+		child = make(map[string]interface{})
+		m[key] = child
+	}
+	return child
+}
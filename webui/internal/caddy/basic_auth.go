@@ -0,0 +1,222 @@
+package caddy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/config"
+	"github.com/sudocarlos/tailrelay-webui/internal/logger"
+)
+
+// basicAuthRealm is the realm Caddy presents in the WWW-Authenticate challenge
+const basicAuthRealm = "tailrelay"
+
+// redactedPasswordHash stands in for password hashes left out of exports.
+// It isn't a valid bcrypt hash, so importing it fails instead of leaving a
+// site without auth.
+const redactedPasswordHash = "REDACTED"
+
+// passwordHashFingerprint identifies a password hash without revealing it
+func passwordHashFingerprint(hash string) string {
+	sum := sha256.Sum256([]byte(hash))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+// maxPasswordBytes is the longest password bcrypt accepts
+const maxPasswordBytes = 72
+
+var (
+	// ErrInvalidBasicAuthUser is returned for an account that can't be stored
+	ErrInvalidBasicAuthUser = errors.New("invalid basic auth user")
+	// ErrBasicAuthUserNotFound is returned when deleting an unknown account
+	ErrBasicAuthUserNotFound = errors.New("basic auth user not found")
+)
+
+// SetBasicAuthUser adds a basic auth account to a proxy, or changes the
+// password of an existing one. The password is hashed with bcrypt before
+// it is stored or sent to Caddy.
+func (pm *ProxyManager) SetBasicAuthUser(id, username, password string) error {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return fmt.Errorf("%w: username is required", ErrInvalidBasicAuthUser)
+	}
+	if strings.Contains(username, ":") {
+		return fmt.Errorf("%w: username must not contain ':'", ErrInvalidBasicAuthUser)
+	}
+	if password == "" {
+		return fmt.Errorf("%w: password is required", ErrInvalidBasicAuthUser)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("%w: password must be at most %d bytes", ErrInvalidBasicAuthUser, maxPasswordBytes)
+	}
+
+	// Hash before taking the apply lock; bcrypt is slow on purpose
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+
+	logger.Info("caddy", "Setting basic auth user %s on proxy %s", username, id)
+	return pm.updateBasicAuth(id, func(proxy *config.CaddyProxy) error {
+		for i := range proxy.BasicAuth {
+			if proxy.BasicAuth[i].Username == username {
+				proxy.BasicAuth[i].PasswordHash = string(hash)
+				return nil
+			}
+		}
+		proxy.BasicAuth = append(proxy.BasicAuth, config.CaddyBasicAuthUser{
+			Username:     username,
+			PasswordHash: string(hash),
+		})
+		return nil
+	})
+}
+
+// DeleteBasicAuthUser removes a basic auth account from a proxy
+func (pm *ProxyManager) DeleteBasicAuthUser(id, username string) error {
+	logger.Info("caddy", "Removing basic auth user %s from proxy %s", username, id)
+	return pm.updateBasicAuth(id, func(proxy *config.CaddyProxy) error {
+		users := make([]config.CaddyBasicAuthUser, 0, len(proxy.BasicAuth))
+		for _, user := range proxy.BasicAuth {
+			if user.Username != username {
+				users = append(users, user)
+			}
+		}
+		if len(users) == len(proxy.BasicAuth) {
+			return fmt.Errorf("%w: %s", ErrBasicAuthUserNotFound, username)
+		}
+		proxy.BasicAuth = users
+		return nil
+	})
+}
+
+// updateBasicAuth changes a proxy's accounts and applies the result. The
+// whole read-modify-write runs under the apply lock, so concurrent changes
+// to the same proxy can't drop each other's accounts.
+func (pm *ProxyManager) updateBasicAuth(id string, change func(proxy *config.CaddyProxy) error) error {
+	pm.applyMu.Lock()
+	defer pm.applyMu.Unlock()
+
+	proxies, err := LoadProxyMetadata(pm.metadataPath)
+	if err != nil {
+		return fmt.Errorf("load metadata: %w", err)
+	}
+
+	var proxy *config.CaddyProxy
+	for i := range proxies {
+		if proxies[i].ID == id {
+			proxy = &proxies[i]
+			break
+		}
+	}
+	if proxy == nil {
+		return fmt.Errorf("proxy with ID %s not found", id)
+	}
+
+	if err := change(proxy); err != nil {
+		return err
+	}
+	if _, err := pm.buildRoute(*proxy); err != nil {
+		return fmt.Errorf("build route: %w", err)
+	}
+
+	if err := pm.commitProxies(proxies); err != nil {
+		logger.Error("caddy", "Failed to update basic auth of proxy %s: %v", id, err)
+		return fmt.Errorf("apply proxies: %w", err)
+	}
+	return nil
+}
+
+// buildBasicAuthHandler builds Caddy's authentication handler for a proxy's accounts
+func buildBasicAuthHandler(users []config.CaddyBasicAuthUser) (Handler, error) {
+	accounts := make([]map[string]string, 0, len(users))
+	for _, user := range users {
+		if user.Username == "" {
+			return nil, fmt.Errorf("basic auth user is missing a username")
+		}
+		if _, err := bcrypt.Cost([]byte(user.PasswordHash)); err != nil {
+			return nil, fmt.Errorf("basic auth user %s: password hash is not bcrypt", user.Username)
+		}
+		accounts = append(accounts, map[string]string{
+			"username": user.Username,
+			"password": user.PasswordHash,
+		})
+	}
+
+	return Handler{
+		"handler": "authentication",
+		"providers": map[string]interface{}{
+			"http_basic": map[string]interface{}{
+				"hash":     map[string]string{"algorithm": "bcrypt"},
+				"accounts": accounts,
+				"realm":    basicAuthRealm,
+			},
+		},
+	}, nil
+}
+
+// extractBasicAuthUsers reads basic auth accounts from a route's subroute
+func extractBasicAuthUsers(route Route) []config.CaddyBasicAuthUser {
+	for _, handleMap := range subrouteHandlers(route) {
+		if handleMap["handler"] != "authentication" {
+			continue
+		}
+		providers, _ := handleMap["providers"].(map[string]interface{})
+		httpBasic, ok := providers["http_basic"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		accounts, _ := httpBasic["accounts"].([]interface{})
+
+		var users []config.CaddyBasicAuthUser
+		for _, accountRaw := range accounts {
+			account, ok := accountRaw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			username, _ := account["username"].(string)
+			password, _ := account["password"].(string)
+			users = append(users, config.CaddyBasicAuthUser{
+				Username:     username,
+				PasswordHash: password,
+			})
+		}
+		return users
+	}
+
+	return nil
+}
+
+// subrouteHandlers flattens the handlers of every route inside a route's subroute
+func subrouteHandlers(route Route) []map[string]interface{} {
+	if len(route.Handle) == 0 || route.Handle[0]["handler"] != "subroute" {
+		return nil
+	}
+	routesRaw, ok := route.Handle[0]["routes"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var handlers []map[string]interface{}
+	for _, routeRaw := range routesRaw {
+		routeMap, ok := routeRaw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		handlesRaw, ok := routeMap["handle"].([]interface{})
+		if !ok {
+			continue
+		}
+		for _, handleRaw := range handlesRaw {
+			if handleMap, ok := handleRaw.(map[string]interface{}); ok {
+				handlers = append(handlers, handleMap)
+			}
+		}
+	}
+	return handlers
+}
@@ -4,63 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"strings"
 
 	"github.com/sudocarlos/tailrelay-webui/internal/config"
 )
 
-// GenerateCaddyfile generates a Caddyfile from proxy configurations
-func GenerateCaddyfile(proxies []config.CaddyProxy, outputPath string) error {
-	var sb strings.Builder
-
-	sb.WriteString("# Generated by Tailrelay Web UI\n")
-	sb.WriteString("# Do not edit manually - changes will be overwritten\n\n")
-
-	for _, proxy := range proxies {
-		if !proxy.Enabled {
-			continue // Skip disabled proxies
-		}
-
-		// Site block: hostname:port
-		sb.WriteString(fmt.Sprintf("%s:%d {\n", proxy.Hostname, proxy.Port))
-
-		// Reverse proxy directive
-		sb.WriteString(fmt.Sprintf("\treverse_proxy %s {\n", proxy.Target))
-
-		// Header passthrough
-		sb.WriteString("\t\theader_up Host {upstream_hostport}\n")
-
-		// Trusted proxies if enabled
-		if proxy.TrustedProxies {
-			sb.WriteString("\t\ttrusted_proxies private_ranges\n")
-		}
-
-		// Custom headers
-		if len(proxy.CustomHeaders) > 0 {
-			for key, value := range proxy.CustomHeaders {
-				sb.WriteString(fmt.Sprintf("\t\theader_up %s %s\n", key, value))
-			}
-		}
-
-		// TLS configuration for HTTPS targets
-		if strings.HasPrefix(proxy.Target, "https://") {
-			sb.WriteString("\t\ttransport http {\n")
-			sb.WriteString("\t\t\ttls_insecure_skip_verify\n")
-			sb.WriteString("\t\t}\n")
-		}
-
-		sb.WriteString("\t}\n")
-		sb.WriteString("}\n\n")
-	}
-
-	// Write to file
-	if err := os.WriteFile(outputPath, []byte(sb.String()), 0644); err != nil {
-		return fmt.Errorf("failed to write Caddyfile: %w", err)
-	}
-
-	return nil
-}
-
 // LoadProxies loads proxy configurations from JSON file
 func LoadProxies(filePath string) ([]config.CaddyProxy, error) {
 	data, err := os.ReadFile(filePath)
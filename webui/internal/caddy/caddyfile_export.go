@@ -0,0 +1,367 @@
+package caddy
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/config"
+)
+
+// caddyfileWriter builds an indented Caddyfile
+type caddyfileWriter struct {
+	sb     strings.Builder
+	depth  int
+	hashes bool // Write basic_auth password hashes rather than a placeholder
+}
+
+// line writes one directive made of the given tokens
+func (w *caddyfileWriter) line(tokens ...string) {
+	w.sb.WriteString(strings.Repeat("\t", w.depth))
+	w.sb.WriteString(strings.Join(tokens, " "))
+	w.sb.WriteString("\n")
+}
+
+// open writes a directive that starts a block
+func (w *caddyfileWriter) open(tokens ...string) {
+	w.line(append(tokens, "{")...)
+	w.depth++
+}
+
+func (w *caddyfileWriter) close() {
+	w.depth--
+	w.line("}")
+}
+
+// caddyfileToken quotes a value when it would not survive as a bare token
+func caddyfileToken(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\n\"`#") {
+		return value
+	}
+	return strconv.Quote(value)
+}
+
+// RenderCaddyfile renders the enabled proxies as Caddyfile site blocks, in
+// the format of Caddyfile.example. Basic auth password hashes are only
+// included when passwordHashes is set.
+func (pm *ProxyManager) RenderCaddyfile(proxies []config.CaddyProxy, passwordHashes bool) (string, error) {
+	w := &caddyfileWriter{hashes: passwordHashes}
+	w.line("# Generated by Tailrelay Web UI")
+	w.line("# Only enabled proxies are included")
+	if !passwordHashes {
+		w.line("# Basic auth password hashes are replaced by " + redactedPasswordHash + "; set them before importing")
+	}
+
+	for _, proxy := range proxies {
+		if !proxy.Enabled {
+			continue
+		}
+		// Catch settings Caddy would reject before describing them
+		if _, err := pm.buildRoute(proxy); err != nil {
+			return "", fmt.Errorf("proxy %s: %w", proxy.ID, err)
+		}
+
+		w.line()
+		w.line("# " + proxy.ID)
+		if proxy.Advanced {
+			w.line("# Has settings the web UI doesn't model; they are not included below")
+		}
+		address := fmt.Sprintf("%s:%d", NormalizeHostname(proxy.Hostname), proxy.Port)
+		switch FrontendTLSMode(proxy) {
+		case config.FrontendTLSHTTP:
+			w.open("http://" + address)
+		case config.FrontendTLSInternal:
+			w.open(address)
+			w.line("tls", "internal")
+		case config.FrontendTLSLoadFiles:
+			w.open(address)
+			w.line("tls", caddyfileToken(proxy.FrontendTLSCertFile), caddyfileToken(proxy.FrontendTLSKeyFile))
+		default:
+			w.open(address)
+		}
+		if err := pm.renderSiteBody(w, proxy); err != nil {
+			return "", fmt.Errorf("proxy %s: %w", proxy.ID, err)
+		}
+		w.close()
+	}
+
+	return w.sb.String(), nil
+}
+
+// renderSiteBody writes a proxy's handlers. Anything beyond a single
+// reverse_proxy is wrapped in a route block so Caddy keeps the order of
+// buildRoute instead of its default directive order.
+func (pm *ProxyManager) renderSiteBody(w *caddyfileWriter, proxy config.CaddyProxy) error {
+	ordered := len(proxy.AllowCIDRs) > 0 || len(proxy.DenyCIDRs) > 0 ||
+		(proxy.TailnetIdentity != nil && proxy.TailnetIdentity.Enabled) ||
+		len(proxy.BasicAuth) > 0 || len(proxy.PathRules) > 0 ||
+		!isReverseProxyKind(proxy.Kind)
+	if ordered {
+		w.open("route")
+		defer w.close()
+	}
+
+	if len(proxy.DenyCIDRs) > 0 {
+		deny, err := normalizeCIDRs(proxy.DenyCIDRs)
+		if err != nil {
+			return err
+		}
+		w.line(append([]string{"@denied", "client_ip"}, deny...)...)
+		w.line("respond", "@denied", "403")
+	}
+	if len(proxy.AllowCIDRs) > 0 {
+		allow, err := normalizeCIDRs(proxy.AllowCIDRs)
+		if err != nil {
+			return err
+		}
+		w.line(append([]string{"@not_allowed", "not", "client_ip"}, allow...)...)
+		w.line("respond", "@not_allowed", "403")
+	}
+
+	if proxy.TailnetIdentity != nil && proxy.TailnetIdentity.Enabled {
+		// The identity secret stays out of the export; importing the block
+		// maps it back to the policy, which is rendered with the secret
+		w.open("forward_auth", pm.identityDial)
+		w.line("uri", caddyfileToken(identityVerifyURI(*proxy.TailnetIdentity)))
+		w.line("header_up", IdentityRemoteAddrHeader, "{remote}")
+		w.line(append([]string{"copy_headers"}, identityHeaders...)...)
+		w.close()
+	}
+
+	if len(proxy.BasicAuth) > 0 {
+		w.open("basic_auth")
+		for _, user := range proxy.BasicAuth {
+			hash := redactedPasswordHash
+			if w.hashes {
+				hash = user.PasswordHash
+			}
+			w.line(caddyfileToken(user.Username), hash)
+		}
+		w.close()
+	}
+
+	for _, rule := range proxy.PathRules {
+		directive := "handle"
+		if rule.StripPrefix {
+			directive = "handle_path"
+		}
+		w.open(directive, caddyfileToken(rule.Path))
+		renderReverseProxy(w, proxy, []string{rule.Target}, rule.CustomHeaders, rule.HeaderRules, false)
+		w.close()
+	}
+
+	if !isReverseProxyKind(proxy.Kind) {
+		return pm.renderKind(w, proxy)
+	}
+	if proxy.Target == "" {
+		return nil
+	}
+
+	targets := append([]string{proxy.Target}, proxy.Upstreams...)
+	if len(proxy.PathRules) > 0 {
+		w.open("handle")
+		defer w.close()
+	}
+	renderReverseProxy(w, proxy, targets, proxy.CustomHeaders, proxy.HeaderRules, true)
+	return nil
+}
+
+// renderKind writes the handler of a redirect, static response or file server
+func (pm *ProxyManager) renderKind(w *caddyfileWriter, proxy config.CaddyProxy) error {
+	switch proxy.Kind {
+	case config.ProxyKindRedirect:
+		status := proxy.Redirect.StatusCode
+		if status == 0 {
+			status = 302
+		}
+		w.line("redir", caddyfileToken(proxy.Redirect.URL), strconv.Itoa(status))
+	case config.ProxyKindStaticResponse:
+		static := proxy.StaticResponse
+		for _, name := range sortedStringKeys(static.Headers) {
+			w.line("header", name, caddyfileToken(static.Headers[name]))
+		}
+		status := static.StatusCode
+		if status == 0 {
+			status = 200
+		}
+		w.line("respond", caddyfileToken(static.Body), strconv.Itoa(status))
+	case config.ProxyKindFileServer:
+		root, err := pm.resolveFileServerRoot(proxy.FileServer.Root)
+		if err != nil {
+			return err
+		}
+		w.line("root", "*", caddyfileToken(root))
+		if proxy.FileServer.Browse {
+			w.open("file_server", "browse")
+		} else {
+			w.open("file_server")
+		}
+		hidden := make([]string, 0, len(pm.fileServerHidden()))
+		for _, name := range pm.fileServerHidden() {
+			hidden = append(hidden, caddyfileToken(name))
+		}
+		w.line(append([]string{"hide"}, hidden...)...)
+		w.close()
+	}
+	return nil
+}
+
+// renderReverseProxy writes a reverse_proxy directive; load balancing and
+// health checks only apply to the proxy's main upstreams
+func renderReverseProxy(w *caddyfileWriter, proxy config.CaddyProxy, targets []string, customHeaders map[string]string, headerRules []config.CaddyHeaderRule, main bool) {
+	scheme := "http://"
+	switch {
+	case UpstreamTLSEnabled(proxy):
+		scheme = "https://"
+	case proxy.Protocol == config.ProxyProtocolH2C || proxy.Protocol == config.ProxyProtocolGRPC:
+		scheme = "h2c://"
+	}
+	upstreams := make([]string, 0, len(targets))
+	for _, target := range targets {
+		if target = strings.TrimSpace(target); target != "" {
+			upstreams = append(upstreams, scheme+target)
+		}
+	}
+
+	w.open(append([]string{"reverse_proxy"}, upstreams...)...)
+	defer w.close()
+
+	if !proxy.PreserveHost {
+		w.line("header_up", "Host", "{upstream_hostport}")
+	}
+	for _, name := range sortedStringKeys(customHeaders) {
+		w.line("header_up", name, caddyfileToken(customHeaders[name]))
+	}
+	for _, rule := range headerRules {
+		w.line(headerRuleTokens(rule)...)
+	}
+	if proxy.TrustedProxies {
+		w.line("trusted_proxies", "private_ranges")
+	}
+
+	if main && proxy.LoadBalancing != nil {
+		lb := proxy.LoadBalancing
+		if lb.Policy != "" {
+			if lb.Policy == "cookie" && lb.CookieName != "" {
+				w.line("lb_policy", "cookie", lb.CookieName)
+			} else {
+				w.line("lb_policy", lb.Policy)
+			}
+		}
+		if lb.Retries > 0 {
+			w.line("lb_retries", strconv.Itoa(lb.Retries))
+		}
+		if lb.TryDuration != "" {
+			w.line("lb_try_duration", lb.TryDuration)
+		}
+		if lb.TryInterval != "" {
+			w.line("lb_try_interval", lb.TryInterval)
+		}
+	}
+
+	if main && proxy.HealthChecks != nil {
+		hc := proxy.HealthChecks
+		if hc.URI != "" {
+			w.line("health_uri", caddyfileToken(hc.URI))
+			if hc.Interval != "" {
+				w.line("health_interval", hc.Interval)
+			}
+			if hc.Timeout != "" {
+				w.line("health_timeout", hc.Timeout)
+			}
+			if hc.ExpectStatus != 0 {
+				w.line("health_status", strconv.Itoa(hc.ExpectStatus))
+			}
+			if hc.ExpectBody != "" {
+				w.line("health_body", caddyfileToken(hc.ExpectBody))
+			}
+		}
+		if hc.MaxFails > 0 || hc.UnhealthyLatency != "" {
+			failDuration := hc.FailDuration
+			if failDuration == "" {
+				failDuration = defaultFailDuration
+			}
+			w.line("fail_duration", failDuration)
+			if hc.MaxFails > 0 {
+				w.line("max_fails", strconv.Itoa(hc.MaxFails))
+			}
+			if hc.UnhealthyLatency != "" {
+				w.line("unhealthy_latency", hc.UnhealthyLatency)
+			}
+		}
+	}
+
+	if proxy.Protocol == config.ProxyProtocolGRPC {
+		w.line("flush_interval", "-1")
+	}
+	renderTransport(w, proxy)
+}
+
+// renderTransport writes the transport block for upstream TLS and protocol settings
+func renderTransport(w *caddyfileWriter, proxy config.CaddyProxy) {
+	transport, err := buildTransport(proxy)
+	if err != nil || transport == nil {
+		return
+	}
+
+	w.open("transport", "http")
+	defer w.close()
+
+	if tlsConfig := transport.TLS; tlsConfig != nil {
+		w.line("tls")
+		if tlsConfig.CA != nil && len(tlsConfig.CA.PEMFiles) > 0 {
+			w.line(append([]string{"tls_trust_pool", "file"}, tlsConfig.CA.PEMFiles...)...)
+		}
+		if tlsConfig.ClientCertificateFile != "" {
+			w.line("tls_client_auth", caddyfileToken(tlsConfig.ClientCertificateFile), caddyfileToken(tlsConfig.ClientCertificateKey))
+		}
+		if tlsConfig.ServerName != "" {
+			w.line("tls_server_name", tlsConfig.ServerName)
+		}
+		if tlsConfig.InsecureSkipVerify {
+			w.line("tls_insecure_skip_verify")
+		}
+		if tlsConfig.HandshakeTimeout != "" {
+			w.line("tls_timeout", tlsConfig.HandshakeTimeout)
+		}
+	}
+	if len(transport.Versions) > 0 {
+		w.line(append([]string{"versions"}, transport.Versions...)...)
+	}
+	if transport.KeepAlive != nil {
+		if transport.KeepAlive.IdleConnTimeout != "" {
+			w.line("keepalive", transport.KeepAlive.IdleConnTimeout)
+		}
+		if transport.KeepAlive.ProbeInterval != "" {
+			w.line("keepalive_interval", transport.KeepAlive.ProbeInterval)
+		}
+	}
+}
+
+// headerRuleTokens renders a header rule as a header_up or header_down line
+func headerRuleTokens(rule config.CaddyHeaderRule) []string {
+	directive := "header_up"
+	if rule.Direction == "response" {
+		directive = "header_down"
+	}
+	switch rule.Operation {
+	case "add":
+		return []string{directive, "+" + rule.Field, caddyfileToken(rule.Value)}
+	case "delete":
+		return []string{directive, "-" + rule.Field}
+	case "replace":
+		return []string{directive, rule.Field, caddyfileToken(rule.Search), caddyfileToken(rule.Value)}
+	default:
+		return []string{directive, rule.Field, caddyfileToken(rule.Value)}
+	}
+}
+
+func sortedStringKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,668 @@
+package caddy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/config"
+	"github.com/sudocarlos/tailrelay-webui/internal/logger"
+)
+
+// ErrInvalidCaddyfile is returned when Caddy can't adapt an uploaded
+// Caddyfile or it uses something the import doesn't allow
+var ErrInvalidCaddyfile = errors.New("invalid Caddyfile")
+
+// CaddyfileImport is the result of parsing a Caddyfile into proxies
+type CaddyfileImport struct {
+	Proxies  []config.CaddyProxy `json:"proxies"`
+	Warnings []string            `json:"warnings"`
+	// Rejected maps the index of a proxy in Proxies to why it must not be
+	// imported, e.g. authentication it can't carry over
+	Rejected map[int]string `json:"rejected,omitempty"`
+}
+
+// adaptedServer is the part of an adapted HTTP server the import reads
+type adaptedServer struct {
+	Listen         []string `json:"listen"`
+	Routes         []Route  `json:"routes"`
+	AutomaticHTTPS struct {
+		Skip []string `json:"skip"`
+	} `json:"automatic_https"`
+	TLSConnectionPolicies []adaptedConnectionPolicy `json:"tls_connection_policies"`
+}
+
+// adaptedConnectionPolicy is a server's TLS setup for some SNI names
+type adaptedConnectionPolicy struct {
+	Match struct {
+		SNI []string `json:"sni"`
+	} `json:"match"`
+	CertificateSelection struct {
+		AnyTag []string `json:"any_tag"`
+	} `json:"certificate_selection"`
+	ClientAuthentication json.RawMessage `json:"client_authentication"`
+}
+
+// ParseCaddyfile has Caddy adapt a Caddyfile to JSON and reads its sites
+// back as proxies the same way existing routes are discovered. What a proxy
+// doesn't model is kept as advanced settings or skipped with a warning.
+func (pm *ProxyManager) ParseCaddyfile(data string) (*CaddyfileImport, error) {
+	if err := checkCaddyfileSources(data); err != nil {
+		return nil, err
+	}
+
+	adapted, err := pm.client.Adapt(data)
+	if err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCaddyfile, adaptErrorMessage(apiErr.Message))
+		}
+		return nil, fmt.Errorf("adapt Caddyfile: %w", err)
+	}
+
+	result := &CaddyfileImport{
+		Proxies:  []config.CaddyProxy{},
+		Warnings: []string{},
+		Rejected: make(map[int]string),
+	}
+	for _, warning := range adapted.Warnings {
+		if warning.Line > 0 {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("line %d: %s", warning.Line, warning.Message))
+		} else {
+			result.Warnings = append(result.Warnings, warning.Message)
+		}
+	}
+
+	var cfg map[string]json.RawMessage
+	if err := json.Unmarshal(adapted.Result, &cfg); err != nil {
+		return nil, fmt.Errorf("unmarshal adapted config: %w", err)
+	}
+	var apps map[string]json.RawMessage
+	if raw, ok := cfg["apps"]; ok {
+		if err := json.Unmarshal(raw, &apps); err != nil {
+			return nil, fmt.Errorf("unmarshal adapted apps: %w", err)
+		}
+	}
+	for _, key := range sortedRawKeys(cfg) {
+		if key != "apps" {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("global option %s ignored", key))
+		}
+	}
+	for _, name := range sortedRawKeys(apps) {
+		if name != "http" && name != "tls" {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s app ignored", name))
+		}
+	}
+
+	var httpApp struct {
+		Servers map[string]adaptedServer `json:"servers"`
+	}
+	if raw, ok := apps["http"]; ok {
+		if err := json.Unmarshal(raw, &httpApp); err != nil {
+			return nil, fmt.Errorf("unmarshal adapted http app: %w", err)
+		}
+	}
+	var tlsApp TLSApp
+	if raw, ok := apps["tls"]; ok {
+		if err := json.Unmarshal(raw, &tlsApp); err != nil {
+			return nil, fmt.Errorf("unmarshal adapted tls app: %w", err)
+		}
+	}
+
+	names := make([]string, 0, len(httpApp.Servers))
+	for name := range httpApp.Servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		server := httpApp.Servers[name]
+		for _, route := range server.Routes {
+			pm.importRoute(result, route, server, tlsApp)
+		}
+	}
+	return result, nil
+}
+
+// checkCaddyfileSources rejects what would have Caddy read more than the
+// upload while adapting it: imported files and environment variables.
+// Importing a snippet defined in the same file is fine.
+func checkCaddyfileSources(data string) error {
+	lines := strings.Split(data, "\n")
+	snippets := make(map[string]bool)
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && strings.HasPrefix(fields[0], "(") && strings.HasSuffix(fields[0], ")") {
+			snippets[strings.Trim(fields[0], "()")] = true
+		}
+	}
+
+	for i, line := range lines {
+		if strings.Contains(line, "{$") {
+			return fmt.Errorf("%w: line %d: environment variables are not supported", ErrInvalidCaddyfile, i+1)
+		}
+		// Only the directive itself counts, not e.g. a site named importer.example.com
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != "import" {
+			continue
+		}
+		if len(fields) < 2 || !snippets[fields[1]] {
+			return fmt.Errorf("%w: line %d: only snippets defined in the file can be imported", ErrInvalidCaddyfile, i+1)
+		}
+	}
+	return nil
+}
+
+// adaptErrorMessage reads the message out of Caddy's {"error": "..."} reply
+func adaptErrorMessage(body string) string {
+	var reply struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(body), &reply); err == nil && reply.Error != "" {
+		return reply.Error
+	}
+	return strings.TrimSpace(body)
+}
+
+func sortedRawKeys(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// importRoute reads one adapted site route into a proxy. A proxy whose
+// authentication can't be carried over is added as rejected.
+func (pm *ProxyManager) importRoute(result *CaddyfileImport, route Route, server adaptedServer, tlsApp TLSApp) {
+	if len(route.Match) == 0 || len(route.Match[0].Host) == 0 {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("site without a hostname on %s skipped", strings.Join(server.Listen, ", ")))
+		return
+	}
+	hosts := route.Match[0].Host
+	site := hosts[0]
+	if port, ok := parseListenPort(server.Listen); ok {
+		site = fmt.Sprintf("%s:%d", hosts[0], port)
+	}
+	if len(route.Match) > 1 || len(route.Match[0].Path) > 0 {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("site %s is limited to a path, skipped", site))
+		return
+	}
+	if len(hosts) > 1 {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("site has several hostnames, only %s is imported", site))
+	}
+
+	if len(route.Handle) == 1 && route.Handle[0]["handler"] == "subroute" {
+		routesRaw, _ := route.Handle[0]["routes"].([]interface{})
+		route.Handle[0]["routes"] = inlineFileServerRoot(flattenSubroutes(routesRaw))
+	}
+
+	proxy, err := pm.routeToProxyWithListen(route, server.Listen)
+	if err != nil {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("site %s skipped: %v", site, err))
+		return
+	}
+	proxy.ID = ""
+	proxy.Enabled = true
+	proxy.Autostart = true
+
+	for _, dropped := range droppedHandlers(route) {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("site %s: %s limited to a matcher is not imported", site, dropped))
+	}
+	if warning := importFrontendTLS(proxy, server, tlsApp); warning != "" {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("site %s: %s", site, warning))
+	}
+
+	reason := unmappedAuth(route, proxy)
+	if reason == "" && requiresClientAuth(server, proxy.Hostname) {
+		reason = "tls client_auth can't be imported"
+	}
+	if reason != "" {
+		reason = fmt.Sprintf("site %s: %s", site, reason)
+		result.Warnings = append(result.Warnings, reason)
+		result.Rejected[len(result.Proxies)] = reason
+	}
+	result.Proxies = append(result.Proxies, *proxy)
+}
+
+// flattenSubroutes undoes the nesting the Caddyfile adapter adds so the
+// routes look like the ones buildRoute produces: the entries of a route
+// block without a matcher are spliced in, and a handle block with a matcher
+// becomes one entry holding the handlers of its unmatched routes.
+func flattenSubroutes(routes []interface{}) []interface{} {
+	flat := make([]interface{}, 0, len(routes))
+	for _, routeRaw := range routes {
+		entry, ok := routeRaw.(map[string]interface{})
+		if !ok {
+			flat = append(flat, routeRaw)
+			continue
+		}
+		nested, ok := soleSubroute(entry)
+		if !ok {
+			flat = append(flat, entry)
+			continue
+		}
+		nested = flattenSubroutes(nested)
+
+		if entry["match"] == nil {
+			flat = append(flat, nested...)
+			continue
+		}
+		if handles, ok := unmatchedHandlers(nested); ok {
+			entry["handle"] = handles
+		} else {
+			entry["handle"] = []interface{}{map[string]interface{}{"handler": "subroute", "routes": nested}}
+		}
+		flat = append(flat, entry)
+	}
+	return flat
+}
+
+// soleSubroute returns the routes of an entry whose only handler is a subroute
+func soleSubroute(entry map[string]interface{}) ([]interface{}, bool) {
+	handles, _ := entry["handle"].([]interface{})
+	if len(handles) != 1 {
+		return nil, false
+	}
+	handler, ok := handles[0].(map[string]interface{})
+	if !ok || handler["handler"] != "subroute" {
+		return nil, false
+	}
+	routes, _ := handler["routes"].([]interface{})
+	return routes, true
+}
+
+// unmatchedHandlers joins the handlers of routes none of which has a
+// matcher, which runs them the same way
+func unmatchedHandlers(routes []interface{}) ([]interface{}, bool) {
+	var handles []interface{}
+	for _, routeRaw := range routes {
+		entry, ok := routeRaw.(map[string]interface{})
+		if !ok || entry["match"] != nil {
+			return nil, false
+		}
+		entryHandles, _ := entry["handle"].([]interface{})
+		handles = append(handles, entryHandles...)
+	}
+	return handles, true
+}
+
+// inlineFileServerRoot moves the root the adapter sets with a vars handler
+// into the file_server handler, where buildRoute puts it
+func inlineFileServerRoot(routes []interface{}) []interface{} {
+	rootIndex, root := -1, ""
+	var fileServer map[string]interface{}
+	for i, routeRaw := range routes {
+		entry, ok := routeRaw.(map[string]interface{})
+		if !ok || entry["match"] != nil {
+			continue
+		}
+		handles, _ := entry["handle"].([]interface{})
+		for _, handleRaw := range handles {
+			handler, ok := handleRaw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			switch {
+			case handler["handler"] == "vars" && len(handles) == 1 && len(handler) == 2:
+				if value, ok := handler["root"].(string); ok {
+					rootIndex, root = i, value
+				}
+			case handler["handler"] == "file_server" && fileServer == nil:
+				fileServer = handler
+			}
+		}
+	}
+	if rootIndex < 0 || fileServer == nil || fileServer["root"] != nil {
+		return routes
+	}
+
+	fileServer["root"] = root
+	return append(routes[:rootIndex:rootIndex], routes[rootIndex+1:]...)
+}
+
+// droppedHandlers names the modeled handlers of matched subroute entries
+// that the proxy doesn't carry: anything but access lists and path rules
+func droppedHandlers(route Route) []string {
+	if len(route.Handle) == 0 || route.Handle[0]["handler"] != "subroute" {
+		return nil
+	}
+	routesRaw, _ := route.Handle[0]["routes"].([]interface{})
+
+	var dropped []string
+	for _, routeRaw := range routesRaw {
+		entry, ok := routeRaw.(map[string]interface{})
+		if !ok || entry["match"] == nil || isForbiddenRoute(entry) {
+			continue
+		}
+		handles, _ := entry["handle"].([]interface{})
+		for _, handleRaw := range handles {
+			handler, ok := handleRaw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			switch handler["handler"] {
+			case "static_response", "file_server":
+				dropped = append(dropped, handler["handler"].(string))
+			case "reverse_proxy":
+				if !isIdentityHandler(handler) && matcherPath(entry["match"]) == "" {
+					dropped = append(dropped, "reverse_proxy")
+				}
+			}
+		}
+	}
+	return dropped
+}
+
+// unmappedAuth explains why a site's authentication can't be carried over
+// to the proxy read from it, or returns "" when it can. It also turns the
+// proxy's password hashes into the form stored in metadata. Importing such
+// a site without its authentication would publish it, so it is rejected.
+func unmappedAuth(route Route, proxy *config.CaddyProxy) string {
+	var (
+		reason           string
+		authHandlers     int
+		identityHandlers int
+		reject           = func(format string, args ...interface{}) {
+			if reason == "" {
+				reason = fmt.Sprintf(format, args...)
+			}
+		}
+	)
+	walkHandlers(route.Handle, false, func(handler map[string]interface{}, matched bool) {
+		switch {
+		case handler["handler"] == "authentication":
+			authHandlers++
+			if matched {
+				reject("basic_auth limited to a matcher can't be imported")
+			} else if !isBcryptBasicAuth(handler) {
+				reject("only basic_auth with bcrypt hashes can be imported")
+			}
+		case isIdentityHandler(handler):
+			identityHandlers++
+			if matched {
+				reject("forward_auth limited to a matcher can't be imported")
+			}
+		case handler["handler"] == "reverse_proxy" && handler["handle_response"] != nil:
+			reject("only tailnet identity forward_auth can be imported")
+		}
+	})
+
+	switch {
+	case authHandlers > 1 || identityHandlers > 1:
+		reject("several authentication handlers can't be imported")
+	case authHandlers == 1 && len(proxy.BasicAuth) == 0:
+		reject("basic_auth without accounts can't be imported")
+	case identityHandlers == 1 && proxy.TailnetIdentity == nil:
+		reject("forward_auth can't be imported")
+	}
+
+	for i, user := range proxy.BasicAuth {
+		hash, err := decodeBasicAuthHash(user.PasswordHash)
+		if err != nil {
+			reject("basic_auth user %s: %v", user.Username, err)
+			continue
+		}
+		proxy.BasicAuth[i].PasswordHash = hash
+	}
+	return reason
+}
+
+// walkHandlers calls fn for each handler, descending into subroutes. matched
+// tells whether a matcher on the way limits when the handler runs.
+func walkHandlers(handles []Handler, matched bool, fn func(handler map[string]interface{}, matched bool)) {
+	for _, handler := range handles {
+		fn(handler, matched)
+		if handler["handler"] != "subroute" {
+			continue
+		}
+		routesRaw, _ := handler["routes"].([]interface{})
+		for _, routeRaw := range routesRaw {
+			entry, ok := routeRaw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			handlesRaw, _ := entry["handle"].([]interface{})
+			nested := make([]Handler, 0, len(handlesRaw))
+			for _, handleRaw := range handlesRaw {
+				if handleMap, ok := handleRaw.(map[string]interface{}); ok {
+					nested = append(nested, handleMap)
+				}
+			}
+			walkHandlers(nested, matched || entry["match"] != nil, fn)
+		}
+	}
+}
+
+// isBcryptBasicAuth reports whether an authentication handler only checks
+// basic auth passwords against bcrypt hashes
+func isBcryptBasicAuth(handler map[string]interface{}) bool {
+	providers, _ := handler["providers"].(map[string]interface{})
+	httpBasic, ok := providers["http_basic"].(map[string]interface{})
+	if !ok || len(providers) != 1 {
+		return false
+	}
+	hash, _ := httpBasic["hash"].(map[string]interface{})
+	algorithm, _ := hash["algorithm"].(string)
+	return algorithm == "" || algorithm == "bcrypt"
+}
+
+// decodeBasicAuthHash returns a bcrypt hash as stored in metadata. Older
+// Caddyfiles base64-encode it, which Caddy still accepts.
+func decodeBasicAuthHash(hash string) (string, error) {
+	if !strings.HasPrefix(hash, "$") {
+		decoded, err := base64.StdEncoding.DecodeString(hash)
+		if err != nil {
+			return "", fmt.Errorf("password hash is not bcrypt")
+		}
+		hash = string(decoded)
+	}
+	if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+		return "", fmt.Errorf("password hash is not bcrypt")
+	}
+	return hash, nil
+}
+
+// importFrontendTLS sets how the proxy serves its site from the adapted
+// server and TLS app. It returns a warning when that has no proxy equivalent.
+func importFrontendTLS(proxy *config.CaddyProxy, server adaptedServer, tlsApp TLSApp) string {
+	if proxy.Port == 80 || containsString(server.AutomaticHTTPS.Skip, proxy.Hostname) {
+		proxy.FrontendTLSMode = config.FrontendTLSHTTP
+		return ""
+	}
+
+	for _, policy := range server.TLSConnectionPolicies {
+		if !containsString(policy.Match.SNI, proxy.Hostname) || len(policy.CertificateSelection.AnyTag) == 0 {
+			continue
+		}
+		if tlsApp.Certificates != nil {
+			for _, file := range tlsApp.Certificates.LoadFiles {
+				for _, tag := range file.Tags {
+					if containsString(policy.CertificateSelection.AnyTag, tag) {
+						proxy.FrontendTLSMode = config.FrontendTLSLoadFiles
+						proxy.FrontendTLSCertFile = file.Certificate
+						proxy.FrontendTLSKeyFile = file.Key
+						return ""
+					}
+				}
+			}
+		}
+		return "certificate selection is not supported, using the default certificate"
+	}
+
+	if tlsApp.Automation != nil {
+		for _, policy := range tlsApp.Automation.Policies {
+			if !containsString(policy.Subjects, proxy.Hostname) {
+				continue
+			}
+			for _, issuer := range policy.Issuers {
+				if issuer.Module == "internal" {
+					proxy.FrontendTLSMode = config.FrontendTLSInternal
+					return ""
+				}
+			}
+			if len(policy.Issuers) > 0 {
+				return fmt.Sprintf("certificate issuer %s is not supported, using the default certificate", policy.Issuers[0].Module)
+			}
+		}
+	}
+	return ""
+}
+
+// requiresClientAuth reports whether the server asks clients of a host for
+// a certificate
+func requiresClientAuth(server adaptedServer, hostname string) bool {
+	for _, policy := range server.TLSConnectionPolicies {
+		if containsString(policy.Match.SNI, hostname) && len(policy.ClientAuthentication) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if strings.EqualFold(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Import entry statuses
+const (
+	ImportStatusNew       = "new"        // Will be created
+	ImportStatusExists    = "exists"     // A proxy already serves this hostname and port
+	ImportStatusInvalid   = "invalid"    // Caddy would reject the settings, or they can't be imported safely
+	ImportStatusPortTaken = "port_taken" // The port belongs to a relay or another service
+)
+
+// PortReserver checks that a new proxy may use its port and holds the port
+// until release is called, so nothing else takes it before the proxy is saved
+type PortReserver func(proxy *config.CaddyProxy) (release func(), err error)
+
+// ImportEntry is one proxy parsed from a Caddyfile and what importing it would do
+type ImportEntry struct {
+	Proxy  config.CaddyProxy `json:"proxy"`
+	Status string            `json:"status"`
+	Error  string            `json:"error,omitempty"`
+}
+
+// ImportPreview lists what importing a Caddyfile would create
+type ImportPreview struct {
+	Entries  []ImportEntry `json:"entries"`
+	Warnings []string      `json:"warnings"`
+}
+
+// PreviewCaddyfileImport parses a Caddyfile and checks each proxy against
+// existing ones and the ports reserve knows, without changing anything
+func (pm *ProxyManager) PreviewCaddyfileImport(data string, reserve PortReserver) (*ImportPreview, error) {
+	existing, err := LoadProxyMetadata(pm.metadataPath)
+	if err != nil {
+		return nil, fmt.Errorf("load metadata: %w", err)
+	}
+	preview, release, err := pm.previewImport(data, existing, reserve)
+	if err != nil {
+		return nil, err
+	}
+	release()
+	return preview, nil
+}
+
+// ImportCaddyfile creates every proxy of a Caddyfile that the preview marks
+// as new, in a single apply. Their ports stay reserved until it is done.
+func (pm *ProxyManager) ImportCaddyfile(data string, reserve PortReserver) (*ImportPreview, []config.CaddyProxy, error) {
+	pm.applyMu.Lock()
+	defer pm.applyMu.Unlock()
+
+	existing, err := LoadProxyMetadata(pm.metadataPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load metadata: %w", err)
+	}
+	preview, release, err := pm.previewImport(data, existing, reserve)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+
+	created := []config.CaddyProxy{}
+	for _, entry := range preview.Entries {
+		if entry.Status != ImportStatusNew {
+			continue
+		}
+		proxy := entry.Proxy
+		if proxy.ID, err = config.GenerateToken(); err != nil {
+			return nil, nil, fmt.Errorf("generate proxy id: %w", err)
+		}
+		created = append(created, proxy)
+	}
+	if len(created) == 0 {
+		return preview, created, nil
+	}
+
+	if err := pm.commitProxies(append(existing, created...)); err != nil {
+		return nil, nil, fmt.Errorf("apply proxies: %w", err)
+	}
+	logger.Info("caddy", "Imported %d proxies from Caddyfile", len(created))
+	return preview, created, nil
+}
+
+// previewImport decides what importing a Caddyfile would do, reserving the
+// ports of the new proxies. The caller must call release once done.
+func (pm *ProxyManager) previewImport(data string, existing []config.CaddyProxy, reserve PortReserver) (*ImportPreview, func(), error) {
+	parsed, err := pm.ParseCaddyfile(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse Caddyfile: %w", err)
+	}
+
+	var releases []func()
+	release := func() {
+		for _, release := range releases {
+			release()
+		}
+	}
+
+	taken := make(map[string]bool, len(existing))
+	for _, proxy := range existing {
+		taken[fmt.Sprintf("%s:%d", NormalizeHostname(proxy.Hostname), proxy.Port)] = true
+	}
+
+	preview := &ImportPreview{
+		Entries:  make([]ImportEntry, 0, len(parsed.Proxies)),
+		Warnings: parsed.Warnings,
+	}
+	for i, proxy := range parsed.Proxies {
+		proxy.Hostname = NormalizeHostname(proxy.Hostname)
+		entry := ImportEntry{Proxy: proxy, Status: ImportStatusNew}
+
+		address := fmt.Sprintf("%s:%d", proxy.Hostname, proxy.Port)
+		if reason, rejected := parsed.Rejected[i]; rejected {
+			entry.Status = ImportStatusInvalid
+			entry.Error = reason
+		} else if taken[address] {
+			entry.Status = ImportStatusExists
+			entry.Error = fmt.Sprintf("a proxy for %s already exists", address)
+		} else if err := pm.ValidateProxy(proxy); err != nil {
+			entry.Status = ImportStatusInvalid
+			entry.Error = err.Error()
+		} else if reserve != nil {
+			if releasePort, err := reserve(&entry.Proxy); err != nil {
+				entry.Status = ImportStatusPortTaken
+				entry.Error = err.Error()
+			} else {
+				releases = append(releases, releasePort)
+				taken[address] = true
+			}
+		} else {
+			taken[address] = true
+		}
+		preview.Entries = append(preview.Entries, entry)
+	}
+	return preview, release, nil
+}
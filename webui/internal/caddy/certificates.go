@@ -0,0 +1,117 @@
+package caddy
+
+import (
+	"fmt"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/config"
+	"github.com/sudocarlos/tailrelay-webui/internal/logger"
+)
+
+const (
+	// certificateTag marks the load_files entries tailrelay manages
+	certificateTag = ownerPrefix + "managed"
+	// certificateFingerprintTagPrefix starts a tag carrying the certificate's
+	// fingerprint. Caddy skips loading a config identical to the running one,
+	// so a renewed file at the same path must change the config to be read.
+	certificateFingerprintTagPrefix = ownerPrefix + "sha256_"
+)
+
+// LoadedCertificate is a certificate and key file pair Caddy loads from disk
+type LoadedCertificate struct {
+	CertFile    string
+	KeyFile     string
+	Fingerprint string // Leaf SHA-256; a change makes Caddy reload the files
+}
+
+// SetLoadedCertificates replaces the certificates tailrelay loads into
+// Caddy's TLS app and re-applies the config. Caddy serves a loaded
+// certificate for the names it covers instead of obtaining one itself.
+func (pm *ProxyManager) SetLoadedCertificates(loaded []LoadedCertificate) error {
+	pm.applyMu.Lock()
+	defer pm.applyMu.Unlock()
+
+	previous := pm.loadedCerts
+	pm.loadedCerts = append([]LoadedCertificate(nil), loaded...)
+
+	proxies, err := LoadProxyMetadata(pm.metadataPath)
+	if err != nil {
+		pm.loadedCerts = previous
+		return fmt.Errorf("load metadata: %w", err)
+	}
+	if err := pm.applyProxies(proxies, nil); err != nil {
+		pm.loadedCerts = previous
+		return fmt.Errorf("apply certificates: %w", err)
+	}
+
+	logger.Info("caddy", "Loaded %d certificate(s) into Caddy", len(loaded))
+	return nil
+}
+
+// SeedLoadedCertificates sets the certificates tailrelay loads into Caddy's
+// TLS app without applying, so the first apply after a restart keeps them
+func (pm *ProxyManager) SeedLoadedCertificates(loaded []LoadedCertificate) {
+	pm.applyMu.Lock()
+	defer pm.applyMu.Unlock()
+	pm.loadedCerts = append([]LoadedCertificate(nil), loaded...)
+}
+
+// renderCertificates replaces the load_files entries tailrelay manages in a
+// full Caddy config with the set certificates and those of load_files
+// proxies, keeping any others. Callers must hold applyMu.
+func (pm *ProxyManager) renderCertificates(cfg map[string]interface{}, proxies []config.CaddyProxy) {
+	loadedCerts := append(append([]LoadedCertificate(nil), pm.loadedCerts...), frontendCertificates(proxies)...)
+
+	apps := childMap(cfg, "apps")
+	if _, ok := apps["tls"]; !ok && len(loadedCerts) == 0 {
+		return
+	}
+	tlsApp := childMap(apps, "tls")
+	certificates := childMap(tlsApp, "certificates")
+
+	files, _ := certificates["load_files"].([]interface{})
+	kept := make([]interface{}, 0, len(files)+len(loadedCerts))
+	for _, fileRaw := range files {
+		if !isManagedCertificate(fileRaw) {
+			kept = append(kept, fileRaw)
+		}
+	}
+	for _, loaded := range loadedCerts {
+		tags := []string{certificateTag}
+		if loaded.Fingerprint != "" {
+			tags = append(tags, certificateFingerprintTagPrefix+loaded.Fingerprint)
+		}
+		kept = append(kept, TLSCertificateFile{
+			Certificate: loaded.CertFile,
+			Key:         loaded.KeyFile,
+			Tags:        tags,
+		})
+	}
+
+	// Leave no empty objects behind in configs that had no TLS app
+	if len(kept) > 0 {
+		certificates["load_files"] = kept
+		return
+	}
+	delete(certificates, "load_files")
+	if len(certificates) == 0 {
+		delete(tlsApp, "certificates")
+	}
+	if len(tlsApp) == 0 {
+		delete(apps, "tls")
+	}
+}
+
+// isManagedCertificate reports whether a load_files entry carries certificateTag
+func isManagedCertificate(fileRaw interface{}) bool {
+	file, ok := fileRaw.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	tags, _ := file["tags"].([]interface{})
+	for _, tag := range tags {
+		if tag == certificateTag {
+			return true
+		}
+	}
+	return false
+}
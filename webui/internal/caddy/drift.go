@@ -0,0 +1,315 @@
+package caddy
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/config"
+	"github.com/sudocarlos/tailrelay-webui/internal/logger"
+)
+
+// driftIgnoredFields are metadata-only settings that never appear in Caddy
+var driftIgnoredFields = map[string]bool{
+	"id":        true,
+	"enabled":   true,
+	"autostart": true,
+	"advanced":  true, // Derived from extra
+	// The pinned fingerprint is only used to check the upstream's certificate
+	"tls_pinned_sha256": true,
+	// Frontend TLS lives in the TLS app and server settings, not the route
+	"frontend_tls_mode":      true,
+	"frontend_tls_cert_file": true,
+	"frontend_tls_key_file":  true,
+}
+
+// DriftReport compares proxy metadata with the live Caddy config
+type DriftReport struct {
+	CheckedAt time.Time    `json:"checked_at"`
+	InSync    bool         `json:"in_sync"`
+	Missing   []DriftEntry `json:"missing"`  // Enabled in metadata but absent from Caddy
+	Extra     []DriftEntry `json:"extra"`    // Live in Caddy but not enabled in metadata
+	Modified  []DriftEntry `json:"modified"` // Live in Caddy with different settings
+}
+
+// DriftEntry describes one drifted proxy route
+type DriftEntry struct {
+	ProxyID  string      `json:"proxy_id"`
+	Hostname string      `json:"hostname"`
+	Port     int         `json:"port"`
+	Server   string      `json:"server,omitempty"`
+	Known    bool        `json:"known"` // The proxy ID exists in metadata
+	Diffs    []FieldDiff `json:"diffs,omitempty"`
+}
+
+// FieldDiff is a single setting that differs between metadata and Caddy
+type FieldDiff struct {
+	Field    string      `json:"field"`
+	Expected interface{} `json:"expected"`
+	Actual   interface{} `json:"actual"`
+}
+
+// DetectDrift compares every proxy in metadata with the routes Caddy is serving
+func (pm *ProxyManager) DetectDrift() (*DriftReport, error) {
+	proxies, err := LoadProxyMetadata(pm.metadataPath)
+	if err != nil {
+		return nil, fmt.Errorf("load metadata: %w", err)
+	}
+	servers, err := pm.listServers()
+	if err != nil {
+		return nil, fmt.Errorf("list servers: %w", err)
+	}
+
+	type liveRoute struct {
+		proxy  *config.CaddyProxy
+		server string
+		marked bool // Carries tailrelay's ownership marker
+	}
+	live := make(map[string]liveRoute)
+	for _, serverName := range sortedServerNames(servers) {
+		server := servers[serverName]
+		for _, route := range server.Routes {
+			proxy, err := pm.routeToProxyWithListen(route, server.Listen)
+			if err != nil || proxy.ID == "" {
+				continue
+			}
+			live[proxy.ID] = liveRoute{proxy: proxy, server: serverName, marked: strings.HasPrefix(route.ID, routeIDPrefix)}
+		}
+	}
+
+	report := &DriftReport{
+		CheckedAt: time.Now(),
+		Missing:   []DriftEntry{},
+		Extra:     []DriftEntry{},
+		Modified:  []DriftEntry{},
+	}
+	known := make(map[string]bool, len(proxies))
+
+	for _, proxy := range proxies {
+		known[proxy.ID] = true
+		actual, isLive := live[proxy.ID]
+		entry := DriftEntry{
+			ProxyID:  proxy.ID,
+			Hostname: proxy.Hostname,
+			Port:     proxy.Port,
+			Server:   actual.server,
+			Known:    true,
+		}
+
+		switch {
+		case !proxy.Enabled && isLive:
+			report.Extra = append(report.Extra, entry)
+		case proxy.Enabled && !isLive:
+			report.Missing = append(report.Missing, entry)
+		case proxy.Enabled:
+			expected, err := pm.expectedProxy(proxy)
+			if err != nil {
+				logger.Warn("caddy", "Skipping drift check for proxy %s: %v", proxy.ID, err)
+				continue
+			}
+			if entry.Diffs = diffProxies(expected, actual.proxy); len(entry.Diffs) > 0 {
+				report.Modified = append(report.Modified, entry)
+			}
+		}
+	}
+
+	// Unknown routes only count when tailrelay created them; the rest are foreign
+	for id, route := range live {
+		if known[id] || !route.marked {
+			continue
+		}
+		report.Extra = append(report.Extra, DriftEntry{
+			ProxyID:  id,
+			Hostname: route.proxy.Hostname,
+			Port:     route.proxy.Port,
+			Server:   route.server,
+		})
+	}
+	sort.Slice(report.Extra, func(i, j int) bool { return report.Extra[i].ProxyID < report.Extra[j].ProxyID })
+
+	report.InSync = len(report.Missing) == 0 && len(report.Extra) == 0 && len(report.Modified) == 0
+	return report, nil
+}
+
+// HealDrift re-applies metadata to Caddy, restoring missing and modified
+// proxies and dropping routes of disabled ones. Routes with unknown IDs are
+// left alone; they may have been added on purpose outside the web UI.
+func (pm *ProxyManager) HealDrift(report *DriftReport) error {
+	pm.applyMu.Lock()
+	defer pm.applyMu.Unlock()
+
+	proxies, err := LoadProxyMetadata(pm.metadataPath)
+	if err != nil {
+		return fmt.Errorf("load metadata: %w", err)
+	}
+	if err := pm.applyProxies(proxies, nil); err != nil {
+		return fmt.Errorf("re-apply metadata: %w", err)
+	}
+
+	logger.Info("caddy", "Re-applied metadata: %d missing, %d modified, %d extra routes healed",
+		len(report.Missing), len(report.Modified), countKnown(report.Extra))
+	return nil
+}
+
+func countKnown(entries []DriftEntry) int {
+	count := 0
+	for _, entry := range entries {
+		if entry.Known {
+			count++
+		}
+	}
+	return count
+}
+
+// expectedProxy runs a proxy through buildRoute and discovery so it can be
+// compared field by field with what discovery reads from Caddy
+func (pm *ProxyManager) expectedProxy(proxy config.CaddyProxy) (*config.CaddyProxy, error) {
+	route, err := pm.buildRoute(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("build route: %w", err)
+	}
+
+	// Round-trip through JSON so the route looks like one read back from Caddy
+	data, err := json.Marshal(route)
+	if err != nil {
+		return nil, fmt.Errorf("marshal route: %w", err)
+	}
+	var decoded Route
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, fmt.Errorf("unmarshal route: %w", err)
+	}
+
+	return pm.routeToProxyWithListen(decoded, []string{listenAddress(proxy.Port)})
+}
+
+// diffProxies lists the JSON fields that differ between two proxies
+func diffProxies(expected, actual *config.CaddyProxy) []FieldDiff {
+	expectedFields, err1 := proxyFields(expected)
+	actualFields, err2 := proxyFields(actual)
+	if err1 != nil || err2 != nil {
+		return nil
+	}
+
+	keys := make(map[string]bool)
+	for key := range expectedFields {
+		keys[key] = true
+	}
+	for key := range actualFields {
+		keys[key] = true
+	}
+
+	var diffs []FieldDiff
+	for _, key := range sortedBoolKeys(keys) {
+		if driftIgnoredFields[key] {
+			continue
+		}
+		if !reflect.DeepEqual(expectedFields[key], actualFields[key]) {
+			diff := FieldDiff{
+				Field:    key,
+				Expected: expectedFields[key],
+				Actual:   actualFields[key],
+			}
+			if key == "basic_auth" {
+				diff.Expected = redactPasswordHashes(diff.Expected)
+				diff.Actual = redactPasswordHashes(diff.Actual)
+			}
+			diffs = append(diffs, diff)
+		}
+	}
+	return diffs
+}
+
+// redactPasswordHashes replaces the password hashes of a basic_auth field
+// with fingerprints, which still show whether a password changed
+func redactPasswordHashes(field interface{}) interface{} {
+	users, ok := field.([]interface{})
+	if !ok {
+		return field
+	}
+	for _, user := range users {
+		if account, ok := user.(map[string]interface{}); ok {
+			if hash, ok := account["password_hash"].(string); ok {
+				account["password_hash"] = passwordHashFingerprint(hash)
+			}
+		}
+	}
+	return users
+}
+
+func proxyFields(proxy *config.CaddyProxy) (map[string]interface{}, error) {
+	data, err := json.Marshal(proxy)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func sortedBoolKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Reconciler periodically checks Caddy for drift from proxy metadata
+type Reconciler struct {
+	pm       *ProxyManager
+	interval time.Duration
+	autoHeal bool
+}
+
+// NewReconciler creates a reconciler for a proxy manager
+func NewReconciler(pm *ProxyManager, interval time.Duration, autoHeal bool) *Reconciler {
+	return &Reconciler{
+		pm:       pm,
+		interval: interval,
+		autoHeal: autoHeal,
+	}
+}
+
+// Start runs the reconcile loop in the background until stop is closed
+func (r *Reconciler) Start(stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.RunOnce()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// RunOnce checks for drift and heals it when enabled
+func (r *Reconciler) RunOnce() *DriftReport {
+	report, err := r.pm.DetectDrift()
+	if err != nil {
+		logger.Warn("caddy", "Drift check failed: %v", err)
+		return nil
+	}
+
+	if !report.InSync {
+		logger.Warn("caddy", "Caddy config drifted from metadata: %d missing, %d extra, %d modified",
+			len(report.Missing), len(report.Extra), len(report.Modified))
+		if r.autoHeal {
+			if err := r.pm.HealDrift(report); err != nil {
+				logger.Error("caddy", "Auto-heal incomplete: %v", err)
+			}
+		}
+	}
+
+	return report
+}
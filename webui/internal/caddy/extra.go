@@ -0,0 +1,281 @@
+package caddy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/config"
+)
+
+// routeExtra is the part of a discovered route that CaddyProxy doesn't
+// model. It is stored opaquely in CaddyProxy.Extra and merged back by
+// buildRoute so edits made in the UI don't wipe it.
+type routeExtra struct {
+	Group     string                 `json:"group,omitempty"`
+	Match     map[string]interface{} `json:"match,omitempty"`     // Matchers next to the host matcher, e.g. "method"
+	Handler   map[string]interface{} `json:"handler,omitempty"`   // Fields of the main handler, e.g. "buffer_requests"
+	Subroutes []interface{}          `json:"subroutes,omitempty"` // Subroute entries with other handlers, e.g. encode
+}
+
+func (e *routeExtra) empty() bool {
+	return e.Group == "" && len(e.Match) == 0 && len(e.Handler) == 0 && len(e.Subroutes) == 0
+}
+
+// modeledHandlers are the handler types buildRoute produces in a subroute
+var modeledHandlers = map[string]bool{
+	"reverse_proxy":   true,
+	"static_response": true,
+	"file_server":     true,
+	"authentication":  true,
+}
+
+// knownMatchers are the matchers MatcherSet has fields for
+var knownMatchers = map[string]bool{
+	"host":      true,
+	"path":      true,
+	"client_ip": true,
+	"remote_ip": true,
+	"not":       true,
+}
+
+// UnmarshalJSON keeps matchers that MatcherSet has no field for
+func (m *MatcherSet) UnmarshalJSON(data []byte) error {
+	type plain MatcherSet
+	if err := json.Unmarshal(data, (*plain)(m)); err != nil {
+		return err
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	for name, value := range raw {
+		if knownMatchers[name] {
+			continue
+		}
+		if m.extra == nil {
+			m.extra = make(map[string]interface{})
+		}
+		m.extra[name] = value
+	}
+	return nil
+}
+
+// MarshalJSON writes the kept matchers back next to the known ones
+func (m MatcherSet) MarshalJSON() ([]byte, error) {
+	type plain MatcherSet
+	data, err := json.Marshal(plain(m))
+	if err != nil || len(m.extra) == 0 {
+		return data, err
+	}
+	var merged map[string]interface{}
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return nil, err
+	}
+	mergeUnmodeled(merged, m.extra)
+	return json.Marshal(merged)
+}
+
+// normalizeExtra drops an empty Extra and keeps Advanced in step with it
+func normalizeExtra(proxy *config.CaddyProxy) {
+	trimmed := bytes.TrimSpace(proxy.Extra)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) || bytes.Equal(trimmed, []byte("{}")) {
+		proxy.Extra = nil
+	}
+	proxy.Advanced = len(proxy.Extra) > 0
+}
+
+// parseRouteExtra decodes a proxy's Extra, or returns nil when it has none
+func parseRouteExtra(proxy config.CaddyProxy) (*routeExtra, error) {
+	if len(bytes.TrimSpace(proxy.Extra)) == 0 {
+		return nil, nil
+	}
+	var extra routeExtra
+	if err := json.Unmarshal(proxy.Extra, &extra); err != nil {
+		return nil, fmt.Errorf("invalid extra: %w", err)
+	}
+	if extra.empty() {
+		return nil, nil
+	}
+	return &extra, nil
+}
+
+// ValidateExtra checks the Extra sent for a proxy. Its subroutes may only
+// hold handlers the proxy doesn't model, so Extra can't add an upstream, a
+// response or authentication the proxy's settings don't show.
+func ValidateExtra(raw json.RawMessage) error {
+	extra, err := parseRouteExtra(config.CaddyProxy{Extra: raw})
+	if err != nil || extra == nil {
+		return err
+	}
+	_, err = extra.extraSubroutes()
+	return err
+}
+
+// extraSubroutes decodes the kept subroute entries so buildRoute can place
+// them ahead of the proxy's upstreams
+func (e *routeExtra) extraSubroutes() ([]Route, error) {
+	routes := make([]Route, 0, len(e.Subroutes))
+	for i, entry := range e.Subroutes {
+		entryMap, _ := entry.(map[string]interface{})
+		if handlerType := modeledHandlerType(entryMap["handle"]); handlerType != "" {
+			return nil, fmt.Errorf("invalid extra subroute %d: %s is set through the proxy settings", i+1, handlerType)
+		}
+		route, ok := decodeRoute(entry)
+		if !ok {
+			return nil, fmt.Errorf("invalid extra subroute %d", i+1)
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// applyRouteExtra merges the unmodeled parts of a route back into one built
+// by buildRoute; settings the UI models always win
+func applyRouteExtra(route *Route, mainHandler Handler, extra *routeExtra) {
+	if route.Group == "" {
+		route.Group = extra.Group
+	}
+	if len(route.Match) > 0 && len(extra.Match) > 0 {
+		route.Match[0].extra = extra.Match
+	}
+	if mainHandler != nil {
+		mergeUnmodeled(mainHandler, extra.Handler)
+	}
+}
+
+// extractRouteExtra works out which parts of a discovered route buildRoute
+// would not reproduce for the proxy read from it. mainHandler is the raw
+// handler the proxy's target or kind was read from.
+func (pm *ProxyManager) extractRouteExtra(route Route, mainHandler Handler, proxy config.CaddyProxy) json.RawMessage {
+	extra := &routeExtra{Group: route.Group}
+	if len(route.Match) > 0 && len(route.Match[0].extra) > 0 {
+		extra.Match = route.Match[0].extra
+	}
+
+	for _, entry := range unmodeledSubroutes(route) {
+		extra.Subroutes = append(extra.Subroutes, entry)
+	}
+
+	if mainHandler != nil {
+		proxy.Extra = nil
+		if built, err := pm.buildRoute(proxy); err == nil {
+			if builtRoute, ok := decodeRoute(built); ok {
+				if builtMain := mainRouteHandler(builtRoute, proxy); builtMain != nil {
+					extra.Handler = unmodeledFields(mainHandler, builtMain)
+				}
+			}
+		}
+	}
+
+	if extra.empty() {
+		return nil
+	}
+	data, err := json.Marshal(extra)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// mainRouteHandler finds the handler a proxy's target or kind lives in
+func mainRouteHandler(route Route, proxy config.CaddyProxy) Handler {
+	if !isReverseProxyKind(proxy.Kind) {
+		return extractKindHandler(route)
+	}
+	if pathRules, fallback := extractPathRules(route); len(pathRules) > 0 {
+		return fallback
+	}
+	if handler, ok := extractReverseProxyHandler(route); ok {
+		return handler
+	}
+	return nil
+}
+
+// unmodeledSubroutes returns the route's subroute entries (or top-level
+// handlers) that contain none of the handlers buildRoute produces
+func unmodeledSubroutes(route Route) []interface{} {
+	var entries []interface{}
+	for i, handler := range route.Handle {
+		handlerType, _ := handler["handler"].(string)
+		if i == 0 && handlerType == "subroute" {
+			routesRaw, _ := handler["routes"].([]interface{})
+			for _, routeRaw := range routesRaw {
+				routeMap, ok := routeRaw.(map[string]interface{})
+				if ok && !hasModeledHandler(routeMap["handle"]) {
+					entries = append(entries, routeMap)
+				}
+			}
+			continue
+		}
+		if !modeledHandlers[handlerType] {
+			entries = append(entries, map[string]interface{}{
+				"handle": []interface{}{map[string]interface{}(handler)},
+			})
+		}
+	}
+	return entries
+}
+
+func hasModeledHandler(handlesRaw interface{}) bool {
+	return modeledHandlerType(handlesRaw) != ""
+}
+
+// modeledHandlerType returns the first of a subroute entry's handlers that
+// buildRoute produces, or "" when it has none
+func modeledHandlerType(handlesRaw interface{}) string {
+	handles, _ := handlesRaw.([]interface{})
+	for _, handleRaw := range handles {
+		if handle, ok := handleRaw.(map[string]interface{}); ok {
+			if handlerType, _ := handle["handler"].(string); modeledHandlers[handlerType] {
+				return handlerType
+			}
+		}
+	}
+	return ""
+}
+
+// unmodeledFields returns the fields of raw that built lacks, recursing into
+// objects both have. Values both have are modeled, so built's win.
+func unmodeledFields(raw, built map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{})
+	for key, rawValue := range raw {
+		builtValue, exists := built[key]
+		if !exists {
+			result[key] = rawValue
+			continue
+		}
+		rawMap, rawIsMap := rawValue.(map[string]interface{})
+		builtMap, builtIsMap := builtValue.(map[string]interface{})
+		if rawIsMap && builtIsMap {
+			if nested := unmodeledFields(rawMap, builtMap); len(nested) > 0 {
+				result[key] = nested
+			}
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+// mergeUnmodeled adds extra fields to target without overriding any it has
+func mergeUnmodeled(target, extra map[string]interface{}) {
+	for key, extraValue := range extra {
+		existing, exists := target[key]
+		if !exists {
+			target[key] = extraValue
+			continue
+		}
+		extraMap, extraIsMap := extraValue.(map[string]interface{})
+		if !extraIsMap {
+			continue
+		}
+		switch existingMap := existing.(type) {
+		case map[string]interface{}:
+			mergeUnmodeled(existingMap, extraMap)
+		case Handler:
+			mergeUnmodeled(existingMap, extraMap)
+		}
+	}
+}
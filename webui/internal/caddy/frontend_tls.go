@@ -0,0 +1,232 @@
+package caddy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/config"
+)
+
+// tlsPolicyIDPrefix starts the @id of the TLS automation policies tailrelay
+// manages; the rest is the policy's subject
+const tlsPolicyIDPrefix = ownerPrefix + "tls_"
+
+// tailnetDomainSuffix ends the MagicDNS names Tailscale issues certificates for
+const tailnetDomainSuffix = ".ts.net"
+
+// InternalCAID is the Caddy PKI authority behind the internal TLS mode
+const InternalCAID = "local"
+
+// FrontendTLSMode returns a proxy's frontend TLS mode, resolving the default
+func FrontendTLSMode(proxy config.CaddyProxy) string {
+	if proxy.FrontendTLSMode == "" {
+		return config.FrontendTLSTailscale
+	}
+	return proxy.FrontendTLSMode
+}
+
+// validateFrontendTLS checks a proxy's frontend TLS settings on their own
+func validateFrontendTLS(proxy config.CaddyProxy) error {
+	hostname := NormalizeHostname(proxy.Hostname)
+	switch proxy.FrontendTLSMode {
+	case "", config.FrontendTLSInternal, config.FrontendTLSHTTP:
+		return nil
+	case config.FrontendTLSTailscale:
+		if !strings.HasSuffix(hostname, tailnetDomainSuffix) {
+			return fmt.Errorf("tailscale certificates only cover *%s names; use internal or load_files for %s", tailnetDomainSuffix, hostname)
+		}
+		return nil
+	case config.FrontendTLSLoadFiles:
+		if proxy.FrontendTLSCertFile == "" || proxy.FrontendTLSKeyFile == "" {
+			return fmt.Errorf("load_files needs a certificate and a key")
+		}
+		pair, err := tls.LoadX509KeyPair(proxy.FrontendTLSCertFile, proxy.FrontendTLSKeyFile)
+		if err != nil {
+			return fmt.Errorf("load certificate: %w", err)
+		}
+		leaf, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("parse certificate: %w", err)
+		}
+		if err := leaf.VerifyHostname(hostname); err != nil {
+			return fmt.Errorf("certificate doesn't cover %s", hostname)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown frontend TLS mode %q", proxy.FrontendTLSMode)
+	}
+}
+
+// validateFrontendTLSSet checks the frontend TLS settings the enabled proxies
+// must agree on: one certificate source per hostname, since Caddy picks a
+// certificate by name alone, and no port serving both plain HTTP and HTTPS,
+// since TLS is per listener
+func validateFrontendTLSSet(proxies []config.CaddyProxy) error {
+	modes := make(map[string]string)
+	plainPorts := make(map[int]bool)
+	tlsPorts := make(map[int]bool)
+	for _, proxy := range proxies {
+		if !proxy.Enabled {
+			continue
+		}
+		mode := FrontendTLSMode(proxy)
+		if mode == config.FrontendTLSHTTP {
+			plainPorts[proxy.Port] = true
+			continue
+		}
+		tlsPorts[proxy.Port] = true
+
+		hostname := NormalizeHostname(proxy.Hostname)
+		if previous, ok := modes[hostname]; ok && previous != mode {
+			return fmt.Errorf("%s can't use both %s and %s certificates; give its proxies the same frontend TLS mode", hostname, previous, mode)
+		}
+		modes[hostname] = mode
+	}
+	for port := range plainPorts {
+		if tlsPorts[port] {
+			return fmt.Errorf("port %d can't serve both plain HTTP and HTTPS proxies", port)
+		}
+	}
+	return nil
+}
+
+// renderTLSPolicies replaces the automation policies tailrelay manages in a
+// full Caddy config with one per hostname that needs one. They go first so
+// a catch-all policy from elsewhere doesn't shadow them. Default-mode
+// proxies outside the tailnet get no policy and keep Caddy's defaults.
+func renderTLSPolicies(cfg map[string]interface{}, proxies []config.CaddyProxy) {
+	policies := make(map[string]TLSPolicy)
+	for _, proxy := range proxies {
+		if !proxy.Enabled {
+			continue
+		}
+		hostname := NormalizeHostname(proxy.Hostname)
+		policy := TLSPolicy{ID: tlsPolicyIDPrefix + hostname, Subjects: []string{hostname}}
+		switch FrontendTLSMode(proxy) {
+		case config.FrontendTLSTailscale:
+			if !strings.HasSuffix(hostname, tailnetDomainSuffix) {
+				continue
+			}
+			policy.GetCertificate = []TLSCertManager{{Via: "tailscale"}}
+		case config.FrontendTLSInternal:
+			policy.Issuers = []TLSIssuer{{Module: "internal", Config: map[string]interface{}{"ca": InternalCAID}}}
+		default:
+			// Loaded certificates are picked by name and plain HTTP has none
+			continue
+		}
+		policies[hostname] = policy
+	}
+
+	apps := childMap(cfg, "apps")
+	tlsApp, hasTLS := apps["tls"].(map[string]interface{})
+	if !hasTLS && len(policies) == 0 {
+		return
+	}
+	if !hasTLS {
+		tlsApp = childMap(apps, "tls")
+	}
+	automation := childMap(tlsApp, "automation")
+	existing, _ := automation["policies"].([]interface{})
+
+	rendered := make([]interface{}, 0, len(existing)+len(policies))
+	for _, hostname := range sortedPolicyKeys(policies) {
+		rendered = append(rendered, policies[hostname])
+	}
+	for _, policyRaw := range existing {
+		if policy, ok := policyRaw.(map[string]interface{}); ok {
+			if id, _ := policy["@id"].(string); strings.HasPrefix(id, tlsPolicyIDPrefix) {
+				continue
+			}
+		}
+		rendered = append(rendered, policyRaw)
+	}
+
+	if len(rendered) > 0 {
+		automation["policies"] = rendered
+		return
+	}
+	delete(automation, "policies")
+	if len(automation) == 0 {
+		delete(tlsApp, "automation")
+	}
+	if len(tlsApp) == 0 {
+		delete(apps, "tls")
+	}
+}
+
+// renderPlainHTTP turns automatic HTTPS off for the hostnames of plain HTTP
+// proxies in each server tailrelay owns. placed lists the enabled proxies
+// rendered into each server.
+func (pm *ProxyManager) renderPlainHTTP(servers map[string]interface{}, placed map[string][]config.CaddyProxy) error {
+	for name, serverRaw := range servers {
+		server, ok := serverRaw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		skip := make(map[string]bool)
+		for _, proxy := range placed[name] {
+			if FrontendTLSMode(proxy) == config.FrontendTLSHTTP {
+				skip[NormalizeHostname(proxy.Hostname)] = true
+			}
+		}
+		if !pm.ownsServer(name) {
+			// Changing automatic HTTPS would affect the server's other routes
+			if len(skip) > 0 {
+				return fmt.Errorf("plain HTTP proxies need a port that isn't shared with server %s, which is managed outside the web UI", name)
+			}
+			continue
+		}
+
+		autoHTTPS := childMap(server, "automatic_https")
+		if len(skip) > 0 {
+			autoHTTPS["skip"] = sortedBoolKeys(skip)
+		} else {
+			delete(autoHTTPS, "skip")
+		}
+		if len(autoHTTPS) == 0 {
+			delete(server, "automatic_https")
+		}
+	}
+	return nil
+}
+
+// frontendCertificates lists the certificate files of enabled load_files proxies
+func frontendCertificates(proxies []config.CaddyProxy) []LoadedCertificate {
+	var loaded []LoadedCertificate
+	for _, proxy := range proxies {
+		if !proxy.Enabled || FrontendTLSMode(proxy) != config.FrontendTLSLoadFiles {
+			continue
+		}
+		loaded = append(loaded, LoadedCertificate{
+			CertFile: proxy.FrontendTLSCertFile,
+			KeyFile:  proxy.FrontendTLSKeyFile,
+		})
+	}
+	return loaded
+}
+
+// InternalCARoot returns the PEM root certificate of Caddy's internal CA,
+// which clients must trust to use proxies in the internal mode
+func (pm *ProxyManager) InternalCARoot() (string, error) {
+	ca, err := pm.client.GetPKICA(InternalCAID)
+	if err != nil {
+		return "", fmt.Errorf("get internal CA: %w", err)
+	}
+	if ca.RootCertificate == "" {
+		return "", fmt.Errorf("internal CA has no root certificate")
+	}
+	return ca.RootCertificate, nil
+}
+
+func sortedPolicyKeys(policies map[string]TLSPolicy) []string {
+	keys := make([]string, 0, len(policies))
+	for key := range policies {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
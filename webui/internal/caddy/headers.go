@@ -0,0 +1,184 @@
+package caddy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/config"
+)
+
+// upstreamHostPlaceholder is the Host value sent upstream unless the
+// proxy preserves the client's original Host header
+const upstreamHostPlaceholder = "{http.reverse_proxy.upstream.hostport}"
+
+// buildHeaders converts header rules (plus legacy custom headers) into
+// Caddy's reverse_proxy header configuration
+func buildHeaders(preserveHost bool, customHeaders map[string]string, rules []config.CaddyHeaderRule) (*HeaderConfig, error) {
+	headers := &HeaderConfig{
+		Request:  &HeaderOps{},
+		Response: &HeaderOps{},
+	}
+
+	if !preserveHost {
+		headers.Request.Set = map[string][]string{
+			"Host": []string{upstreamHostPlaceholder},
+		}
+	}
+
+	// Legacy custom headers are plain request "set" rules
+	for key, value := range customHeaders {
+		rules = append(rules, config.CaddyHeaderRule{
+			Direction: "request",
+			Operation: "set",
+			Field:     key,
+			Value:     value,
+		})
+	}
+
+	for i, rule := range rules {
+		field := strings.TrimSpace(rule.Field)
+		if field == "" {
+			return nil, fmt.Errorf("header rule %d: field is required", i+1)
+		}
+
+		var ops *HeaderOps
+		switch rule.Direction {
+		case "", "request":
+			ops = headers.Request
+		case "response":
+			ops = headers.Response
+		default:
+			return nil, fmt.Errorf("header rule %d: unsupported direction %q", i+1, rule.Direction)
+		}
+
+		switch rule.Operation {
+		case "add":
+			if ops.Add == nil {
+				ops.Add = make(map[string][]string)
+			}
+			ops.Add[field] = append(ops.Add[field], rule.Value)
+		case "set":
+			if ops.Set == nil {
+				ops.Set = make(map[string][]string)
+			}
+			ops.Set[field] = []string{rule.Value}
+		case "delete":
+			ops.Delete = append(ops.Delete, field)
+		case "replace":
+			if rule.Search == "" {
+				return nil, fmt.Errorf("header rule %d: search is required for replace", i+1)
+			}
+			if ops.Replace == nil {
+				ops.Replace = make(map[string][]HeaderReplacement)
+			}
+			ops.Replace[field] = append(ops.Replace[field], HeaderReplacement{
+				Search:  rule.Search,
+				Replace: rule.Value,
+			})
+		default:
+			return nil, fmt.Errorf("header rule %d: unsupported operation %q", i+1, rule.Operation)
+		}
+	}
+
+	if headerOpsEmpty(headers.Request) {
+		headers.Request = nil
+	}
+	if headerOpsEmpty(headers.Response) {
+		headers.Response = nil
+	}
+
+	return headers, nil
+}
+
+func headerOpsEmpty(ops *HeaderOps) bool {
+	return len(ops.Add) == 0 && len(ops.Set) == 0 && len(ops.Delete) == 0 && len(ops.Replace) == 0
+}
+
+// extractHeaderRules reads header rules back from a reverse_proxy handler.
+// The default upstream Host header is not reported as a rule; preserveHost
+// reports whether it was absent.
+func extractHeaderRules(reverseProxyHandler Handler) (rules []config.CaddyHeaderRule, preserveHost bool) {
+	preserveHost = true
+
+	headers, ok := reverseProxyHandler["headers"].(map[string]interface{})
+	if !ok {
+		return nil, preserveHost
+	}
+
+	for _, direction := range []string{"request", "response"} {
+		ops, ok := headers[direction].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		for _, operation := range []string{"set", "add"} {
+			opMap, ok := ops[operation].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for _, field := range sortedKeys(opMap) {
+				values, _ := opMap[field].([]interface{})
+				for _, valueRaw := range values {
+					value, _ := valueRaw.(string)
+					if direction == "request" && operation == "set" && strings.EqualFold(field, "Host") {
+						if value == upstreamHostPlaceholder || value == "{upstream_hostport}" {
+							preserveHost = false
+							continue
+						}
+					}
+					rules = append(rules, config.CaddyHeaderRule{
+						Direction: direction,
+						Operation: operation,
+						Field:     field,
+						Value:     value,
+					})
+				}
+			}
+		}
+
+		if deletes, ok := ops["delete"].([]interface{}); ok {
+			for _, fieldRaw := range deletes {
+				if field, ok := fieldRaw.(string); ok {
+					rules = append(rules, config.CaddyHeaderRule{
+						Direction: direction,
+						Operation: "delete",
+						Field:     field,
+					})
+				}
+			}
+		}
+
+		if replaceMap, ok := ops["replace"].(map[string]interface{}); ok {
+			for _, field := range sortedKeys(replaceMap) {
+				replacements, _ := replaceMap[field].([]interface{})
+				for _, replacementRaw := range replacements {
+					replacement, ok := replacementRaw.(map[string]interface{})
+					if !ok {
+						continue
+					}
+					search, _ := replacement["search"].(string)
+					value, _ := replacement["replace"].(string)
+					rules = append(rules, config.CaddyHeaderRule{
+						Direction: direction,
+						Operation: "replace",
+						Field:     field,
+						Value:     value,
+						Search:    search,
+					})
+				}
+			}
+		}
+	}
+
+	return rules, preserveHost
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
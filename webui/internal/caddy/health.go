@@ -0,0 +1,146 @@
+package caddy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/config"
+)
+
+// defaultFailDuration is how long Caddy remembers a failed request when
+// passive health checks are enabled without an explicit fail duration
+const defaultFailDuration = "30s"
+
+// UpstreamHealth reports the state of a single proxy upstream
+type UpstreamHealth struct {
+	Address     string `json:"address"`
+	Healthy     bool   `json:"healthy"`
+	NumRequests int    `json:"num_requests"`
+	Fails       int    `json:"fails"`
+
+	// Caddy doesn't report active check results, so the web UI runs the
+	// proxy's active check itself; nil until it has
+	ActiveHealthy *bool  `json:"active_healthy,omitempty"`
+	ActiveError   string `json:"active_error,omitempty"`
+}
+
+// buildHealthChecks converts proxy health check settings to Caddy's form
+func buildHealthChecks(hc config.CaddyHealthChecks) (*HealthChecks, error) {
+	for _, duration := range []string{hc.Interval, hc.Timeout, hc.FailDuration, hc.UnhealthyLatency} {
+		if duration == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(duration); err != nil {
+			return nil, fmt.Errorf("invalid duration %q", duration)
+		}
+	}
+
+	healthChecks := &HealthChecks{}
+
+	if hc.URI != "" {
+		healthChecks.Active = &ActiveHealthChecks{
+			URI:          hc.URI,
+			Interval:     hc.Interval,
+			Timeout:      hc.Timeout,
+			ExpectStatus: hc.ExpectStatus,
+			ExpectBody:   hc.ExpectBody,
+		}
+	}
+
+	if hc.MaxFails > 0 || hc.UnhealthyLatency != "" {
+		failDuration := hc.FailDuration
+		if failDuration == "" {
+			failDuration = defaultFailDuration
+		}
+		healthChecks.Passive = &PassiveHealthChecks{
+			FailDuration:     failDuration,
+			MaxFails:         hc.MaxFails,
+			UnhealthyLatency: hc.UnhealthyLatency,
+		}
+	}
+
+	if healthChecks.Active == nil && healthChecks.Passive == nil {
+		return nil, nil
+	}
+
+	return healthChecks, nil
+}
+
+// extractHealthChecks reads health check settings back from a reverse_proxy handler
+func extractHealthChecks(reverseProxyHandler Handler) *config.CaddyHealthChecks {
+	healthRaw, ok := reverseProxyHandler["health_checks"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	hc := &config.CaddyHealthChecks{}
+	if active, ok := healthRaw["active"].(map[string]interface{}); ok {
+		hc.URI, _ = active["uri"].(string)
+		hc.Interval = durationValue(active["interval"])
+		hc.Timeout = durationValue(active["timeout"])
+		hc.ExpectBody, _ = active["expect_body"].(string)
+		if status, ok := active["expect_status"].(float64); ok {
+			hc.ExpectStatus = int(status)
+		}
+	}
+	if passive, ok := healthRaw["passive"].(map[string]interface{}); ok {
+		hc.FailDuration = durationValue(passive["fail_duration"])
+		hc.UnhealthyLatency = durationValue(passive["unhealthy_latency"])
+		if maxFails, ok := passive["max_fails"].(float64); ok {
+			hc.MaxFails = int(maxFails)
+		}
+	}
+
+	return hc
+}
+
+// ProxyTargets lists every upstream address a proxy dials
+func ProxyTargets(proxy config.CaddyProxy) []string {
+	var targets []string
+	if proxy.Target != "" {
+		targets = append(targets, proxy.Target)
+	}
+	targets = append(targets, proxy.Upstreams...)
+	for _, rule := range proxy.PathRules {
+		if rule.Target != "" {
+			targets = append(targets, rule.Target)
+		}
+	}
+	return targets
+}
+
+// GetProxyHealth matches Caddy's upstream pool against each proxy's targets.
+// Caddy only reports request and failure counts, so an upstream is considered
+// healthy when it is known to Caddy and has fewer recent failures than the
+// proxy's passive max_fails threshold (1 when passive checks are off).
+func (pm *ProxyManager) GetProxyHealth(proxies []config.CaddyProxy) (map[string][]UpstreamHealth, error) {
+	statuses, err := pm.client.GetReverseProxyUpstreams()
+	if err != nil {
+		return nil, err
+	}
+
+	byAddress := make(map[string]UpstreamStatus, len(statuses))
+	for _, status := range statuses {
+		byAddress[status.Address] = status
+	}
+
+	health := make(map[string][]UpstreamHealth, len(proxies))
+	for _, proxy := range proxies {
+		maxFails := 1
+		if proxy.HealthChecks != nil && proxy.HealthChecks.MaxFails > 0 {
+			maxFails = proxy.HealthChecks.MaxFails
+		}
+
+		for _, target := range ProxyTargets(proxy) {
+			entry := UpstreamHealth{Address: target}
+			if status, ok := byAddress[target]; ok && proxy.Enabled {
+				entry.NumRequests = status.NumRequests
+				entry.Fails = status.Fails
+				entry.Healthy = status.Fails < maxFails
+			}
+			health[proxy.ID] = append(health[proxy.ID], entry)
+		}
+	}
+
+	return health, nil
+}
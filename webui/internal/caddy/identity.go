@@ -0,0 +1,167 @@
+package caddy
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/config"
+)
+
+const (
+	// IdentityVerifyPath is the web UI endpoint Caddy asks before proxying
+	// requests for proxies that require a tailnet identity
+	IdentityVerifyPath = "/api/identity/verify"
+	// IdentityRemoteAddrHeader carries the original client address to the verify endpoint
+	IdentityRemoteAddrHeader = "X-Tailrelay-Remote-Addr"
+	// IdentitySecretHeader carries the per-install secret that shows a verify
+	// request comes from Caddy. With userspace networking tailnet peers reach
+	// the web UI over loopback too, so the source address proves nothing.
+	IdentitySecretHeader = "X-Tailrelay-Identity-Secret"
+	// IdentityUserLoginHeader and IdentityUserNameHeader are injected upstream on success
+	IdentityUserLoginHeader = "Tailscale-User-Login"
+	IdentityUserNameHeader  = "Tailscale-User-Name"
+)
+
+// identityHeaders are copied from a successful verify response to the upstream request
+var identityHeaders = []string{IdentityUserLoginHeader, IdentityUserNameHeader}
+
+// SetIdentityEndpoint sets the dial address Caddy uses to reach the web UI's
+// identity verify endpoint (e.g. "127.0.0.1:8021") and the secret it sends
+func (pm *ProxyManager) SetIdentityEndpoint(dial, secret string) {
+	pm.identityDial = dial
+	pm.identitySecret = secret
+}
+
+// buildIdentityHandler builds a forward_auth style reverse_proxy that asks the
+// web UI to resolve the caller through Tailscale whois. Non-2xx answers are
+// returned to the client as-is; on 2xx the identity headers are copied onto
+// the request (after dropping any client-supplied values) and the chain continues.
+func (pm *ProxyManager) buildIdentityHandler(policy config.CaddyIdentityPolicy) (Handler, error) {
+	if pm.identityDial == "" || pm.identitySecret == "" {
+		return nil, fmt.Errorf("identity verification endpoint is not configured")
+	}
+
+	copyRoutes := []map[string]interface{}{
+		{
+			"handle": []Handler{
+				{
+					"handler": "headers",
+					"request": HeaderOps{Delete: identityHeaders},
+				},
+			},
+		},
+	}
+	for _, header := range identityHeaders {
+		placeholder := fmt.Sprintf("{http.reverse_proxy.header.%s}", header)
+		copyRoutes = append(copyRoutes, map[string]interface{}{
+			"match": []map[string]interface{}{
+				{"not": []map[string]interface{}{
+					{"vars": map[string][]string{placeholder: {""}}},
+				}},
+			},
+			"handle": []Handler{
+				{
+					"handler": "headers",
+					"request": HeaderOps{Set: map[string][]string{header: {placeholder}}},
+				},
+			},
+		})
+	}
+
+	return Handler{
+		"handler":   "reverse_proxy",
+		"upstreams": []Upstream{{Dial: pm.identityDial}},
+		"rewrite": map[string]string{
+			"method": "GET",
+			"uri":    identityVerifyURI(policy),
+		},
+		"headers": HeaderConfig{
+			Request: &HeaderOps{
+				Set: map[string][]string{
+					IdentityRemoteAddrHeader: {"{http.request.remote}"},
+					IdentitySecretHeader:     {pm.identitySecret},
+					"X-Forwarded-Method":     {"{http.request.method}"},
+					"X-Forwarded-Uri":        {"{http.request.uri}"},
+				},
+			},
+		},
+		"handle_response": []map[string]interface{}{
+			{
+				"match":  map[string]interface{}{"status_code": []int{2}},
+				"routes": copyRoutes,
+			},
+		},
+	}, nil
+}
+
+// identityVerifyURI encodes the allow lists into the verify URI so the Caddy
+// config fully describes the policy and survives discovery
+func identityVerifyURI(policy config.CaddyIdentityPolicy) string {
+	query := url.Values{}
+	if len(policy.AllowUsers) > 0 {
+		query.Set("users", strings.Join(policy.AllowUsers, ","))
+	}
+	if len(policy.AllowNodes) > 0 {
+		query.Set("nodes", strings.Join(policy.AllowNodes, ","))
+	}
+	if len(policy.AllowTags) > 0 {
+		query.Set("tags", strings.Join(policy.AllowTags, ","))
+	}
+	if len(query) == 0 {
+		return IdentityVerifyPath
+	}
+	return IdentityVerifyPath + "?" + query.Encode()
+}
+
+// ParseIdentityPolicy reads an identity policy from verify endpoint query values
+func ParseIdentityPolicy(query url.Values) config.CaddyIdentityPolicy {
+	split := func(value string) []string {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+
+	return config.CaddyIdentityPolicy{
+		Enabled:    true,
+		AllowUsers: split(query.Get("users")),
+		AllowNodes: split(query.Get("nodes")),
+		AllowTags:  split(query.Get("tags")),
+	}
+}
+
+// isIdentityHandler reports whether a raw handler is the identity check
+// rather than a proxy upstream
+func isIdentityHandler(handler map[string]interface{}) bool {
+	if handler["handler"] != "reverse_proxy" {
+		return false
+	}
+	rewrite, ok := handler["rewrite"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	uri, _ := rewrite["uri"].(string)
+	return strings.HasPrefix(uri, IdentityVerifyPath)
+}
+
+// extractIdentityPolicy reads the identity policy back from a route's subroute
+func extractIdentityPolicy(route Route) *config.CaddyIdentityPolicy {
+	for _, handler := range subrouteHandlers(route) {
+		if !isIdentityHandler(handler) {
+			continue
+		}
+		rewrite, _ := handler["rewrite"].(map[string]interface{})
+		uri, _ := rewrite["uri"].(string)
+		parsed, err := url.Parse(uri)
+		if err != nil {
+			continue
+		}
+		policy := ParseIdentityPolicy(parsed.Query())
+		return &policy
+	}
+	return nil
+}
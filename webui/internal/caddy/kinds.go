@@ -0,0 +1,287 @@
+package caddy
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/config"
+)
+
+// fileServerDirName is the directory under the state dir that file_server
+// roots are confined to, keeping the state files themselves out of reach
+const fileServerDirName = "www"
+
+// tailscaledStateFile is the name of the file tailscaled keeps the node's
+// keys in, in the state dir
+const tailscaledStateFile = "tailscaled.state"
+
+// SetStateDir sets the state directory whose www subdirectory file_server
+// routes are confined to. protected lists files and directories, such as
+// the web UI token, that no file_server root may contain.
+func (pm *ProxyManager) SetStateDir(dir string, protected []string) {
+	pm.stateDir = dir
+	pm.protectedPaths = append([]string(nil), protected...)
+}
+
+// fileServerBase is the directory file_server roots are relative to
+func (pm *ProxyManager) fileServerBase() string {
+	return filepath.Join(filepath.Clean(pm.stateDir), fileServerDirName)
+}
+
+// fileServerProtected lists the paths no file_server root may expose
+func (pm *ProxyManager) fileServerProtected() []string {
+	var protected []string
+	if pm.stateDir != "" {
+		protected = append(protected, filepath.Join(filepath.Clean(pm.stateDir), tailscaledStateFile))
+	}
+	// Unset paths would clean to "." and hide every file
+	for _, path := range append([]string{pm.metadataPath, pm.serverMapPath}, pm.protectedPaths...) {
+		if path != "" {
+			protected = append(protected, path)
+		}
+	}
+	return protected
+}
+
+// fileServerHidden lists the names file_server pretends don't exist, so a
+// symlink or copy inside a root still doesn't expose a protected file
+func (pm *ProxyManager) fileServerHidden() []string {
+	seen := make(map[string]bool)
+	var hidden []string
+	for _, path := range pm.fileServerProtected() {
+		for _, entry := range []string{filepath.Clean(path), filepath.Base(path)} {
+			if !seen[entry] {
+				seen[entry] = true
+				hidden = append(hidden, entry)
+			}
+		}
+	}
+	return hidden
+}
+
+// isReverseProxyKind reports whether a proxy forwards to upstreams
+func isReverseProxyKind(kind string) bool {
+	return kind == "" || kind == config.ProxyKindReverseProxy
+}
+
+// buildKindHandler builds the main handler for proxies that are not reverse proxies
+func (pm *ProxyManager) buildKindHandler(proxy config.CaddyProxy) (Handler, error) {
+	switch proxy.Kind {
+	case config.ProxyKindRedirect:
+		if proxy.Redirect == nil {
+			return nil, fmt.Errorf("redirect settings are required")
+		}
+		return buildRedirectHandler(*proxy.Redirect)
+	case config.ProxyKindStaticResponse:
+		if proxy.StaticResponse == nil {
+			return nil, fmt.Errorf("static response settings are required")
+		}
+		return buildStaticResponseHandler(*proxy.StaticResponse)
+	case config.ProxyKindFileServer:
+		if proxy.FileServer == nil {
+			return nil, fmt.Errorf("file server settings are required")
+		}
+		return pm.buildFileServerHandler(*proxy.FileServer)
+	default:
+		return nil, fmt.Errorf("unsupported kind %q", proxy.Kind)
+	}
+}
+
+func buildRedirectHandler(redirect config.CaddyRedirect) (Handler, error) {
+	location := strings.TrimSpace(redirect.URL)
+	if location == "" {
+		return nil, fmt.Errorf("redirect URL is required")
+	}
+	status := redirect.StatusCode
+	if status == 0 {
+		status = http.StatusFound
+	}
+	if status < 300 || status > 399 {
+		return nil, fmt.Errorf("redirect status code must be 3xx, got %d", status)
+	}
+
+	return Handler{
+		"handler":     "static_response",
+		"status_code": strconv.Itoa(status),
+		"headers":     map[string][]string{"Location": {location}},
+	}, nil
+}
+
+func buildStaticResponseHandler(static config.CaddyStaticResponse) (Handler, error) {
+	status := static.StatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status < 100 || status > 599 {
+		return nil, fmt.Errorf("invalid status code %d", status)
+	}
+
+	handler := Handler{
+		"handler":     "static_response",
+		"status_code": strconv.Itoa(status),
+	}
+	if static.Body != "" {
+		handler["body"] = static.Body
+	}
+	if len(static.Headers) > 0 {
+		headers := make(map[string][]string, len(static.Headers))
+		for field, value := range static.Headers {
+			if strings.TrimSpace(field) == "" {
+				return nil, fmt.Errorf("header name is required")
+			}
+			headers[field] = []string{value}
+		}
+		handler["headers"] = headers
+	}
+	return handler, nil
+}
+
+func (pm *ProxyManager) buildFileServerHandler(fileServer config.CaddyFileServer) (Handler, error) {
+	root, err := pm.resolveFileServerRoot(fileServer.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	handler := Handler{
+		"handler": "file_server",
+		"root":    root,
+		"hide":    pm.fileServerHidden(),
+	}
+	if fileServer.Browse {
+		handler["browse"] = map[string]interface{}{}
+	}
+	return handler, nil
+}
+
+// resolveFileServerRoot turns a root relative to the www dir into an
+// absolute path, refusing anything outside it or holding a protected file
+func (pm *ProxyManager) resolveFileServerRoot(root string) (string, error) {
+	if pm.stateDir == "" {
+		return "", fmt.Errorf("state directory is not configured")
+	}
+	root = strings.TrimSpace(root)
+	if root == "" {
+		return "", fmt.Errorf("file server root is required")
+	}
+
+	base := pm.fileServerBase()
+	resolved := filepath.Clean(root)
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(base, resolved)
+	}
+	if !pathWithin(base, resolved) {
+		return "", fmt.Errorf("file server root must be inside %s", base)
+	}
+	// A symlink in the www dir mustn't lead back out of it
+	real := resolved
+	if target, err := filepath.EvalSymlinks(resolved); err == nil {
+		realBase, err := filepath.EvalSymlinks(base)
+		if err != nil || !pathWithin(realBase, target) {
+			return "", fmt.Errorf("file server root must be inside %s", base)
+		}
+		real = target
+	}
+
+	for _, protected := range pm.fileServerProtected() {
+		protected = filepath.Clean(protected)
+		realProtected := protected
+		if target, err := filepath.EvalSymlinks(protected); err == nil {
+			realProtected = target
+		}
+		for _, candidate := range []string{protected, realProtected} {
+			if pathWithin(resolved, candidate) || pathWithin(candidate, resolved) ||
+				pathWithin(real, candidate) || pathWithin(candidate, real) {
+				return "", fmt.Errorf("file server root %s would expose %s", resolved, protected)
+			}
+		}
+	}
+	return resolved, nil
+}
+
+// pathWithin reports whether path is dir or inside it
+func pathWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// extractKindHandler finds the unmatched static_response or file_server
+// handler that a non-proxy route serves
+func extractKindHandler(route Route) Handler {
+	candidates := route.Handle
+	if len(route.Handle) > 0 && route.Handle[0]["handler"] == "subroute" {
+		candidates = nil
+		routesRaw, _ := route.Handle[0]["routes"].([]interface{})
+		for _, routeRaw := range routesRaw {
+			routeMap, ok := routeRaw.(map[string]interface{})
+			if !ok || routeMap["match"] != nil {
+				continue
+			}
+			handlesRaw, _ := routeMap["handle"].([]interface{})
+			for _, handleRaw := range handlesRaw {
+				if handleMap, ok := handleRaw.(map[string]interface{}); ok {
+					candidates = append(candidates, handleMap)
+				}
+			}
+		}
+	}
+
+	for _, handler := range candidates {
+		switch handler["handler"] {
+		case "static_response", "file_server":
+			return handler
+		}
+	}
+	return nil
+}
+
+// applyKindHandler fills in a proxy's kind settings from its Caddy handler
+func (pm *ProxyManager) applyKindHandler(proxy *config.CaddyProxy, handler Handler) {
+	switch handler["handler"] {
+	case "file_server":
+		proxy.Kind = config.ProxyKindFileServer
+		root, _ := handler["root"].(string)
+		if pm.stateDir != "" && pathWithin(pm.fileServerBase(), root) {
+			root, _ = filepath.Rel(pm.fileServerBase(), root)
+		}
+		_, browse := handler["browse"]
+		proxy.FileServer = &config.CaddyFileServer{Root: root, Browse: browse}
+	case "static_response":
+		status := statusCodeValue(handler["status_code"])
+		headers := map[string]string{}
+		if headersRaw, ok := handler["headers"].(map[string]interface{}); ok {
+			for _, field := range sortedKeys(headersRaw) {
+				if values, ok := headersRaw[field].([]interface{}); ok && len(values) > 0 {
+					headers[field], _ = values[0].(string)
+				}
+			}
+		}
+
+		if location, ok := headers["Location"]; ok && status >= 300 && status <= 399 {
+			proxy.Kind = config.ProxyKindRedirect
+			proxy.Redirect = &config.CaddyRedirect{URL: location, StatusCode: status}
+			return
+		}
+
+		proxy.Kind = config.ProxyKindStaticResponse
+		body, _ := handler["body"].(string)
+		proxy.StaticResponse = &config.CaddyStaticResponse{StatusCode: status, Body: body}
+		if len(headers) > 0 {
+			proxy.StaticResponse.Headers = headers
+		}
+	}
+}
+
+// statusCodeValue reads a status code Caddy may store as a number or string
+func statusCodeValue(raw interface{}) int {
+	switch value := raw.(type) {
+	case float64:
+		return int(value)
+	case string:
+		status, _ := strconv.Atoi(value)
+		return status
+	}
+	return 0
+}
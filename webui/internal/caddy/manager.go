@@ -1,8 +1,10 @@
 package caddy
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/sudocarlos/tailrelay-webui/internal/config"
 )
@@ -14,7 +16,8 @@ type Manager struct {
 	serverMap    string
 }
 
-// NewManager creates a new Caddy manager using the API
+// NewManager creates a new Caddy manager using the API. apiURL is an admin
+// address as accepted by NewAPIClient.
 func NewManager(apiURL, serverMapPath string) *Manager {
 	if apiURL == "" {
 		apiURL = DefaultAdminAPI
@@ -29,6 +32,54 @@ func NewManager(apiURL, serverMapPath string) *Manager {
 	}
 }
 
+// SetAdminOrigin sets the Origin header sent to Caddy's admin API
+func (m *Manager) SetAdminOrigin(origin string) {
+	m.proxyManager.client.Origin = origin
+}
+
+// WaitReady blocks until Caddy's admin API answers or the timeout passes
+func (m *Manager) WaitReady(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return m.proxyManager.client.WaitReady(ctx)
+}
+
+// APIBreaker returns the state of the admin API circuit breaker
+func (m *Manager) APIBreaker() BreakerStatus {
+	return m.proxyManager.client.Breaker()
+}
+
+// SetIdentityEndpoint sets the address Caddy dials for tailnet identity checks
+// and the secret it proves itself with
+func (m *Manager) SetIdentityEndpoint(dial, secret string) {
+	m.proxyManager.SetIdentityEndpoint(dial, secret)
+}
+
+// SetStateDir sets the state directory whose www subdirectory file_server
+// routes are confined to, and the paths they must never expose
+func (m *Manager) SetStateDir(dir string, protected []string) {
+	m.proxyManager.SetStateDir(dir, protected)
+}
+
+// SeedLoadedCertificates sets the certificate files tailrelay loads into
+// Caddy for the next apply, without applying
+func (m *Manager) SeedLoadedCertificates(loaded []LoadedCertificate) {
+	m.proxyManager.SeedLoadedCertificates(loaded)
+}
+
+// SetLoadedCertificates replaces the certificate files tailrelay loads into Caddy
+func (m *Manager) SetLoadedCertificates(loaded []LoadedCertificate) error {
+	if err := m.proxyManager.SetLoadedCertificates(loaded); err != nil {
+		return fmt.Errorf("failed to load certificates: %w", err)
+	}
+	return nil
+}
+
+// InternalCARoot returns the PEM root certificate of Caddy's internal CA
+func (m *Manager) InternalCARoot() (string, error) {
+	return m.proxyManager.InternalCARoot()
+}
+
 // AddProxy adds a new reverse proxy via Caddy API
 func (m *Manager) AddProxy(proxy config.CaddyProxy) (*config.CaddyProxy, error) {
 	created, err := m.proxyManager.AddProxy(proxy)
@@ -39,6 +90,11 @@ func (m *Manager) AddProxy(proxy config.CaddyProxy) (*config.CaddyProxy, error)
 	return created, nil
 }
 
+// ValidateProxy checks a proxy's settings without applying them
+func (m *Manager) ValidateProxy(proxy config.CaddyProxy) error {
+	return m.proxyManager.ValidateProxy(proxy)
+}
+
 // GetProxy retrieves a proxy by ID
 func (m *Manager) GetProxy(id string) (*config.CaddyProxy, error) {
 	return m.proxyManager.GetProxy(id)
@@ -67,6 +123,51 @@ func (m *Manager) ListProxies() ([]config.CaddyProxy, error) {
 	return m.proxyManager.ListProxies()
 }
 
+// ExportCaddyfile renders the enabled proxies in metadata as a Caddyfile,
+// with basic auth password hashes only when passwordHashes is set
+func (m *Manager) ExportCaddyfile(passwordHashes bool) (string, error) {
+	proxies, err := m.proxyManager.ListProxies()
+	if err != nil {
+		return "", err
+	}
+	return m.proxyManager.RenderCaddyfile(proxies, passwordHashes)
+}
+
+// PreviewCaddyfileImport reports what importing a Caddyfile would create
+func (m *Manager) PreviewCaddyfileImport(data string, reserve PortReserver) (*ImportPreview, error) {
+	return m.proxyManager.PreviewCaddyfileImport(data, reserve)
+}
+
+// ImportCaddyfile creates the new proxies of a Caddyfile
+func (m *Manager) ImportCaddyfile(data string, reserve PortReserver) (*ImportPreview, []config.CaddyProxy, error) {
+	preview, created, err := m.proxyManager.ImportCaddyfile(data, reserve)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to import Caddyfile: %w", err)
+	}
+	log.Printf("Imported %d proxies from Caddyfile", len(created))
+	return preview, created, nil
+}
+
+// ListenPorts returns the ports live Caddy servers listen on
+func (m *Manager) ListenPorts() (map[int]string, error) {
+	return m.proxyManager.ListenPorts()
+}
+
+// ForeignRoutes lists the Caddy routes managed outside the web UI
+func (m *Manager) ForeignRoutes() ([]ForeignRoute, error) {
+	return m.proxyManager.ForeignRoutes()
+}
+
+// AdoptRoute brings a foreign route under the web UI's management
+func (m *Manager) AdoptRoute(key string, reserve PortReserver) (*config.CaddyProxy, error) {
+	proxy, err := m.proxyManager.AdoptRoute(key, reserve)
+	if err != nil {
+		return nil, fmt.Errorf("failed to adopt route: %w", err)
+	}
+	log.Printf("Route adopted as proxy: %s", proxy.ID)
+	return proxy, nil
+}
+
 // ToggleProxy enables or disables a proxy
 func (m *Manager) ToggleProxy(id string, enabled bool) error {
 	if err := m.proxyManager.ToggleProxy(id, enabled); err != nil {
@@ -80,6 +181,24 @@ func (m *Manager) ToggleProxy(id string, enabled bool) error {
 	return nil
 }
 
+// SetBasicAuthUser adds or updates a basic auth account on a proxy
+func (m *Manager) SetBasicAuthUser(id, username, password string) error {
+	if err := m.proxyManager.SetBasicAuthUser(id, username, password); err != nil {
+		return fmt.Errorf("failed to set basic auth user: %w", err)
+	}
+	log.Printf("Basic auth user %s set on proxy %s", username, id)
+	return nil
+}
+
+// DeleteBasicAuthUser removes a basic auth account from a proxy
+func (m *Manager) DeleteBasicAuthUser(id, username string) error {
+	if err := m.proxyManager.DeleteBasicAuthUser(id, username); err != nil {
+		return fmt.Errorf("failed to delete basic auth user: %w", err)
+	}
+	log.Printf("Basic auth user %s removed from proxy %s", username, id)
+	return nil
+}
+
 // GetStatus checks if Caddy API is accessible
 func (m *Manager) GetStatus() (bool, error) {
 	return m.proxyManager.GetStatus()
@@ -90,13 +209,9 @@ func (m *Manager) GetUpstreams() ([]UpstreamStatus, error) {
 	return m.proxyManager.GetUpstreams()
 }
 
-// InitializeServer ensures the HTTP server is configured in Caddy
-func (m *Manager) InitializeServer(listenAddrs []string) error {
-	if err := m.proxyManager.InitializeServer(listenAddrs); err != nil {
-		return fmt.Errorf("failed to initialize server: %w", err)
-	}
-	log.Printf("Server initialized")
-	return nil
+// GetProxyHealth returns per-upstream health for each proxy, keyed by proxy ID
+func (m *Manager) GetProxyHealth(proxies []config.CaddyProxy) (map[string][]UpstreamHealth, error) {
+	return m.proxyManager.GetProxyHealth(proxies)
 }
 
 // MigrateExistingProxies migrates existing Caddy proxies to metadata storage
@@ -104,6 +219,18 @@ func (m *Manager) MigrateExistingProxies() error {
 	return m.proxyManager.MigrateExistingProxies()
 }
 
+// DetectDrift compares proxy metadata with the live Caddy config
+func (m *Manager) DetectDrift() (*DriftReport, error) {
+	return m.proxyManager.DetectDrift()
+}
+
+// StartReconciler periodically checks for drift until stop is closed,
+// re-applying metadata when autoHeal is set
+func (m *Manager) StartReconciler(interval time.Duration, autoHeal bool, stop <-chan struct{}) {
+	NewReconciler(m.proxyManager, interval, autoHeal).Start(stop)
+	log.Printf("Caddy reconciler started (interval: %s, auto-heal: %v)", interval, autoHeal)
+}
+
 // InitializeAutostart starts all proxies with autostart enabled
 func (m *Manager) InitializeAutostart() error {
 	proxies, err := m.ListProxies()
@@ -8,7 +8,8 @@ import (
 )
 
 // MigrateExistingProxies discovers and syncs existing proxies from Caddy to metadata storage
-// This runs on every startup to ensure the UI tracks all proxies in Caddy
+// This runs on every startup to ensure the UI tracks all of its proxies in Caddy. Routes
+// tailrelay doesn't own are left alone; see ForeignRoutes and AdoptRoute.
 func (pm *ProxyManager) MigrateExistingProxies() error {
 	logger.Info("caddy", "Discovering existing proxies in Caddy...")
 
@@ -38,9 +39,12 @@ func (pm *ProxyManager) MigrateExistingProxies() error {
 		return nil // Don't fail, just skip discovery
 	}
 
+	ownership := pm.newProxyOwnership(existing)
+
 	var discoveredProxies []config.CaddyProxy
 	discovered := 0
 	updated := 0
+	foreign := 0
 
 	for serverName, server := range servers {
 		if server == nil {
@@ -52,6 +56,11 @@ func (pm *ProxyManager) MigrateExistingProxies() error {
 				logger.Warn("caddy", "Failed to convert route to proxy in server %s: %v", serverName, err)
 				continue
 			}
+			if !ownership.manages(route, proxy) {
+				logger.Info("caddy", "Leaving externally managed route %s:%d in server %s alone; adopt it to manage it here", proxy.Hostname, proxy.Port, serverName)
+				foreign++
+				continue
+			}
 
 			// Try to find existing proxy by ID first, then by hostname:port
 			var existingProxy *config.CaddyProxy
@@ -87,6 +96,7 @@ func (pm *ProxyManager) MigrateExistingProxies() error {
 			if existingProxy != nil {
 				// Preserve existing settings (especially autostart)
 				proxy.Autostart = existingProxy.Autostart
+				preserveMetadataOnly(proxy, *existingProxy)
 				proxy.Enabled = true // If it's in Caddy, it's enabled
 				logger.Debug("caddy", "Found existing proxy in metadata: %s (ID: %s)", proxy.Hostname, proxy.ID)
 				updated++
@@ -99,7 +109,11 @@ func (pm *ProxyManager) MigrateExistingProxies() error {
 			}
 
 			discoveredProxies = append(discoveredProxies, *proxy)
-			pm.updateServerMap(*proxy, serverName)
+			listen := ""
+			if port, ok := parseListenPort(server.Listen); ok {
+				listen = listenAddress(port)
+			}
+			pm.recordRoute(proxy.ID, serverName, route.ID, listen)
 		}
 	}
 
@@ -132,6 +146,18 @@ func (pm *ProxyManager) MigrateExistingProxies() error {
 	} else {
 		logger.Info("caddy", "No proxies found in Caddy")
 	}
+	if foreign > 0 {
+		logger.Info("caddy", "%d route(s) in Caddy are managed outside the web UI", foreign)
+	}
 
 	return nil
 }
+
+// preserveMetadataOnly copies the settings a proxy read back from its Caddy
+// route can't carry, so discovery doesn't reset them
+func preserveMetadataOnly(proxy *config.CaddyProxy, existing config.CaddyProxy) {
+	proxy.TLSPinnedSHA256 = existing.TLSPinnedSHA256
+	proxy.FrontendTLSMode = existing.FrontendTLSMode
+	proxy.FrontendTLSCertFile = existing.FrontendTLSCertFile
+	proxy.FrontendTLSKeyFile = existing.FrontendTLSKeyFile
+}
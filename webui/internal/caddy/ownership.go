@@ -0,0 +1,226 @@
+package caddy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/config"
+	"github.com/sudocarlos/tailrelay-webui/internal/logger"
+)
+
+// Everything tailrelay creates in Caddy carries an ownership marker, so
+// routes and servers from a hand-written Caddyfile or config are never
+// adopted, rewritten or removed unless the user adopts them explicitly.
+const (
+	ownerPrefix = "tailrelay_"
+	// routeIDPrefix starts the @id of a proxy's route; it also keeps that @id
+	// distinct from the one on the proxy's main handler
+	routeIDPrefix = ownerPrefix + "route_"
+	// legacyRouteIDPrefix is the route @id prefix used before ownership markers
+	legacyRouteIDPrefix = "route_"
+	// serverNamePrefix starts the names of servers tailrelay creates, so they
+	// never collide with the srvN names Caddy gives Caddyfile servers
+	serverNamePrefix = ownerPrefix + "srv"
+)
+
+// ownedServerName is the name of the index-th server tailrelay creates
+func ownedServerName(index int) string {
+	return fmt.Sprintf("%s%d", serverNamePrefix, index)
+}
+
+// routeIDProxy returns the proxy ID carried by a route @id, if any
+func routeIDProxy(routeID string) string {
+	if strings.HasPrefix(routeID, routeIDPrefix) {
+		return strings.TrimPrefix(routeID, routeIDPrefix)
+	}
+	return strings.TrimPrefix(routeID, legacyRouteIDPrefix)
+}
+
+// ownsServer reports whether tailrelay created a server. Servers from before
+// ownership markers count when the server map recorded them for a proxy.
+func (pm *ProxyManager) ownsServer(name string) bool {
+	if strings.HasPrefix(name, serverNamePrefix) {
+		return true
+	}
+
+	pm.mapMu.Lock()
+	defer pm.mapMu.Unlock()
+	for _, location := range pm.serverMap.ByProxyID {
+		if location.Server == name {
+			return true
+		}
+	}
+	return false
+}
+
+// routeOwner returns the ID of the proxy a route belongs to, or "" for a
+// foreign route. Marked routes are always tailrelay's; unmarked ones only
+// when they carry the ID of a known proxy (routes from before the markers).
+func routeOwner(route Route, known map[string]bool) string {
+	if strings.HasPrefix(route.ID, routeIDPrefix) {
+		return strings.TrimPrefix(route.ID, routeIDPrefix)
+	}
+	return routeProxyID(route, known)
+}
+
+// proxyOwnership decides which live routes tailrelay manages
+type proxyOwnership struct {
+	known     map[string]bool // Proxy IDs in metadata or the server map
+	hostPorts map[string]bool // hostname:port of proxies in metadata
+}
+
+func (pm *ProxyManager) newProxyOwnership(proxies []config.CaddyProxy) proxyOwnership {
+	ownership := proxyOwnership{
+		known:     pm.ownedProxyIDs(proxies),
+		hostPorts: make(map[string]bool),
+	}
+	for _, proxy := range proxies {
+		if proxy.Hostname != "" && proxy.Port != 0 {
+			ownership.hostPorts[hostPortKey(proxy.Hostname, proxy.Port)] = true
+		}
+	}
+	return ownership
+}
+
+// manages reports whether a live route (read as proxy) is tailrelay's.
+// Unmarked routes matching a stored proxy's hostname:port were adopted by
+// versions that adopted every route, and stay managed.
+func (o proxyOwnership) manages(route Route, proxy *config.CaddyProxy) bool {
+	if routeOwner(route, o.known) != "" {
+		return true
+	}
+	return proxy != nil && o.hostPorts[hostPortKey(proxy.Hostname, proxy.Port)]
+}
+
+func hostPortKey(hostname string, port int) string {
+	return fmt.Sprintf("%s:%d", NormalizeHostname(hostname), port)
+}
+
+// ErrForeignRouteNotFound is returned when adopting a route that is gone or
+// has changed since it was listed
+var ErrForeignRouteNotFound = errors.New("foreign route not found")
+
+// ForeignRoute is a live Caddy route tailrelay doesn't manage. It is shown
+// read-only until adopted.
+type ForeignRoute struct {
+	Key    string            `json:"key"` // Identifies the route for AdoptRoute
+	Server string            `json:"server"`
+	Proxy  config.CaddyProxy `json:"proxy"` // The route as the web UI would manage it
+}
+
+// foreignRouteKey identifies a foreign route by its server and content, so
+// an adopt request can't hit a route that moved or changed in the meantime
+func foreignRouteKey(serverName string, route Route) string {
+	data, err := json.Marshal(route)
+	if err != nil {
+		return ""
+	}
+	// Re-encode generically so map keys come out in one order
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return ""
+	}
+	if data, err = json.Marshal(generic); err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return serverName + "/" + hex.EncodeToString(sum[:8])
+}
+
+// ForeignRoutes lists the proxy-like routes in Caddy that tailrelay doesn't
+// manage
+func (pm *ProxyManager) ForeignRoutes() ([]ForeignRoute, error) {
+	proxies, err := LoadProxyMetadata(pm.metadataPath)
+	if err != nil {
+		return nil, fmt.Errorf("load metadata: %w", err)
+	}
+	return pm.foreignRoutes(proxies)
+}
+
+func (pm *ProxyManager) foreignRoutes(proxies []config.CaddyProxy) ([]ForeignRoute, error) {
+	servers, err := pm.listServers()
+	if err != nil {
+		return nil, fmt.Errorf("list servers: %w", err)
+	}
+
+	ownership := pm.newProxyOwnership(proxies)
+	foreign := []ForeignRoute{}
+	for _, serverName := range sortedServerNames(servers) {
+		server := servers[serverName]
+		if server == nil {
+			continue
+		}
+		for _, route := range server.Routes {
+			// Routes without a reverse proxy or supported kind aren't proxies
+			proxy, err := pm.routeToProxyWithListen(route, server.Listen)
+			if err != nil || ownership.manages(route, proxy) {
+				continue
+			}
+			proxy.ID = ""
+			foreign = append(foreign, ForeignRoute{
+				Key:    foreignRouteKey(serverName, route),
+				Server: serverName,
+				Proxy:  *proxy,
+			})
+		}
+	}
+	return foreign, nil
+}
+
+// AdoptRoute brings a foreign route under tailrelay's management: it becomes
+// a proxy in metadata and is replaced by the route rendered for that proxy
+func (pm *ProxyManager) AdoptRoute(key string, reserve PortReserver) (*config.CaddyProxy, error) {
+	pm.applyMu.Lock()
+	defer pm.applyMu.Unlock()
+
+	proxies, err := LoadProxyMetadata(pm.metadataPath)
+	if err != nil {
+		return nil, fmt.Errorf("load metadata: %w", err)
+	}
+	foreign, err := pm.foreignRoutes(proxies)
+	if err != nil {
+		return nil, err
+	}
+
+	var found *ForeignRoute
+	for i := range foreign {
+		if foreign[i].Key == key {
+			found = &foreign[i]
+			break
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: %s", ErrForeignRouteNotFound, key)
+	}
+
+	proxy := found.Proxy
+	id, err := config.GenerateToken()
+	if err != nil {
+		return nil, fmt.Errorf("generate proxy id: %w", err)
+	}
+	proxy.ID = id
+	proxy.Enabled = true
+	proxy.Autostart = true
+	if _, err := pm.buildRoute(proxy); err != nil {
+		return nil, fmt.Errorf("build route: %w", err)
+	}
+	if reserve != nil {
+		release, err := reserve(&proxy)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	if err := pm.commitProxiesClaiming(append(proxies, proxy), map[string]bool{key: true}); err != nil {
+		logger.Error("caddy", "Failed to adopt route %s: %v", key, err)
+		return nil, fmt.Errorf("apply proxies: %w", err)
+	}
+
+	logger.Info("caddy", "Adopted route %s in server %s as proxy %s (%s:%d)", key, found.Server, proxy.ID, proxy.Hostname, proxy.Port)
+	return &proxy, nil
+}
@@ -0,0 +1,98 @@
+package caddy
+
+import (
+	"fmt"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/config"
+)
+
+const (
+	// streamingFlushInterval makes Caddy flush every write, which gRPC streams need
+	streamingFlushInterval = -1
+	// grpcKeepAliveInterval keeps long-lived gRPC connections from being dropped as idle
+	grpcKeepAliveInterval = "30s"
+	grpcIdleConnTimeout   = "5m"
+)
+
+// buildTransport builds the reverse_proxy transport for a proxy's upstream
+// TLS settings and protocol mode, or nil when Caddy's defaults apply
+func buildTransport(proxy config.CaddyProxy) (*HTTPTransport, error) {
+	tlsConfig, err := buildUpstreamTLS(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("upstream TLS: %w", err)
+	}
+
+	transport := &HTTPTransport{
+		Protocol: "http",
+		TLS:      tlsConfig,
+	}
+
+	switch proxy.Protocol {
+	case "":
+	case config.ProxyProtocolHTTP1:
+		transport.Versions = []string{"1.1"}
+	case config.ProxyProtocolH2:
+		if tlsConfig == nil {
+			return nil, fmt.Errorf("protocol h2 requires TLS to the upstream; use h2c for cleartext")
+		}
+		transport.Versions = []string{"2"}
+	case config.ProxyProtocolH2C:
+		if tlsConfig != nil {
+			return nil, fmt.Errorf("protocol h2c is cleartext and cannot be combined with upstream TLS")
+		}
+		transport.Versions = []string{"h2c", "2"}
+	case config.ProxyProtocolGRPC:
+		// gRPC always speaks HTTP/2: over TLS when configured, cleartext otherwise
+		if tlsConfig != nil {
+			transport.Versions = []string{"2"}
+		} else {
+			transport.Versions = []string{"h2c", "2"}
+		}
+		transport.KeepAlive = &KeepAlive{
+			ProbeInterval:   grpcKeepAliveInterval,
+			IdleConnTimeout: grpcIdleConnTimeout,
+		}
+	default:
+		return nil, fmt.Errorf("unsupported protocol %q", proxy.Protocol)
+	}
+
+	if transport.TLS == nil && len(transport.Versions) == 0 {
+		return nil, nil
+	}
+	return transport, nil
+}
+
+// extractProtocol works out a proxy's protocol mode from its reverse_proxy handler
+func extractProtocol(reverseProxyHandler Handler) string {
+	transport, ok := reverseProxyHandler["transport"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	versionsRaw, _ := transport["versions"].([]interface{})
+	versions := make(map[string]bool, len(versionsRaw))
+	for _, versionRaw := range versionsRaw {
+		if version, ok := versionRaw.(string); ok {
+			versions[version] = true
+		}
+	}
+
+	streaming := false
+	switch flush := reverseProxyHandler["flush_interval"].(type) {
+	case float64:
+		streaming = flush < 0
+	case string:
+		streaming = flush == "-1"
+	}
+
+	switch {
+	case len(versions) == 1 && versions["1.1"]:
+		return config.ProxyProtocolHTTP1
+	case streaming && versions["2"]:
+		return config.ProxyProtocolGRPC
+	case versions["h2c"]:
+		return config.ProxyProtocolH2C
+	case len(versions) == 1 && versions["2"]:
+		return config.ProxyProtocolH2
+	}
+	return ""
+}
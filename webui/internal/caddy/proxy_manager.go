@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/sudocarlos/tailrelay-webui/internal/config"
 	"github.com/sudocarlos/tailrelay-webui/internal/logger"
@@ -15,11 +16,17 @@ import (
 
 // ProxyManager manages Caddy reverse proxies via the admin API
 type ProxyManager struct {
-	client        *APIClient
-	serverMapPath string
-	metadataPath  string
-	serverMap     *ServerMap
-	mapMu         sync.Mutex
+	client         *APIClient
+	serverMapPath  string
+	metadataPath   string
+	serverMap      *ServerMap
+	mapMu          sync.Mutex
+	identityDial   string   // Web UI address Caddy calls for tailnet identity checks
+	identitySecret string   // Sent on identity checks to prove they come from Caddy
+	stateDir       string   // file_server roots must live under its www directory
+	protectedPaths []string // Files and directories file_server roots must not expose
+	applyMu        sync.Mutex
+	loadedCerts    []LoadedCertificate // Rendered into the TLS app's load_files; guarded by applyMu
 }
 
 // NewProxyManager creates a new proxy manager
@@ -62,51 +69,51 @@ func (pm *ProxyManager) AddProxy(proxy config.CaddyProxy) (*config.CaddyProxy, e
 		proxy.ID = id
 	}
 
-	// Save metadata first
-	if err := AddProxyMetadata(pm.metadataPath, proxy); err != nil {
-		logger.Error("caddy", "Failed to save proxy metadata: %v", err)
-		return nil, fmt.Errorf("save metadata: %w", err)
+	pm.applyMu.Lock()
+	defer pm.applyMu.Unlock()
+
+	proxies, err := LoadProxyMetadata(pm.metadataPath)
+	if err != nil {
+		logger.Error("caddy", "Failed to load proxy metadata: %v", err)
+		return nil, fmt.Errorf("load metadata: %w", err)
 	}
 
-	// Only create route in Caddy if enabled
-	if proxy.Enabled {
-		route, err := pm.buildRoute(proxy)
-		if err != nil {
-			logger.Error("caddy", "Failed to build route for proxy %s: %v", proxy.ID, err)
-			// Clean up metadata
-			DeleteProxyMetadata(pm.metadataPath, proxy.ID)
-			return nil, fmt.Errorf("build route: %w", err)
-		}
+	if !proxy.Enabled {
+		logger.Debug("caddy", "Proxy %s created but not enabled, skipping Caddy route creation", proxy.ID)
+	}
 
-		serverName, err := pm.allocateServerName()
-		if err != nil {
-			// Clean up metadata
-			DeleteProxyMetadata(pm.metadataPath, proxy.ID)
-			return nil, fmt.Errorf("allocate server name: %w", err)
-		}
+	// Caddy and metadata change together or not at all
+	if err := pm.commitProxies(append(proxies, proxy)); err != nil {
+		logger.Error("caddy", "Failed to add proxy %s:%d: %v", proxy.Hostname, proxy.Port, err)
+		return nil, fmt.Errorf("apply proxies: %w", err)
+	}
 
-		path := fmt.Sprintf("/apps/http/servers/%s", serverName)
-		logger.Debug("caddy", "Creating server for proxy at path: %s", path)
+	logger.Info("caddy", "Added Caddy proxy: %s:%d -> %s (ID: %s, Enabled: %v)", proxy.Hostname, proxy.Port, proxy.Target, proxy.ID, proxy.Enabled)
+	return &proxy, nil
+}
 
-		server := &HTTPServer{
-			Listen: []string{fmt.Sprintf(":%d", proxy.Port)},
-			Routes: []Route{*route},
-		}
+// ValidateProxy checks that a proxy's settings translate into a valid Caddy
+// route and that its frontend TLS fits the other proxies
+func (pm *ProxyManager) ValidateProxy(proxy config.CaddyProxy) error {
+	if _, err := pm.buildRoute(proxy); err != nil {
+		return err
+	}
+	if err := validateFrontendTLS(proxy); err != nil {
+		return err
+	}
 
-		if err := pm.client.PutConfig(path, server); err != nil {
-			logger.Error("caddy", "Failed to create server %s for %s:%d via Caddy API: %v", serverName, proxy.Hostname, proxy.Port, err)
-			// Clean up metadata
-			DeleteProxyMetadata(pm.metadataPath, proxy.ID)
-			return nil, fmt.Errorf("create server: %w", err)
+	// Frontend TLS must also agree with the other stored proxies
+	proxies, err := LoadProxyMetadata(pm.metadataPath)
+	if err != nil {
+		return fmt.Errorf("load metadata: %w", err)
+	}
+	others := make([]config.CaddyProxy, 0, len(proxies)+1)
+	for _, other := range proxies {
+		if other.ID != proxy.ID || proxy.ID == "" {
+			others = append(others, other)
 		}
-
-		pm.updateServerMap(proxy, serverName)
-	} else {
-		logger.Debug("caddy", "Proxy %s created but not enabled, skipping Caddy route creation", proxy.ID)
 	}
-
-	logger.Info("caddy", "Added Caddy proxy: %s:%d -> %s (ID: %s, Enabled: %v)", proxy.Hostname, proxy.Port, proxy.Target, proxy.ID, proxy.Enabled)
-	return &proxy, nil
+	return validateFrontendTLSSet(append(others, proxy))
 }
 
 // GetProxy retrieves a proxy by ID from metadata
@@ -131,81 +138,37 @@ func (pm *ProxyManager) UpdateProxy(proxy config.CaddyProxy) error {
 
 	proxy.Hostname = NormalizeHostname(proxy.Hostname)
 
-	// Update metadata first
-	if err := UpdateProxyMetadata(pm.metadataPath, proxy); err != nil {
-		logger.Error("caddy", "Failed to update proxy metadata: %v", err)
-		return fmt.Errorf("update metadata: %w", err)
+	// Build the route before touching metadata so invalid settings are never saved
+	if _, err := pm.buildRoute(proxy); err != nil {
+		logger.Error("caddy", "Failed to build route for proxy update %s: %v", proxy.ID, err)
+		return fmt.Errorf("build route: %w", err)
 	}
 
-	// Only update route in Caddy if enabled
-	if proxy.Enabled {
-		route, err := pm.buildRoute(proxy)
-		if err != nil {
-			logger.Error("caddy", "Failed to build route for proxy update %s: %v", proxy.ID, err)
-			return fmt.Errorf("build route: %w", err)
-		}
-
-		// Try to get server name from map
-		serverName, err := pm.getServerNameForProxy(proxy)
-		serverExists := false
+	pm.applyMu.Lock()
+	defer pm.applyMu.Unlock()
 
-		if err == nil {
-			// Found in map, but verify it actually exists in Caddy
-			serverExists = pm.serverExistsInCaddy(serverName)
-			if !serverExists {
-				logger.Debug("caddy", "Server %s found in map but not in Caddy for proxy %s, will allocate new server", serverName, proxy.ID)
-				// Remove stale entry from map
-				pm.removeServerMapByID(proxy.ID, serverName)
-			}
-		}
-
-		if !serverExists {
-			// Server doesn't exist in Caddy (proxy was previously disabled or map is stale)
-			// Allocate a new server name
-			serverName, err = pm.allocateServerName()
-			if err != nil {
-				logger.Error("caddy", "Failed to allocate server for proxy %s: %v", proxy.ID, err)
-				return fmt.Errorf("allocate server: %w", err)
-			}
-			logger.Debug("caddy", "Allocated new server name %s for re-enabled proxy %s", serverName, proxy.ID)
-		}
-
-		server := &HTTPServer{
-			Listen: []string{fmt.Sprintf(":%d", proxy.Port)},
-			Routes: []Route{*route},
-		}
-
-		path := fmt.Sprintf("/apps/http/servers/%s", serverName)
-
-		// Use PUT if server doesn't exist (create), PATCH if it exists (update)
-		var apiErr error
-		if serverExists {
-			apiErr = pm.client.PatchConfig(path, server)
-			logger.Debug("caddy", "Updating existing server %s for proxy %s", serverName, proxy.ID)
-		} else {
-			apiErr = pm.client.PutConfig(path, server)
-			logger.Debug("caddy", "Creating new server %s for proxy %s", serverName, proxy.ID)
-		}
+	proxies, err := LoadProxyMetadata(pm.metadataPath)
+	if err != nil {
+		logger.Error("caddy", "Failed to load proxy metadata: %v", err)
+		return fmt.Errorf("load metadata: %w", err)
+	}
 
-		if apiErr != nil {
-			logger.Error("caddy", "Failed to %s server %s for proxy %s via Caddy API: %v",
-				map[bool]string{true: "update", false: "create"}[serverExists], serverName, proxy.ID, apiErr)
-			return fmt.Errorf("update server: %w", apiErr)
+	found := false
+	for i := range proxies {
+		if proxies[i].ID == proxy.ID {
+			proxies[i] = proxy
+			found = true
+			break
 		}
+	}
+	if !found {
+		return fmt.Errorf("proxy with ID %s not found", proxy.ID)
+	}
 
-		pm.updateServerMap(proxy, serverName)
-	} else {
-		// If disabled, remove from Caddy but keep metadata
-		serverName, err := pm.getServerNameForProxy(proxy)
-		if err == nil {
-			path := fmt.Sprintf("/apps/http/servers/%s", serverName)
-			if err := pm.client.DeleteConfig(path); err != nil {
-				logger.Warn("caddy", "Failed to delete server %s for disabled proxy %s: %v", serverName, proxy.ID, err)
-			}
-			// Remove from server map since it no longer exists in Caddy
-			pm.removeServerMapByID(proxy.ID, serverName)
-			logger.Debug("caddy", "Removed server %s mapping for disabled proxy %s", serverName, proxy.ID)
-		}
+	// Disabled proxies are left out of the rendered config but keep their metadata
+	if err := pm.commitProxies(proxies); err != nil {
+		logger.Error("caddy", "Failed to update proxy %s: %v", proxy.ID, err)
+		return fmt.Errorf("apply proxies: %w", err)
 	}
 
 	logger.Info("caddy", "Updated Caddy proxy: %s (ID: %s, Enabled: %v)", proxy.Hostname, proxy.ID, proxy.Enabled)
@@ -216,20 +179,29 @@ func (pm *ProxyManager) UpdateProxy(proxy config.CaddyProxy) error {
 func (pm *ProxyManager) DeleteProxy(id string) error {
 	logger.Debug("caddy", "DeleteProxy: removing proxy ID %s", id)
 
-	// Delete from Caddy if it exists
-	serverName, err := pm.getServerNameForProxy(config.CaddyProxy{ID: id})
-	if err == nil {
-		path := fmt.Sprintf("/apps/http/servers/%s", serverName)
-		if err := pm.client.DeleteConfig(path); err != nil {
-			logger.Warn("caddy", "Failed to delete server %s for proxy %s via Caddy API: %v", serverName, id, err)
+	pm.applyMu.Lock()
+	defer pm.applyMu.Unlock()
+
+	proxies, err := LoadProxyMetadata(pm.metadataPath)
+	if err != nil {
+		logger.Error("caddy", "Failed to load proxy metadata: %v", err)
+		return fmt.Errorf("load metadata: %w", err)
+	}
+
+	remaining := make([]config.CaddyProxy, 0, len(proxies))
+	for _, proxy := range proxies {
+		if proxy.ID != id {
+			remaining = append(remaining, proxy)
 		}
-		pm.removeServerMapByID(id, serverName)
+	}
+	if len(remaining) == len(proxies) {
+		return fmt.Errorf("proxy with ID %s not found", id)
 	}
 
-	// Delete from metadata
-	if err := DeleteProxyMetadata(pm.metadataPath, id); err != nil {
-		logger.Error("caddy", "Failed to delete proxy metadata: %v", err)
-		return fmt.Errorf("delete metadata: %w", err)
+	// Rendering without the proxy drops its route from Caddy
+	if err := pm.commitProxies(remaining); err != nil {
+		logger.Error("caddy", "Failed to delete proxy %s: %v", id, err)
+		return fmt.Errorf("apply proxies: %w", err)
 	}
 
 	logger.Info("caddy", "Deleted Caddy proxy: %s", id)
@@ -277,81 +249,116 @@ func (pm *ProxyManager) GetUpstreams() ([]UpstreamStatus, error) {
 
 // buildRoute converts a config.CaddyProxy to a Caddy Route with ReverseProxyHandler
 func (pm *ProxyManager) buildRoute(proxy config.CaddyProxy) (*Route, error) {
-	// Build the reverse proxy handler
-	reverseProxyHandler := make(Handler)
-	reverseProxyHandler["handler"] = "reverse_proxy"
-
-	// Add @id if provided
-	if proxy.ID != "" {
-		reverseProxyHandler["@id"] = proxy.ID
+	extra, err := parseRouteExtra(proxy)
+	if err != nil {
+		return nil, err
 	}
+	var mainHandler Handler
 
-	// Build upstreams
-	upstreams := []Upstream{
-		{Dial: proxy.Target},
+	// Path rules come first so the catch-all target only sees unmatched requests
+	subroutes := make([]Route, 0, len(proxy.PathRules)+1)
+	for i, rule := range proxy.PathRules {
+		ruleRoute, err := pm.buildPathRuleRoute(proxy, rule)
+		if err != nil {
+			return nil, fmt.Errorf("path rule %d: %w", i+1, err)
+		}
+		subroutes = append(subroutes, *ruleRoute)
 	}
-	reverseProxyHandler["upstreams"] = upstreams
 
-	// Build headers configuration using map form expected by Caddy
-	headers := HeaderConfig{
-		Request: &HeaderOps{
-			Set: map[string][]string{
-				"Host": []string{"{http.reverse_proxy.upstream.hostport}"},
-			},
-		},
-	}
+	if !isReverseProxyKind(proxy.Kind) {
+		kindHandler, err := pm.buildKindHandler(proxy)
+		if err != nil {
+			return nil, err
+		}
+		if proxy.ID != "" {
+			kindHandler["@id"] = proxy.ID
+		}
+		mainHandler = kindHandler
+		subroutes = append(subroutes, Route{
+			Handle: []Handler{kindHandler},
+		})
+	} else if proxy.Target != "" {
+		targets := append([]string{proxy.Target}, proxy.Upstreams...)
+		reverseProxyHandler, err := pm.buildReverseProxyHandler(proxy, targets, proxy.CustomHeaders, proxy.HeaderRules)
+		if err != nil {
+			return nil, err
+		}
 
-	// Add custom headers if provided
-	if len(proxy.CustomHeaders) > 0 {
-		if headers.Request.Set == nil {
-			headers.Request.Set = make(map[string][]string)
+		if proxy.LoadBalancing != nil {
+			loadBalancing, err := buildLoadBalancing(*proxy.LoadBalancing)
+			if err != nil {
+				return nil, fmt.Errorf("load balancing: %w", err)
+			}
+			reverseProxyHandler["load_balancing"] = loadBalancing
+		}
+
+		if proxy.HealthChecks != nil {
+			healthChecks, err := buildHealthChecks(*proxy.HealthChecks)
+			if err != nil {
+				return nil, fmt.Errorf("health checks: %w", err)
+			}
+			if healthChecks != nil {
+				reverseProxyHandler["health_checks"] = healthChecks
+			}
 		}
-		for key, value := range proxy.CustomHeaders {
-			headers.Request.Set[key] = []string{value}
+
+		// Add @id if provided
+		if proxy.ID != "" {
+			reverseProxyHandler["@id"] = proxy.ID
 		}
+
+		mainHandler = reverseProxyHandler
+		subroutes = append(subroutes, Route{
+			Handle: []Handler{reverseProxyHandler},
+		})
 	}
 
-	reverseProxyHandler["headers"] = headers
+	if len(subroutes) == 0 {
+		return nil, fmt.Errorf("proxy needs a target or at least one path rule")
+	}
 
-	// Add trusted proxies if enabled
-	if proxy.TrustedProxies {
-		reverseProxyHandler["trusted_proxies"] = []string{
-			"192.168.0.0/16",
-			"172.16.0.0/12",
-			"10.0.0.0/8",
-			"127.0.0.1/8",
-			"fd00::/8",
-			"::1",
+	// Unmodeled handlers such as encode keep their place ahead of the upstreams
+	if extra != nil && len(extra.Subroutes) > 0 {
+		extraRoutes, err := extra.extraSubroutes()
+		if err != nil {
+			return nil, err
 		}
+		subroutes = append(extraRoutes, subroutes...)
 	}
 
-	// Configure TLS transport only when a CA file is provided (srv0-like config)
-	if proxy.TLSCertFile != "" {
-		transport := HTTPTransport{
-			Protocol: "http",
-			TLS:      &TLSConfig{},
+	// Authentication runs ahead of every upstream in the subroute
+	if len(proxy.BasicAuth) > 0 {
+		authHandler, err := buildBasicAuthHandler(proxy.BasicAuth)
+		if err != nil {
+			return nil, err
 		}
+		subroutes = append([]Route{{Handle: []Handler{authHandler}}}, subroutes...)
+	}
 
-		transport.TLS.CA = &TLSCAConfig{
-			Provider: "file",
-			PEMFiles: []string{proxy.TLSCertFile},
+	// Identity checks run before basic auth so unknown callers never see a login prompt
+	if proxy.TailnetIdentity != nil && proxy.TailnetIdentity.Enabled {
+		identityHandler, err := pm.buildIdentityHandler(*proxy.TailnetIdentity)
+		if err != nil {
+			return nil, err
 		}
+		subroutes = append([]Route{{Handle: []Handler{identityHandler}}}, subroutes...)
+	}
 
-		reverseProxyHandler["transport"] = transport
+	// IP restrictions are checked before anything else, including authentication
+	accessRoutes, err := buildAccessRoutes(proxy.AllowCIDRs, proxy.DenyCIDRs)
+	if err != nil {
+		return nil, err
 	}
+	subroutes = append(accessRoutes, subroutes...)
 
 	// Build route with matchers
 	subrouteHandler := Handler{
 		"handler": "subroute",
-		"routes": []Route{
-			{
-				Handle: []Handler{reverseProxyHandler},
-			},
-		},
+		"routes":  subroutes,
 	}
 
 	route := &Route{
-		ID:       proxy.ID,
+		ID:       RouteID(proxy.ID),
 		Terminal: true,
 		Match: []MatcherSet{
 			{
@@ -361,13 +368,146 @@ func (pm *ProxyManager) buildRoute(proxy config.CaddyProxy) (*Route, error) {
 		Handle: []Handler{subrouteHandler},
 	}
 
-	// If disabled, we could add a static_response handler instead
-	// or simply not include the route. For now, we'll always include it.
-	// The enabled flag is stored but not enforced at the Caddy level.
+	if extra != nil {
+		applyRouteExtra(route, mainHandler, extra)
+	}
 
 	return route, nil
 }
 
+// buildPathRuleRoute builds the subroute entry for a single path rule
+func (pm *ProxyManager) buildPathRuleRoute(proxy config.CaddyProxy, rule config.CaddyPathRule) (*Route, error) {
+	if rule.Target == "" {
+		return nil, fmt.Errorf("target is required")
+	}
+
+	path := strings.TrimSpace(rule.Path)
+	if path != "" && !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("path %q must start with /", rule.Path)
+	}
+
+	var handlers []Handler
+	if rule.StripPrefix {
+		if prefix := pathRulePrefix(path); prefix != "" {
+			handlers = append(handlers, Handler{
+				"handler":           "rewrite",
+				"strip_path_prefix": prefix,
+			})
+		}
+	}
+	reverseProxyHandler, err := pm.buildReverseProxyHandler(proxy, []string{rule.Target}, rule.CustomHeaders, rule.HeaderRules)
+	if err != nil {
+		return nil, err
+	}
+	handlers = append(handlers, reverseProxyHandler)
+
+	ruleRoute := &Route{
+		Terminal: true,
+		Handle:   handlers,
+	}
+
+	// "/" and "/*" behave as catch-alls, so leave them unmatched
+	if path != "" && path != "/" && path != "/*" {
+		ruleRoute.Match = []MatcherSet{
+			{
+				Path: []string{path},
+			},
+		}
+	}
+
+	return ruleRoute, nil
+}
+
+// buildReverseProxyHandler builds a reverse_proxy handler for the given upstreams
+func (pm *ProxyManager) buildReverseProxyHandler(proxy config.CaddyProxy, targets []string, customHeaders map[string]string, headerRules []config.CaddyHeaderRule) (Handler, error) {
+	reverseProxyHandler := make(Handler)
+	reverseProxyHandler["handler"] = "reverse_proxy"
+
+	// Build upstreams
+	upstreams := make([]Upstream, 0, len(targets))
+	for _, target := range targets {
+		if target = strings.TrimSpace(target); target != "" {
+			upstreams = append(upstreams, Upstream{Dial: target})
+		}
+	}
+	reverseProxyHandler["upstreams"] = upstreams
+
+	// Build headers configuration using map form expected by Caddy
+	headers, err := buildHeaders(proxy.PreserveHost, customHeaders, headerRules)
+	if err != nil {
+		return nil, err
+	}
+	if headers.Request != nil || headers.Response != nil {
+		reverseProxyHandler["headers"] = headers
+	}
+
+	// Add trusted proxies if enabled
+	if proxy.TrustedProxies {
+		reverseProxyHandler["trusted_proxies"] = []string{
+			"192.168.0.0/16",
+			"172.16.0.0/12",
+			"10.0.0.0/8",
+			"127.0.0.1/8",
+			"fd00::/8",
+			"::1",
+		}
+	}
+
+	// Configure the transport for HTTPS upstreams and non-default protocols
+	transport, err := buildTransport(proxy)
+	if err != nil {
+		return nil, err
+	}
+	if transport != nil {
+		reverseProxyHandler["transport"] = transport
+	}
+	if proxy.Protocol == config.ProxyProtocolGRPC {
+		reverseProxyHandler["flush_interval"] = streamingFlushInterval
+	}
+
+	return reverseProxyHandler, nil
+}
+
+// buildLoadBalancing converts proxy load balancing settings to Caddy's form
+func buildLoadBalancing(lb config.CaddyLoadBalancing) (*LoadBalancing, error) {
+	loadBalancing := &LoadBalancing{
+		TryDuration: lb.TryDuration,
+		TryInterval: lb.TryInterval,
+		Retries:     lb.Retries,
+	}
+
+	switch lb.Policy {
+	case "":
+	case "round_robin", "first", "least_conn", "ip_hash":
+		loadBalancing.SelectionPolicy = map[string]interface{}{"policy": lb.Policy}
+	case "cookie":
+		policy := map[string]interface{}{"policy": lb.Policy}
+		if lb.CookieName != "" {
+			policy["name"] = lb.CookieName
+		}
+		loadBalancing.SelectionPolicy = policy
+	default:
+		return nil, fmt.Errorf("unsupported selection policy %q", lb.Policy)
+	}
+
+	for _, duration := range []string{lb.TryDuration, lb.TryInterval} {
+		if duration == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(duration); err != nil {
+			return nil, fmt.Errorf("invalid duration %q", duration)
+		}
+	}
+
+	return loadBalancing, nil
+}
+
+// pathRulePrefix returns the literal prefix of a path matcher, e.g. "/api" for "/api/*"
+func pathRulePrefix(path string) string {
+	prefix := strings.TrimSuffix(path, "*")
+	return strings.TrimSuffix(prefix, "/")
+}
+
 // routeToProxy converts a Caddy Route back to a config.CaddyProxy
 func (pm *ProxyManager) routeToProxy(route Route) (*config.CaddyProxy, error) {
 	return pm.routeToProxyWithListen(route, nil)
@@ -379,19 +519,25 @@ func (pm *ProxyManager) routeToProxyWithListen(route Route, listenAddrs []string
 	}
 
 	reverseProxyHandler, ok := extractReverseProxyHandler(route)
-	if !ok {
-		return nil, fmt.Errorf("not a reverse_proxy handler")
+	kindHandler := extractKindHandler(route)
+	if !ok && kindHandler == nil {
+		return nil, fmt.Errorf("no supported handler in route")
 	}
 
 	proxy := &config.CaddyProxy{
-		ID:      route.ID,
+		ID:      routeIDProxy(route.ID),
 		Enabled: true, // Default to enabled if route exists
 	}
 
-	if proxy.ID == "" {
-		if handlerID, ok := reverseProxyHandler["@id"].(string); ok {
-			proxy.ID = handlerID
-		}
+	// The main handler carries the bare proxy ID
+	if handlerID, ok := reverseProxyHandler["@id"].(string); ok && handlerID != "" {
+		proxy.ID = handlerID
+	} else if handlerID, ok := kindHandler["@id"].(string); ok && handlerID != "" {
+		proxy.ID = handlerID
+	}
+
+	if kindHandler != nil {
+		pm.applyKindHandler(proxy, kindHandler)
 	}
 
 	// Extract hostname and port from matchers
@@ -424,46 +570,39 @@ func (pm *ProxyManager) routeToProxyWithListen(route Route, listenAddrs []string
 		proxy.Port = port
 	}
 
-	// Extract upstreams
-	if upstreams, ok := reverseProxyHandler["upstreams"].([]interface{}); ok && len(upstreams) > 0 {
-		if upstream, ok := upstreams[0].(map[string]interface{}); ok {
-			if dial, ok := upstream["dial"].(string); ok {
-				proxy.Target = dial
-			}
-		}
+	// Path-matched subroutes become path rules; only the unmatched one feeds Target
+	targetHandler := reverseProxyHandler
+	if kindHandler != nil {
+		targetHandler = nil
+	}
+	if pathRules, fallback := extractPathRules(route); len(pathRules) > 0 {
+		proxy.PathRules = pathRules
+		targetHandler = fallback
 	}
 
-	// Check for TLS transport
-	if transport, ok := reverseProxyHandler["transport"].(map[string]interface{}); ok {
-		if tlsConfig, hasTLS := transport["tls"].(map[string]interface{}); hasTLS {
-			proxy.TLS = true
-			if caCfg, ok := tlsConfig["ca"].(map[string]interface{}); ok {
-				if pemFiles, ok := caCfg["pem_files"].([]interface{}); ok && len(pemFiles) > 0 {
-					if pemFile, ok := pemFiles[0].(string); ok {
-						proxy.TLSCertFile = pemFile
-					}
-				}
-			}
+	// Extract upstreams
+	if targetHandler != nil {
+		if dials := extractUpstreamDials(targetHandler); len(dials) > 0 {
+			proxy.Target = dials[0]
+			proxy.Upstreams = dials[1:]
 		}
+		proxy.LoadBalancing = extractLoadBalancing(targetHandler)
+		proxy.HealthChecks = extractHealthChecks(targetHandler)
 	}
 
-	// Extract custom headers (excluding the default Host header)
-	if headers, ok := reverseProxyHandler["headers"].(map[string]interface{}); ok {
-		if request, ok := headers["request"].(map[string]interface{}); ok {
-			if setMap, ok := request["set"].(map[string]interface{}); ok {
-				proxy.CustomHeaders = make(map[string]string)
-				for field, val := range setMap {
-					if strings.EqualFold(field, "Host") {
-						continue
-					}
-					if values, ok := val.([]interface{}); ok && len(values) > 0 {
-						if value, ok := values[0].(string); ok {
-							proxy.CustomHeaders[field] = value
-						}
-					}
-				}
-			}
-		}
+	// Check for TLS transport and protocol mode
+	extractUpstreamTLS(proxy, reverseProxyHandler)
+	proxy.Protocol = extractProtocol(reverseProxyHandler)
+
+	proxy.BasicAuth = extractBasicAuthUsers(route)
+	proxy.AllowCIDRs, proxy.DenyCIDRs = extractAccessLists(route)
+	proxy.TailnetIdentity = extractIdentityPolicy(route)
+
+	// Extract header rules (excluding the default Host header)
+	if targetHandler != nil {
+		proxy.HeaderRules, proxy.PreserveHost = extractHeaderRules(targetHandler)
+	} else {
+		_, proxy.PreserveHost = extractHeaderRules(reverseProxyHandler)
 	}
 
 	if trustedProxies, ok := reverseProxyHandler["trusted_proxies"]; ok {
@@ -479,9 +618,159 @@ func (pm *ProxyManager) routeToProxyWithListen(route Route, listenAddrs []string
 		}
 	}
 
+	// Keep whatever the fields above don't cover so edits don't drop it
+	mainHandler := targetHandler
+	if kindHandler != nil {
+		mainHandler = kindHandler
+	}
+	proxy.Extra = pm.extractRouteExtra(route, mainHandler, *proxy)
+	proxy.Advanced = len(proxy.Extra) > 0
+
 	return proxy, nil
 }
 
+// extractPathRules returns the path-matched reverse proxies inside a route's
+// subroute as path rules, along with the unmatched (catch-all) handler if any.
+func extractPathRules(route Route) ([]config.CaddyPathRule, Handler) {
+	if len(route.Handle) == 0 {
+		return nil, nil
+	}
+	if handlerType, _ := route.Handle[0]["handler"].(string); handlerType != "subroute" {
+		return nil, nil
+	}
+	routesRaw, ok := route.Handle[0]["routes"].([]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	var rules []config.CaddyPathRule
+	var fallback Handler
+	for _, routeRaw := range routesRaw {
+		routeMap, ok := routeRaw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		handlesRaw, ok := routeMap["handle"].([]interface{})
+		if !ok {
+			continue
+		}
+
+		var reverseProxyHandler Handler
+		stripPrefix := ""
+		for _, handleRaw := range handlesRaw {
+			handleMap, ok := handleRaw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			switch handleMap["handler"] {
+			case "rewrite":
+				if prefix, ok := handleMap["strip_path_prefix"].(string); ok {
+					stripPrefix = prefix
+				}
+			case "reverse_proxy":
+				if !isIdentityHandler(handleMap) {
+					reverseProxyHandler = handleMap
+				}
+			}
+		}
+		if reverseProxyHandler == nil {
+			continue
+		}
+
+		path := matcherPath(routeMap["match"])
+		if path == "" {
+			fallback = reverseProxyHandler
+			continue
+		}
+
+		headerRules, _ := extractHeaderRules(reverseProxyHandler)
+		rules = append(rules, config.CaddyPathRule{
+			Path:        path,
+			Target:      extractUpstreamDial(reverseProxyHandler),
+			StripPrefix: stripPrefix != "" && stripPrefix == pathRulePrefix(path),
+			HeaderRules: headerRules,
+		})
+	}
+
+	return rules, fallback
+}
+
+// matcherPath returns the first path matcher value from a raw match list
+func matcherPath(matchRaw interface{}) string {
+	matchers, ok := matchRaw.([]interface{})
+	if !ok {
+		return ""
+	}
+	for _, matcherRaw := range matchers {
+		matcher, ok := matcherRaw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if paths, ok := matcher["path"].([]interface{}); ok && len(paths) > 0 {
+			if path, ok := paths[0].(string); ok {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+func extractUpstreamDial(reverseProxyHandler Handler) string {
+	if dials := extractUpstreamDials(reverseProxyHandler); len(dials) > 0 {
+		return dials[0]
+	}
+	return ""
+}
+
+func extractUpstreamDials(reverseProxyHandler Handler) []string {
+	upstreams, ok := reverseProxyHandler["upstreams"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var dials []string
+	for _, upstreamRaw := range upstreams {
+		if upstream, ok := upstreamRaw.(map[string]interface{}); ok {
+			if dial, ok := upstream["dial"].(string); ok {
+				dials = append(dials, dial)
+			}
+		}
+	}
+	return dials
+}
+
+func extractLoadBalancing(reverseProxyHandler Handler) *config.CaddyLoadBalancing {
+	lbRaw, ok := reverseProxyHandler["load_balancing"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	lb := &config.CaddyLoadBalancing{}
+	if policy, ok := lbRaw["selection_policy"].(map[string]interface{}); ok {
+		lb.Policy, _ = policy["policy"].(string)
+		lb.CookieName, _ = policy["name"].(string)
+	}
+	if retries, ok := lbRaw["retries"].(float64); ok {
+		lb.Retries = int(retries)
+	}
+	lb.TryDuration = durationValue(lbRaw["try_duration"])
+	lb.TryInterval = durationValue(lbRaw["try_interval"])
+
+	return lb
+}
+
+// durationValue reads a Caddy duration, which is either a string like "5s"
+// or an integer number of nanoseconds (as produced by the Caddyfile adapter)
+func durationValue(raw interface{}) string {
+	switch value := raw.(type) {
+	case string:
+		return value
+	case float64:
+		return time.Duration(int64(value)).String()
+	}
+	return ""
+}
+
 func extractReverseProxyHandler(route Route) (Handler, bool) {
 	if len(route.Handle) == 0 {
 		return nil, false
@@ -511,7 +800,7 @@ func extractReverseProxyHandler(route Route) (Handler, bool) {
 					if !ok {
 						continue
 					}
-					if nestedType, ok := handleMap["handler"].(string); ok && nestedType == "reverse_proxy" {
+					if nestedType, ok := handleMap["handler"].(string); ok && nestedType == "reverse_proxy" && !isIdentityHandler(handleMap) {
 						return handleMap, true
 					}
 				}
@@ -579,30 +868,6 @@ func extractIDFromLocation(location string) (string, error) {
 	return id, nil
 }
 
-// InitializeServer ensures the HTTP server exists in Caddy config
-func (pm *ProxyManager) InitializeServer(listenAddrs []string) error {
-	if len(listenAddrs) == 0 {
-		listenAddrs = []string{":80", ":443"}
-	}
-
-	server := &HTTPServer{
-		Listen: listenAddrs,
-		Routes: []Route{},
-	}
-
-	serverName, err := pm.allocateServerName()
-	if err != nil {
-		return fmt.Errorf("allocate server name: %w", err)
-	}
-
-	path := fmt.Sprintf("/apps/http/servers/%s", serverName)
-	if err := pm.client.PutConfig(path, server); err != nil {
-		return fmt.Errorf("initialize server: %w", err)
-	}
-
-	return nil
-}
-
 func (pm *ProxyManager) listServers() (map[string]*HTTPServer, error) {
 	data, err := pm.client.GetConfig("/apps/http/servers")
 	if err != nil {
@@ -617,152 +882,8 @@ func (pm *ProxyManager) listServers() (map[string]*HTTPServer, error) {
 	return servers, nil
 }
 
-func (pm *ProxyManager) getServerNameForProxy(proxy config.CaddyProxy) (string, error) {
-	pm.mapMu.Lock()
-	if proxy.ID != "" {
-		if serverName, ok := pm.serverMap.ByProxyID[proxy.ID]; ok {
-			pm.mapMu.Unlock()
-			return serverName, nil
-		}
-	}
-	if proxy.Hostname != "" && proxy.Port != 0 {
-		if serverName, ok := pm.serverMap.ByHostPort[pm.hostPortKey(proxy)]; ok {
-			pm.mapMu.Unlock()
-			return serverName, nil
-		}
-	}
-	pm.mapMu.Unlock()
-
-	serverName, err := pm.findServerNameInCaddy(proxy)
-	if err != nil {
-		return "", err
-	}
-
-	pm.updateServerMap(proxy, serverName)
-	return serverName, nil
-}
-
-// serverExistsInCaddy checks if a server name actually exists in Caddy's configuration
-func (pm *ProxyManager) serverExistsInCaddy(serverName string) bool {
-	path := fmt.Sprintf("/apps/http/servers/%s", serverName)
-	data, err := pm.client.GetConfig(path)
-	if err != nil {
-		return false
-	}
-	// Caddy returns "null" for non-existent paths
-	dataStr := strings.TrimSpace(string(data))
-	exists := len(dataStr) > 0 && dataStr != "null"
-	logger.Debug("caddy", "Checking if server %s exists in Caddy: %v (data: %s)", serverName, exists, dataStr)
-	return exists
-}
-
-func (pm *ProxyManager) allocateServerName() (string, error) {
-	pm.mapMu.Lock()
-	defer pm.mapMu.Unlock()
-
-	servers, err := pm.listServers()
-	if err != nil {
-		return "", err
-	}
-
-	serverNames := make(map[string]bool)
-	for name := range servers {
-		serverNames[name] = true
-	}
-	for _, name := range pm.serverMap.ByProxyID {
-		serverNames[name] = true
-	}
-	for _, name := range pm.serverMap.ByHostPort {
-		serverNames[name] = true
-	}
-
-	for i := pm.serverMap.NextIndex; ; i++ {
-		candidate := fmt.Sprintf("srv%d", i)
-		if !serverNames[candidate] {
-			pm.serverMap.NextIndex = i + 1
-			if err := SaveServerMap(pm.serverMapPath, pm.serverMap); err != nil {
-				logger.Error("caddy", "Failed to save server map: %v", err)
-			}
-			return candidate, nil
-		}
-	}
-}
-
-func (pm *ProxyManager) updateServerMap(proxy config.CaddyProxy, serverName string) {
-	if serverName == "" {
-		return
-	}
-
-	pm.mapMu.Lock()
-	defer pm.mapMu.Unlock()
-
-	if proxy.ID != "" {
-		pm.serverMap.ByProxyID[proxy.ID] = serverName
-	}
-	if proxy.Hostname != "" && proxy.Port != 0 {
-		pm.serverMap.ByHostPort[pm.hostPortKey(proxy)] = serverName
-	}
-
-	if err := SaveServerMap(pm.serverMapPath, pm.serverMap); err != nil {
-		logger.Error("caddy", "Failed to save server map: %v", err)
-	}
-}
-
-func (pm *ProxyManager) removeServerMapByID(proxyID, serverName string) {
-	pm.mapMu.Lock()
-	defer pm.mapMu.Unlock()
-
-	if proxyID != "" {
-		delete(pm.serverMap.ByProxyID, proxyID)
-	}
-	if serverName != "" {
-		for key, name := range pm.serverMap.ByHostPort {
-			if name == serverName {
-				delete(pm.serverMap.ByHostPort, key)
-			}
-		}
-	}
-
-	if err := SaveServerMap(pm.serverMapPath, pm.serverMap); err != nil {
-		logger.Error("caddy", "Failed to save server map: %v", err)
-	}
-}
-
-func (pm *ProxyManager) hostPortKey(proxy config.CaddyProxy) string {
-	return fmt.Sprintf("%s:%d", NormalizeHostname(proxy.Hostname), proxy.Port)
-}
-
-func (pm *ProxyManager) findServerNameInCaddy(proxy config.CaddyProxy) (string, error) {
-	servers, err := pm.listServers()
-	if err != nil {
-		return "", err
-	}
-
-	for serverName, server := range servers {
-		if server == nil {
-			continue
-		}
-		for _, route := range server.Routes {
-			if proxy.ID != "" && routeHasID(route, proxy.ID) {
-				return serverName, nil
-			}
-			candidate, err := pm.routeToProxyWithListen(route, server.Listen)
-			if err != nil {
-				continue
-			}
-			if proxy.Hostname != "" && proxy.Port != 0 {
-				if NormalizeHostname(candidate.Hostname) == NormalizeHostname(proxy.Hostname) && candidate.Port == proxy.Port {
-					return serverName, nil
-				}
-			}
-		}
-	}
-
-	return "", fmt.Errorf("server not found for proxy")
-}
-
 func routeHasID(route Route, id string) bool {
-	if route.ID == id {
+	if route.ID == id || route.ID == RouteID(id) || route.ID == legacyRouteIDPrefix+id {
 		return true
 	}
 	if len(route.Handle) == 0 {
@@ -772,12 +893,15 @@ func routeHasID(route Route, id string) bool {
 		return handlerID == id
 	}
 
-	reverseProxyHandler, ok := extractReverseProxyHandler(route)
-	if !ok {
-		return false
+	if reverseProxyHandler, ok := extractReverseProxyHandler(route); ok {
+		if handlerID, ok := reverseProxyHandler["@id"].(string); ok && handlerID == id {
+			return true
+		}
 	}
-	if handlerID, ok := reverseProxyHandler["@id"].(string); ok {
-		return handlerID == id
+	if kindHandler := extractKindHandler(route); kindHandler != nil {
+		if handlerID, ok := kindHandler["@id"].(string); ok {
+			return handlerID == id
+		}
 	}
 	return false
 }
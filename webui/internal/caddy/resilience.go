@@ -0,0 +1,244 @@
+package caddy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/logger"
+)
+
+const (
+	// maxRequestAttempts bounds how often an idempotent request is sent
+	maxRequestAttempts = 3
+	// retryBaseDelay and retryMaxDelay bound the exponential backoff
+	// between request retries and readiness probes
+	retryBaseDelay = 200 * time.Millisecond
+	retryMaxDelay  = 5 * time.Second
+
+	// breakerFailureThreshold consecutive failures open the circuit
+	breakerFailureThreshold = 5
+	// breakerCooldown is how long an open circuit rejects requests before
+	// letting a trial request through
+	breakerCooldown = 30 * time.Second
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// ErrCircuitOpen is returned without contacting Caddy while the admin API
+// is considered down
+var ErrCircuitOpen = errors.New("caddy admin API circuit is open")
+
+// unavailableError marks a failure that means Caddy could not be reached or
+// could not serve the request, as opposed to Caddy rejecting it
+type unavailableError struct {
+	err error
+}
+
+func (e *unavailableError) Error() string { return e.err.Error() }
+func (e *unavailableError) Unwrap() error { return e.err }
+
+func isUnavailable(err error) bool {
+	var unavailable *unavailableError
+	return errors.As(err, &unavailable)
+}
+
+// unavailableStatus reports whether an admin API status code means Caddy is
+// (temporarily) unable to serve requests
+func unavailableStatus(code int) bool {
+	return code == http.StatusBadGateway || code == http.StatusServiceUnavailable || code == http.StatusGatewayTimeout
+}
+
+// idempotentMethod reports whether a request may safely be sent again.
+// PUT and DELETE are left out: Caddy's admin API creates or inserts with PUT,
+// and DELETE on an array index removes whatever entry moved into its place,
+// so a retry after a lost response would duplicate or remove the wrong entry.
+func idempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// backoffDelay is the exponential delay before the given retry (1-based)
+func backoffDelay(retry int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < retry && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return delay
+}
+
+// BreakerStatus is a snapshot of the admin API circuit breaker
+type BreakerStatus struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"` // Set while the circuit is not closed
+}
+
+// circuitBreaker stops requests to the admin API after repeated failures
+// and lets a single trial request through once the cooldown has passed
+type circuitBreaker struct {
+	mu          sync.Mutex
+	state       string
+	failures    int
+	lastError   string
+	lastSuccess time.Time
+	openedAt    time.Time
+	trialActive bool
+}
+
+func newCircuitBreaker() *circuitBreaker {
+	return &circuitBreaker{state: BreakerClosed}
+}
+
+// allow reports whether a request may be sent now
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < breakerCooldown {
+			return ErrCircuitOpen
+		}
+		b.state = BreakerHalfOpen
+		b.trialActive = true
+		logger.Info("caddy", "Caddy admin API circuit half-open, sending a trial request")
+		return nil
+	case BreakerHalfOpen:
+		if b.trialActive {
+			return ErrCircuitOpen
+		}
+		b.trialActive = true
+	}
+	return nil
+}
+
+func (b *circuitBreaker) recordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != BreakerClosed {
+		logger.Info("caddy", "Caddy admin API reachable again, circuit closed")
+	}
+	b.state = BreakerClosed
+	b.failures = 0
+	b.lastError = ""
+	b.lastSuccess = time.Now()
+	b.trialActive = false
+}
+
+func (b *circuitBreaker) recordFailure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.lastError = err.Error()
+	b.trialActive = false
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= breakerFailureThreshold) {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+		logger.Warn("caddy", "Caddy admin API circuit opened after %d failures: %v", b.failures, err)
+	}
+}
+
+func (b *circuitBreaker) status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		LastError:           b.lastError,
+	}
+	if !b.lastSuccess.IsZero() {
+		lastSuccess := b.lastSuccess
+		status.LastSuccess = &lastSuccess
+	}
+	if b.state != BreakerClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
+
+// Breaker returns the state of the client's circuit breaker
+func (c *APIClient) Breaker() BreakerStatus {
+	return c.breaker.status()
+}
+
+// WaitReady probes the admin API with bounded exponential backoff until it
+// answers or the context ends. Failed probes don't count against the
+// circuit breaker; a successful one closes it.
+func (c *APIClient) WaitReady(ctx context.Context) error {
+	var lastErr error
+	for probe := 1; ; probe++ {
+		_, _, err := c.sendRequest(http.MethodGet, "/config/", nil, "")
+		if err == nil {
+			c.breaker.recordSuccess()
+			logger.Info("caddy", "Caddy admin API ready after %d probe(s)", probe)
+			return nil
+		}
+		lastErr = err
+		logger.Debug("caddy", "Caddy admin API not ready (probe %d): %v", probe, err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("caddy admin API not ready: %w", lastErr)
+		case <-time.After(backoffDelay(probe)):
+		}
+	}
+}
+
+// doRequestWithHeaders sends a request through the circuit breaker,
+// retrying idempotent requests with backoff while Caddy is unavailable.
+// A non-empty ifMatch is sent as the If-Match header.
+func (c *APIClient) doRequestWithHeaders(method, path string, body interface{}, ifMatch string) ([]byte, http.Header, error) {
+	attempts := 1
+	if idempotentMethod(method) {
+		attempts = maxRequestAttempts
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoffDelay(attempt - 1))
+		}
+		if allowErr := c.breaker.allow(); allowErr != nil {
+			if err != nil {
+				return nil, nil, err
+			}
+			return nil, nil, allowErr
+		}
+
+		var respBody []byte
+		var headers http.Header
+		respBody, headers, err = c.sendRequest(method, path, body, ifMatch)
+		if err == nil || !isUnavailable(err) {
+			// Caddy answered, even if it rejected the request
+			c.breaker.recordSuccess()
+			return respBody, headers, err
+		}
+
+		c.breaker.recordFailure(err)
+		if attempt < attempts {
+			logger.Warn("caddy", "Caddy admin API unavailable for %s %s (attempt %d/%d): %v", method, path, attempt, attempts, err)
+		}
+	}
+	return nil, nil, err
+}
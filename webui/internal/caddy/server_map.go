@@ -7,18 +7,31 @@ import (
 	"path/filepath"
 )
 
-// ServerMap stores mappings between proxy identifiers and Caddy server names.
+// ServerMap records where each proxy's route lives in Caddy. Proxies sharing
+// a listen address share one server, so each proxy maps to a server name
+// plus the @id of its route inside that server.
 type ServerMap struct {
-	ByProxyID  map[string]string `json:"by_proxy_id"`
-	ByHostPort map[string]string `json:"by_host_port"`
-	NextIndex  int               `json:"next_index"`
+	ByProxyID map[string]RouteLocation `json:"by_proxy_id"`
+	ByListen  map[string]string        `json:"by_listen"` // Listen address (e.g. ":443") to server name
+	NextIndex int                      `json:"next_index"`
+}
+
+// RouteLocation identifies a proxy's route inside a Caddy server
+type RouteLocation struct {
+	Server  string `json:"server"`
+	RouteID string `json:"route_id"`
+}
+
+// RouteID returns the @id used for a proxy's route
+func RouteID(proxyID string) string {
+	return routeIDPrefix + proxyID
 }
 
 func NewServerMap() *ServerMap {
 	return &ServerMap{
-		ByProxyID:  make(map[string]string),
-		ByHostPort: make(map[string]string),
-		NextIndex:  0,
+		ByProxyID: make(map[string]RouteLocation),
+		ByListen:  make(map[string]string),
+		NextIndex: 0,
 	}
 }
 
@@ -35,19 +48,35 @@ func LoadServerMap(filePath string) (*ServerMap, error) {
 		return nil, fmt.Errorf("read server map: %w", err)
 	}
 
-	var m ServerMap
-	if err := json.Unmarshal(data, &m); err != nil {
+	var raw struct {
+		ByProxyID map[string]json.RawMessage `json:"by_proxy_id"`
+		ByListen  map[string]string          `json:"by_listen"`
+		NextIndex int                        `json:"next_index"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
 		return nil, fmt.Errorf("unmarshal server map: %w", err)
 	}
 
-	if m.ByProxyID == nil {
-		m.ByProxyID = make(map[string]string)
+	m := NewServerMap()
+	m.NextIndex = raw.NextIndex
+	for listen, serverName := range raw.ByListen {
+		m.ByListen[listen] = serverName
 	}
-	if m.ByHostPort == nil {
-		m.ByHostPort = make(map[string]string)
+	for proxyID, entry := range raw.ByProxyID {
+		var location RouteLocation
+		if err := json.Unmarshal(entry, &location); err == nil {
+			m.ByProxyID[proxyID] = location
+			continue
+		}
+		// Older maps stored one server per proxy, whose route @id was the proxy ID
+		var serverName string
+		if err := json.Unmarshal(entry, &serverName); err != nil {
+			return nil, fmt.Errorf("unmarshal server map entry %s: %w", proxyID, err)
+		}
+		m.ByProxyID[proxyID] = RouteLocation{Server: serverName, RouteID: proxyID}
 	}
 
-	return &m, nil
+	return m, nil
 }
 
 func SaveServerMap(filePath string, m *ServerMap) error {
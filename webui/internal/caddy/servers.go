@@ -0,0 +1,98 @@
+package caddy
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/logger"
+)
+
+// listenAddress is the Caddy listen address for a proxy port
+func listenAddress(port int) string {
+	return fmt.Sprintf(":%d", port)
+}
+
+// recordRoute stores a proxy's route location in the server map
+func (pm *ProxyManager) recordRoute(proxyID, serverName, routeID, listen string) {
+	if proxyID == "" || serverName == "" {
+		return
+	}
+
+	pm.mapMu.Lock()
+	defer pm.mapMu.Unlock()
+
+	pm.serverMap.ByProxyID[proxyID] = RouteLocation{Server: serverName, RouteID: routeID}
+	if listen != "" {
+		pm.serverMap.ByListen[listen] = serverName
+	}
+
+	if err := SaveServerMap(pm.serverMapPath, pm.serverMap); err != nil {
+		logger.Error("caddy", "Failed to save server map: %v", err)
+	}
+}
+
+// ListenPorts returns the ports live Caddy servers listen on, mapped to the
+// server's name
+func (pm *ProxyManager) ListenPorts() (map[int]string, error) {
+	servers, err := pm.listServers()
+	if err != nil {
+		return nil, fmt.Errorf("list servers: %w", err)
+	}
+
+	ports := make(map[int]string)
+	for _, name := range sortedServerNames(servers) {
+		if servers[name] == nil {
+			continue
+		}
+		for _, addr := range servers[name].Listen {
+			if port, ok := parseListenPort([]string{addr}); ok {
+				if _, seen := ports[port]; !seen {
+					ports[port] = name
+				}
+			}
+		}
+	}
+	return ports, nil
+}
+
+func serverHasProxyRoute(server *HTTPServer, proxyID string) bool {
+	if server == nil {
+		return false
+	}
+	for _, route := range server.Routes {
+		if routeHasID(route, proxyID) {
+			return true
+		}
+	}
+	return false
+}
+
+func serverListensOn(server *HTTPServer, port int) bool {
+	if server == nil {
+		return false
+	}
+	for _, addr := range server.Listen {
+		if listenPort, ok := parseListenPort([]string{addr}); ok && listenPort == port {
+			return true
+		}
+	}
+	return false
+}
+
+func routeHasHostMatcher(route Route) bool {
+	for _, matcher := range route.Match {
+		if len(matcher.Host) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedServerNames(servers map[string]*HTTPServer) []string {
+	names := make([]string, 0, len(servers))
+	for name := range servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,82 @@
+package caddy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/config"
+	"github.com/sudocarlos/tailrelay-webui/internal/logger"
+)
+
+// UpstreamTLSEnabled reports whether a proxy talks HTTPS to its upstreams
+func UpstreamTLSEnabled(proxy config.CaddyProxy) bool {
+	return proxy.TLS ||
+		proxy.TLSCertFile != "" ||
+		proxy.TLSClientCertFile != "" ||
+		proxy.TLSServerName != "" ||
+		proxy.TLSInsecureSkipVerify
+}
+
+// buildUpstreamTLS builds the reverse_proxy transport TLS settings for a
+// proxy, or nil when its upstreams are plain HTTP
+func buildUpstreamTLS(proxy config.CaddyProxy) (*TLSConfig, error) {
+	if !UpstreamTLSEnabled(proxy) {
+		return nil, nil
+	}
+
+	if (proxy.TLSClientCertFile == "") != (proxy.TLSClientKeyFile == "") {
+		return nil, fmt.Errorf("client certificate and key must be set together")
+	}
+	if proxy.TLSHandshakeTimeout != "" {
+		if _, err := time.ParseDuration(proxy.TLSHandshakeTimeout); err != nil {
+			return nil, fmt.Errorf("invalid handshake timeout %q", proxy.TLSHandshakeTimeout)
+		}
+	}
+
+	tlsConfig := &TLSConfig{
+		ClientCertificateFile: proxy.TLSClientCertFile,
+		ClientCertificateKey:  proxy.TLSClientKeyFile,
+		ServerName:            proxy.TLSServerName,
+		InsecureSkipVerify:    proxy.TLSInsecureSkipVerify,
+		HandshakeTimeout:      proxy.TLSHandshakeTimeout,
+	}
+	if proxy.TLSCertFile != "" {
+		tlsConfig.CA = &TLSCAConfig{
+			Provider: "file",
+			PEMFiles: []string{proxy.TLSCertFile},
+		}
+	}
+
+	if proxy.TLSInsecureSkipVerify {
+		logger.Warn("caddy", "Proxy %s (%s) skips upstream TLS certificate verification; connections to its upstreams can be intercepted",
+			proxy.ID, proxy.Hostname)
+	}
+
+	return tlsConfig, nil
+}
+
+// extractUpstreamTLS reads upstream TLS settings back from a reverse_proxy handler
+func extractUpstreamTLS(proxy *config.CaddyProxy, reverseProxyHandler Handler) {
+	transport, ok := reverseProxyHandler["transport"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	tlsConfig, ok := transport["tls"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	proxy.TLS = true
+	if caCfg, ok := tlsConfig["ca"].(map[string]interface{}); ok {
+		if pemFiles, ok := caCfg["pem_files"].([]interface{}); ok && len(pemFiles) > 0 {
+			if pemFile, ok := pemFiles[0].(string); ok {
+				proxy.TLSCertFile = pemFile
+			}
+		}
+	}
+	proxy.TLSClientCertFile, _ = tlsConfig["client_certificate_file"].(string)
+	proxy.TLSClientKeyFile, _ = tlsConfig["client_certificate_key_file"].(string)
+	proxy.TLSServerName, _ = tlsConfig["server_name"].(string)
+	proxy.TLSInsecureSkipVerify, _ = tlsConfig["insecure_skip_verify"].(bool)
+	proxy.TLSHandshakeTimeout = durationValue(tlsConfig["handshake_timeout"])
+}
@@ -0,0 +1,154 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ExpiryWarning is how long before it expires a certificate is flagged
+const ExpiryWarning = 30 * 24 * time.Hour
+
+// Certificate expiry states
+const (
+	StatusOK       = "ok"
+	StatusExpiring = "expiring"
+	StatusExpired  = "expired"
+)
+
+var (
+	// ErrNotPEM is returned for uploads that contain no PEM data
+	ErrNotPEM = errors.New("file is not PEM encoded")
+	// ErrPrivateKey is returned when a certificate upload contains a private key
+	ErrPrivateKey = errors.New("file contains a private key; upload only the certificate")
+	// ErrNoCertificate is returned when PEM data holds no certificate
+	ErrNoCertificate = errors.New("file contains no certificate")
+	// ErrNoPrivateKey is returned when a key upload holds no private key
+	ErrNoPrivateKey = errors.New("file contains no private key")
+)
+
+// Certificate summarizes an X.509 certificate
+type Certificate struct {
+	Subject       string    `json:"subject"`
+	Issuer        string    `json:"issuer"`
+	DNSNames      []string  `json:"dns_names,omitempty"`
+	IPAddresses   []string  `json:"ip_addresses,omitempty"`
+	NotBefore     time.Time `json:"not_before"`
+	NotAfter      time.Time `json:"not_after"`
+	ExpiresInDays int       `json:"expires_in_days"`
+	SHA256        string    `json:"sha256"`
+}
+
+// Fingerprint is the hex SHA-256 of a certificate's DER encoding
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// Describe summarizes a chain, keeping its order
+func Describe(chain []*x509.Certificate) []Certificate {
+	now := time.Now()
+	described := make([]Certificate, 0, len(chain))
+	for _, cert := range chain {
+		summary := Certificate{
+			Subject:       cert.Subject.String(),
+			Issuer:        cert.Issuer.String(),
+			DNSNames:      cert.DNSNames,
+			NotBefore:     cert.NotBefore,
+			NotAfter:      cert.NotAfter,
+			ExpiresInDays: int(cert.NotAfter.Sub(now).Hours() / 24),
+			SHA256:        Fingerprint(cert),
+		}
+		for _, ip := range cert.IPAddresses {
+			summary.IPAddresses = append(summary.IPAddresses, ip.String())
+		}
+		described = append(described, summary)
+	}
+	return described
+}
+
+// EncodeChain encodes a chain as concatenated PEM certificates, the format
+// Caddy's file trust pool reads
+func EncodeChain(chain []*x509.Certificate) []byte {
+	var buf bytes.Buffer
+	for _, cert := range chain {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return buf.Bytes()
+}
+
+// ExpiryStatus classifies a certificate's expiry at the given time
+func ExpiryStatus(notAfter, now time.Time) string {
+	switch {
+	case now.After(notAfter):
+		return StatusExpired
+	case notAfter.Sub(now) < ExpiryWarning:
+		return StatusExpiring
+	default:
+		return StatusOK
+	}
+}
+
+// ParseCertificates parses PEM data meant as a certificate or trust pool. It
+// rejects anything but certificates, private keys in particular.
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	found := false
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		found = true
+		switch {
+		case strings.HasSuffix(block.Type, "PRIVATE KEY"):
+			return nil, ErrPrivateKey
+		case block.Type != "CERTIFICATE":
+			return nil, fmt.Errorf("unexpected PEM block %q", block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse certificate: %w", err)
+		}
+		chain = append(chain, cert)
+	}
+	if !found {
+		return nil, ErrNotPEM
+	}
+	if len(chain) == 0 {
+		return nil, ErrNoCertificate
+	}
+	return chain, nil
+}
+
+// ValidatePrivateKey checks that PEM data holds a single parseable private key
+func ValidatePrivateKey(data []byte) error {
+	block, rest := pem.Decode(data)
+	if block == nil {
+		return ErrNotPEM
+	}
+	if !strings.HasSuffix(block.Type, "PRIVATE KEY") {
+		return ErrNoPrivateKey
+	}
+	if next, _ := pem.Decode(rest); next != nil {
+		return fmt.Errorf("file contains more than the private key")
+	}
+
+	if _, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return nil
+	}
+	if _, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return nil
+	}
+	if _, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return nil
+	}
+	return fmt.Errorf("unsupported or encrypted private key")
+}
@@ -0,0 +1,183 @@
+package certs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kinds of files in the certificates dir
+const (
+	KindCertificate = "certificate"
+	KindPrivateKey  = "private_key"
+	KindInvalid     = "invalid"
+)
+
+var (
+	// ErrNotFound is returned for files that aren't in the certificates dir
+	ErrNotFound = errors.New("certificate file not found")
+	// ErrInUse is returned when deleting a file a proxy still references
+	ErrInUse = errors.New("certificate file is in use")
+)
+
+// certExtensions are the files the store manages; the certificates dir may
+// be shared with other state
+var certExtensions = []string{".pem", ".crt", ".cer", ".key"}
+
+// Reference is a proxy setting that points at a stored file
+type Reference struct {
+	ProxyID  string `json:"proxy_id"`
+	Hostname string `json:"hostname"`
+	Field    string `json:"field"` // The proxy's JSON field, e.g. "tls_cert_file"
+}
+
+// References maps cleaned file paths to the settings that use them
+type References map[string][]Reference
+
+// Add records that a setting uses path
+func (r References) Add(path string, ref Reference) {
+	if path == "" {
+		return
+	}
+	path = filepath.Clean(path)
+	r[path] = append(r[path], ref)
+}
+
+// Entry is a file in the certificates dir
+type Entry struct {
+	Name         string        `json:"name"`
+	Path         string        `json:"path"`
+	Size         int64         `json:"size"`
+	ModTime      time.Time     `json:"mod_time"`
+	Kind         string        `json:"kind"`
+	Error        string        `json:"error,omitempty"` // Why an invalid file didn't parse
+	Certificates []Certificate `json:"certificates,omitempty"`
+	NotAfter     *time.Time    `json:"not_after,omitempty"` // Earliest expiry in the file
+	Status       string        `json:"status,omitempty"`    // ok, expiring or expired; certificates only
+	ReferencedBy []Reference   `json:"referenced_by"`
+}
+
+// Store manages the certificate files in a directory
+type Store struct {
+	dir string
+}
+
+// NewStore creates a store for dir
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// List parses every certificate and key file in the store, sorted by name
+func (s *Store) List(refs References) ([]Entry, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Entry{}, nil
+		}
+		return nil, fmt.Errorf("read certificates dir: %w", err)
+	}
+
+	now := time.Now()
+	entries := []Entry{}
+	for _, file := range files {
+		if !file.Type().IsRegular() || !isCertFile(file.Name()) {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+
+		path := filepath.Join(s.dir, file.Name())
+		entry := Entry{
+			Name:         file.Name(),
+			Path:         path,
+			Size:         info.Size(),
+			ModTime:      info.ModTime(),
+			ReferencedBy: refs[filepath.Clean(path)],
+		}
+		if entry.ReferencedBy == nil {
+			entry.ReferencedBy = []Reference{}
+		}
+		inspect(&entry, now)
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+// inspect fills in what an entry's file holds
+func inspect(entry *Entry, now time.Time) {
+	data, err := os.ReadFile(entry.Path)
+	if err != nil {
+		entry.Kind = KindInvalid
+		entry.Error = err.Error()
+		return
+	}
+
+	chain, err := ParseCertificates(data)
+	switch {
+	case err == nil:
+		entry.Kind = KindCertificate
+		entry.Certificates = Describe(chain)
+		notAfter := chain[0].NotAfter
+		for _, cert := range chain[1:] {
+			if cert.NotAfter.Before(notAfter) {
+				notAfter = cert.NotAfter
+			}
+		}
+		entry.NotAfter = &notAfter
+		entry.Status = ExpiryStatus(notAfter, now)
+	case errors.Is(err, ErrPrivateKey) && ValidatePrivateKey(data) == nil:
+		entry.Kind = KindPrivateKey
+	default:
+		entry.Kind = KindInvalid
+		entry.Error = err.Error()
+	}
+}
+
+// Delete removes a file from the store unless a setting references it
+func (s *Store) Delete(name string, refs References) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if len(refs[path]) > 0 {
+		return fmt.Errorf("%w by %d proxy setting(s)", ErrInUse, len(refs[path]))
+	}
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("remove %s: %w", name, err)
+	}
+	return nil
+}
+
+// path resolves a file name in the store, refusing anything outside it
+func (s *Store) path(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") || !isCertFile(name) {
+		return "", ErrNotFound
+	}
+	path := filepath.Clean(filepath.Join(s.dir, name))
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", ErrNotFound
+	}
+	return path, nil
+}
+
+func isCertFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, certExt := range certExtensions {
+		if ext == certExt {
+			return true
+		}
+	}
+	return false
+}
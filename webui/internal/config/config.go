@@ -36,6 +36,25 @@ func Load(filename string) (*Config, error) {
 	if cfg.Paths.CaddyServerMap == "" {
 		cfg.Paths.CaddyServerMap = "/var/lib/tailscale/caddy_servers.json"
 	}
+	if cfg.Reconcile.Interval == "" {
+		cfg.Reconcile.Interval = "60s"
+	}
+	if cfg.CaddyAdmin.Address == "" {
+		cfg.CaddyAdmin.Address = "localhost:2019"
+	}
+	if cfg.CaddyAdmin.ReadyTimeout == "" {
+		cfg.CaddyAdmin.ReadyTimeout = "30s"
+	}
+	if cfg.Ports.AutoAssignStart == 0 && cfg.Ports.AutoAssignEnd == 0 {
+		cfg.Ports.AutoAssignStart = 10000
+		cfg.Ports.AutoAssignEnd = 10999
+	}
+	if cfg.TailscaleCerts.RenewBefore == "" {
+		cfg.TailscaleCerts.RenewBefore = "720h"
+	}
+	if cfg.TailscaleCerts.CheckInterval == "" {
+		cfg.TailscaleCerts.CheckInterval = "12h"
+	}
 
 	return &cfg, nil
 }
@@ -105,6 +124,25 @@ func DefaultConfig() *Config {
 			Level:  "info",
 			Format: "text",
 		},
+		Reconcile: ReconcileConfig{
+			Enabled:  true,
+			Interval: "60s",
+			AutoHeal: false,
+		},
+		CaddyAdmin: CaddyAdminConfig{
+			Address:      "localhost:2019",
+			ReadyTimeout: "30s",
+		},
+		Ports: PortsConfig{
+			AutoAssignStart: 10000,
+			AutoAssignEnd:   10999,
+		},
+		TailscaleCerts: TailscaleCertsConfig{
+			Enabled:       true,
+			LoadIntoCaddy: false,
+			RenewBefore:   "720h",
+			CheckInterval: "12h",
+		},
 	}
 }
 
@@ -158,6 +196,12 @@ func GenerateToken() (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
+// IdentitySecretFile is where the secret Caddy presents on identity checks
+// is kept, next to the token file
+func (a AuthConfig) IdentitySecretFile() string {
+	return filepath.Join(filepath.Dir(a.TokenFile), ".webui_identity_secret")
+}
+
 // LoadOrGenerateToken loads token from file or generates a new one
 func LoadOrGenerateToken(filename string) (string, error) {
 	// Check if file exists
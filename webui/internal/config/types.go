@@ -1,14 +1,22 @@
 package config
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // Config represents the main application configuration
 type Config struct {
-	Server  ServerConfig  `yaml:"server"`
-	Auth    AuthConfig    `yaml:"auth"`
-	Paths   PathsConfig   `yaml:"paths"`
-	Backup  BackupConfig  `yaml:"backup"`
-	Logging LoggingConfig `yaml:"logging"`
+	Server     ServerConfig     `yaml:"server"`
+	Auth       AuthConfig       `yaml:"auth"`
+	Paths      PathsConfig      `yaml:"paths"`
+	Backup     BackupConfig     `yaml:"backup"`
+	Logging    LoggingConfig    `yaml:"logging"`
+	Reconcile  ReconcileConfig  `yaml:"reconcile"`
+	CaddyAdmin CaddyAdminConfig `yaml:"caddy_admin"`
+	Ports      PortsConfig      `yaml:"ports"`
+	// TailscaleCerts controls HTTPS certificates requested from tailscaled
+	TailscaleCerts TailscaleCertsConfig `yaml:"tailscale_certs"`
 }
 
 // ServerConfig contains HTTP server settings
@@ -48,18 +56,176 @@ type LoggingConfig struct {
 	Format string `yaml:"format"`
 }
 
+// ReconcileConfig controls the background check of Caddy against proxy metadata
+type ReconcileConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Interval string `yaml:"interval"`  // e.g. "60s"
+	AutoHeal bool   `yaml:"auto_heal"` // Re-apply metadata when drift is found
+}
+
+// CaddyAdminConfig sets how the web UI reaches Caddy's admin API
+type CaddyAdminConfig struct {
+	Address string `yaml:"address"` // "unix//path/to/admin.sock", "localhost:2019" or a full URL
+	Origin  string `yaml:"origin"`  // Sent as the Origin header, for admin configs with enforce_origin
+	// ReadyTimeout is how long startup waits for the admin API, e.g. "30s"
+	ReadyTimeout string `yaml:"ready_timeout"`
+}
+
+// PortsConfig sets the range relays and proxies are given ports from when
+// they are created without one
+type PortsConfig struct {
+	AutoAssignStart int `yaml:"auto_assign_start"`
+	AutoAssignEnd   int `yaml:"auto_assign_end"`
+}
+
+// TailscaleCertsConfig controls the HTTPS certificates the web UI requests
+// for the node's MagicDNS name and stores in the certificates dir
+type TailscaleCertsConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	LoadIntoCaddy bool   `yaml:"load_into_caddy"` // Have Caddy serve the stored certs instead of fetching its own
+	RenewBefore   string `yaml:"renew_before"`    // Ask for a new cert when less than this is left, e.g. "720h"
+	CheckInterval string `yaml:"check_interval"`  // e.g. "12h"
+}
+
 // CaddyProxy represents a Caddy reverse proxy configuration
 type CaddyProxy struct {
-	ID             string            `json:"id"`
-	Hostname       string            `json:"hostname"`
-	Port           int               `json:"port"`
-	Target         string            `json:"target"`
-	TLS            bool              `json:"tls"`
-	TLSCertFile    string            `json:"tls_cert_file,omitempty"`
-	TrustedProxies bool              `json:"trusted_proxies"`
-	CustomHeaders  map[string]string `json:"custom_headers,omitempty"`
-	Enabled        bool              `json:"enabled"`
-	Autostart      bool              `json:"autostart"` // Start automatically on container boot
+	ID                    string               `json:"id"`
+	Kind                  string               `json:"kind,omitempty"` // Empty or "reverse_proxy", "redirect", "static_response", "file_server"
+	Hostname              string               `json:"hostname"`
+	Port                  int                  `json:"port"`
+	Target                string               `json:"target"`
+	Upstreams             []string             `json:"upstreams,omitempty"` // Additional targets balanced alongside Target
+	LoadBalancing         *CaddyLoadBalancing  `json:"load_balancing,omitempty"`
+	HealthChecks          *CaddyHealthChecks   `json:"health_checks,omitempty"`
+	TLS                   bool                 `json:"tls"`
+	TLSCertFile           string               `json:"tls_cert_file,omitempty"`
+	TLSClientCertFile     string               `json:"tls_client_cert_file,omitempty"` // Client certificate for upstream mTLS
+	TLSClientKeyFile      string               `json:"tls_client_key_file,omitempty"`
+	TLSServerName         string               `json:"tls_server_name,omitempty"`          // SNI override for the upstream handshake
+	TLSInsecureSkipVerify bool                 `json:"tls_insecure_skip_verify,omitempty"` // Disables upstream certificate verification
+	TLSHandshakeTimeout   string               `json:"tls_handshake_timeout,omitempty"`
+	TLSPinnedSHA256       string               `json:"tls_pinned_sha256,omitempty"`      // Upstream leaf certificate trusted on first use
+	Protocol              string               `json:"protocol,omitempty"`               // Upstream protocol: empty (auto), "http1", "h2", "h2c" or "grpc"
+	FrontendTLSMode       string               `json:"frontend_tls_mode,omitempty"`      // Empty (tailscale), "tailscale", "internal", "load_files" or "http"
+	FrontendTLSCertFile   string               `json:"frontend_tls_cert_file,omitempty"` // Used when FrontendTLSMode is "load_files"
+	FrontendTLSKeyFile    string               `json:"frontend_tls_key_file,omitempty"`
+	TrustedProxies        bool                 `json:"trusted_proxies"`
+	CustomHeaders         map[string]string    `json:"custom_headers,omitempty"` // Deprecated: use HeaderRules
+	HeaderRules           []CaddyHeaderRule    `json:"header_rules,omitempty"`
+	PreserveHost          bool                 `json:"preserve_host,omitempty"` // Pass the client's Host header upstream
+	PathRules             []CaddyPathRule      `json:"path_rules,omitempty"`    // Evaluated in order before Target
+	BasicAuth             []CaddyBasicAuthUser `json:"basic_auth,omitempty"`
+	AllowCIDRs            []string             `json:"allow_cidrs,omitempty"` // Only these client IPs/ranges may connect
+	DenyCIDRs             []string             `json:"deny_cidrs,omitempty"`  // Checked before AllowCIDRs
+	TailnetIdentity       *CaddyIdentityPolicy `json:"tailnet_identity,omitempty"`
+	Redirect              *CaddyRedirect       `json:"redirect,omitempty"`        // Used when Kind is "redirect"
+	StaticResponse        *CaddyStaticResponse `json:"static_response,omitempty"` // Used when Kind is "static_response"
+	FileServer            *CaddyFileServer     `json:"file_server,omitempty"`     // Used when Kind is "file_server"
+	Extra                 json.RawMessage      `json:"extra,omitempty"`           // Caddy route settings the UI doesn't model, merged back into the route
+	Advanced              bool                 `json:"advanced,omitempty"`        // Set when Extra is present; those settings can't be edited in the UI
+	Enabled               bool                 `json:"enabled"`
+	Autostart             bool                 `json:"autostart"` // Start automatically on container boot
+}
+
+// Route kinds a CaddyProxy can take
+const (
+	ProxyKindReverseProxy   = "reverse_proxy"
+	ProxyKindRedirect       = "redirect"
+	ProxyKindStaticResponse = "static_response"
+	ProxyKindFileServer     = "file_server"
+)
+
+// Frontend TLS modes: where the certificate clients see comes from
+const (
+	FrontendTLSTailscale = "tailscale"  // Tailscale HTTPS certificate for the MagicDNS name (the default)
+	FrontendTLSInternal  = "internal"   // Caddy's internal CA, for names outside the tailnet
+	FrontendTLSLoadFiles = "load_files" // A user-supplied certificate and key
+	FrontendTLSHTTP      = "http"       // Plain HTTP without TLS
+)
+
+// Upstream protocol modes a CaddyProxy can use
+const (
+	ProxyProtocolHTTP1 = "http1"
+	ProxyProtocolH2    = "h2"
+	ProxyProtocolH2C   = "h2c"
+	ProxyProtocolGRPC  = "grpc"
+)
+
+// CaddyRedirect sends clients to another URL
+type CaddyRedirect struct {
+	URL        string `json:"url"`                   // May use Caddy placeholders, e.g. "https://example.com{http.request.uri}"
+	StatusCode int    `json:"status_code,omitempty"` // Defaults to 302
+}
+
+// CaddyStaticResponse answers requests with a fixed response
+type CaddyStaticResponse struct {
+	StatusCode int               `json:"status_code,omitempty"` // Defaults to 200
+	Body       string            `json:"body,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+}
+
+// CaddyFileServer serves files from a directory under the state dir
+type CaddyFileServer struct {
+	Root   string `json:"root"` // Relative to the www dir in the state dir
+	Browse bool   `json:"browse,omitempty"`
+}
+
+// CaddyLoadBalancing controls how a proxy picks between its upstreams
+type CaddyLoadBalancing struct {
+	Policy      string `json:"policy,omitempty"`      // round_robin, first, least_conn, ip_hash or cookie
+	CookieName  string `json:"cookie_name,omitempty"` // Only used by the cookie policy
+	Retries     int    `json:"retries,omitempty"`
+	TryDuration string `json:"try_duration,omitempty"` // e.g. "5s"
+	TryInterval string `json:"try_interval,omitempty"`
+}
+
+// CaddyHealthChecks configures active and passive upstream health checks
+type CaddyHealthChecks struct {
+	// Active checks run only when URI is set
+	URI          string `json:"uri,omitempty"`
+	Interval     string `json:"interval,omitempty"`
+	Timeout      string `json:"timeout,omitempty"`
+	ExpectStatus int    `json:"expect_status,omitempty"`
+	ExpectBody   string `json:"expect_body,omitempty"`
+
+	// Passive checks run when MaxFails or UnhealthyLatency is set
+	MaxFails         int    `json:"max_fails,omitempty"`
+	FailDuration     string `json:"fail_duration,omitempty"` // Defaults to 30s
+	UnhealthyLatency string `json:"unhealthy_latency,omitempty"`
+}
+
+// CaddyBasicAuthUser is an HTTP basic auth account for a proxy.
+// Only the bcrypt hash of the password is ever stored.
+type CaddyBasicAuthUser struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+}
+
+// CaddyIdentityPolicy requires callers to have a tailnet identity, optionally
+// limited to specific users, nodes or ACL tags (any match is allowed)
+type CaddyIdentityPolicy struct {
+	Enabled    bool     `json:"enabled"`
+	AllowUsers []string `json:"allow_users,omitempty"` // Login names, e.g. alice@example.com
+	AllowNodes []string `json:"allow_nodes,omitempty"` // Node names, short or MagicDNS FQDN
+	AllowTags  []string `json:"allow_tags,omitempty"`  // ACL tags, e.g. tag:admin
+}
+
+// CaddyPathRule routes requests matching a path to a dedicated upstream
+type CaddyPathRule struct {
+	Path          string            `json:"path"` // Caddy path matcher, e.g. "/api/*"
+	Target        string            `json:"target"`
+	StripPrefix   bool              `json:"strip_prefix,omitempty"`
+	CustomHeaders map[string]string `json:"custom_headers,omitempty"` // Deprecated: use HeaderRules
+	HeaderRules   []CaddyHeaderRule `json:"header_rules,omitempty"`
+}
+
+// CaddyHeaderRule is a single request or response header operation
+type CaddyHeaderRule struct {
+	Direction string `json:"direction"` // "request" or "response"
+	Operation string `json:"operation"` // add, set, delete or replace
+	Field     string `json:"field"`
+	Value     string `json:"value,omitempty"`  // May contain Caddy placeholders; replacement text for replace
+	Search    string `json:"search,omitempty"` // Substring to find, replace only
 }
 
 // CaddyProxyList represents the list of Caddy proxies
@@ -0,0 +1,46 @@
+package diagnostics
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+)
+
+// FetchChain connects to a TLS upstream and returns the certificate chain it
+// presents, leaf first. The chain is not verified, so self-signed upstreams
+// can be inspected before they are trusted.
+func FetchChain(ctx context.Context, target Target) ([]*x509.Certificate, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+
+	host, port, err := splitAddress(target.Address, true)
+	if err != nil {
+		return nil, err
+	}
+	serverName := target.ServerName
+	if serverName == "" {
+		serverName = host
+	}
+	config, err := clientConfig(target, serverName)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := tls.Dialer{Config: config}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, fmt.Errorf("handshake with %s: %w", target.Address, err)
+	}
+	defer conn.Close()
+
+	chain := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("%s presented no certificate", target.Address)
+	}
+	return chain, nil
+}
@@ -0,0 +1,301 @@
+package diagnostics
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/certs"
+)
+
+// DefaultTimeout bounds a whole probe when the context has no deadline
+const DefaultTimeout = 15 * time.Second
+
+// Step names, in the order a probe runs them
+const (
+	StepDNS  = "dns"
+	StepTCP  = "tcp"
+	StepTLS  = "tls"
+	StepHTTP = "http"
+)
+
+// Target describes an upstream to probe, as a proxy or relay dials it
+type Target struct {
+	Address string `json:"address"` // host:port; the port defaults to 443 with TLS, 80 otherwise
+
+	TLS                bool   `json:"tls"`
+	ServerName         string `json:"server_name,omitempty"`          // SNI and verified name; defaults to the host
+	CAFile             string `json:"ca_file,omitempty"`              // PEM trust pool; the system pool when empty
+	ClientCertFile     string `json:"client_cert_file,omitempty"`     // Client certificate for mutual TLS
+	ClientKeyFile      string `json:"client_key_file,omitempty"`      // Must be set with ClientCertFile
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"` // Chain is still reported, but not verified
+
+	HTTP bool   `json:"http"`           // Send an HTTP request once connected
+	Path string `json:"path,omitempty"` // Request path, "/" when empty
+	Host string `json:"host,omitempty"` // Host header; defaults to the address host
+}
+
+// Step is the outcome of one stage of a probe
+type Step struct {
+	Name       string  `json:"name"`
+	OK         bool    `json:"ok"`
+	Skipped    bool    `json:"skipped,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+	Detail     string  `json:"detail,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// Report is the step-by-step result of a probe. Later steps are skipped once
+// one fails.
+type Report struct {
+	Address      string              `json:"address"`
+	OK           bool                `json:"ok"`
+	Steps        []Step              `json:"steps"`
+	Certificates []certs.Certificate `json:"certificates,omitempty"` // Leaf first
+	HTTPStatus   int                 `json:"http_status,omitempty"`
+	HTTPBody     []byte              `json:"-"` // Up to the first 64 KiB
+	TotalMs      float64             `json:"total_ms"`
+}
+
+// Probe resolves, connects to, and optionally handshakes with and requests
+// from a target, timing each step
+func Probe(ctx context.Context, target Target) *Report {
+	report := &Report{Address: target.Address}
+	start := time.Now()
+	defer func() { report.TotalMs = millis(time.Since(start)) }()
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+
+	host, port, err := splitAddress(target.Address, target.TLS)
+	if err != nil {
+		report.fail(StepDNS, 0, err)
+		report.skipRest(StepDNS)
+		return report
+	}
+
+	// DNS
+	stepStart := time.Now()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		report.fail(StepDNS, time.Since(stepStart), err)
+		report.skipRest(StepDNS)
+		return report
+	}
+	report.pass(StepDNS, time.Since(stepStart), fmt.Sprintf("%s resolved to %s", host, strings.Join(addrs, ", ")))
+
+	// TCP
+	stepStart = time.Now()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(addrs[0], port))
+	if err != nil {
+		report.fail(StepTCP, time.Since(stepStart), err)
+		report.skipRest(StepTCP)
+		return report
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	report.pass(StepTCP, time.Since(stepStart), fmt.Sprintf("connected to %s from %s", conn.RemoteAddr(), conn.LocalAddr()))
+
+	// TLS
+	if target.TLS {
+		stepStart = time.Now()
+		tlsConn, detail, err := report.handshake(ctx, conn, host, target)
+		if err != nil {
+			report.fail(StepTLS, time.Since(stepStart), err)
+			report.skipRest(StepTLS)
+			return report
+		}
+		report.pass(StepTLS, time.Since(stepStart), detail)
+		conn = tlsConn
+	} else {
+		report.skip(StepTLS, "upstream is plain TCP")
+	}
+
+	// HTTP
+	if target.HTTP {
+		stepStart = time.Now()
+		status, body, err := request(conn, host, target)
+		if err != nil {
+			report.fail(StepHTTP, time.Since(stepStart), err)
+			return report
+		}
+		report.HTTPStatus = status
+		report.HTTPBody = body
+		report.pass(StepHTTP, time.Since(stepStart), fmt.Sprintf("%d %s", status, http.StatusText(status)))
+	} else {
+		report.skip(StepHTTP, "no HTTP request for this target")
+	}
+
+	report.OK = true
+	return report
+}
+
+// handshake runs the TLS handshake and verifies the presented chain against
+// the target's trust pool. The handshake itself never verifies, so the chain
+// is reported even when it isn't trusted.
+func (report *Report) handshake(ctx context.Context, conn net.Conn, host string, target Target) (*tls.Conn, string, error) {
+	serverName := target.ServerName
+	if serverName == "" {
+		serverName = host
+	}
+
+	config, err := clientConfig(target, serverName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var roots *x509.CertPool
+	if target.CAFile != "" {
+		pem, err := os.ReadFile(target.CAFile)
+		if err != nil {
+			return nil, "", fmt.Errorf("read trust pool: %w", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, "", fmt.Errorf("no certificates found in %s", target.CAFile)
+		}
+	}
+
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, "", fmt.Errorf("handshake: %w", err)
+	}
+
+	state := tlsConn.ConnectionState()
+	report.Certificates = certs.Describe(state.PeerCertificates)
+	if len(state.PeerCertificates) == 0 {
+		return nil, "", fmt.Errorf("upstream presented no certificate")
+	}
+	detail := fmt.Sprintf("%s, %s", tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite))
+
+	if target.InsecureSkipVerify {
+		return tlsConn, detail + ", certificate not verified (insecure_skip_verify)", nil
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	leaf := state.PeerCertificates[0]
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates}); err != nil {
+		return nil, "", fmt.Errorf("verify certificate: %w", err)
+	}
+	if err := leaf.VerifyHostname(serverName); err != nil {
+		return nil, "", fmt.Errorf("verify certificate: %w", err)
+	}
+	return tlsConn, detail + ", certificate verified for " + serverName, nil
+}
+
+// clientConfig builds the TLS settings for a handshake with target. The
+// chain is never verified during the handshake; callers verify it afterwards.
+func clientConfig(target Target, serverName string) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	}
+	if target.ClientCertFile != "" || target.ClientKeyFile != "" {
+		clientCert, err := tls.LoadX509KeyPair(target.ClientCertFile, target.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{clientCert}
+	}
+	return config, nil
+}
+
+// request sends a GET over an established connection and reads the status
+// and the start of the body
+func request(conn net.Conn, host string, target Target) (int, []byte, error) {
+	path := target.Path
+	if path == "" {
+		path = "/"
+	}
+	req, err := http.NewRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("build request: %w", err)
+	}
+	req.Host = target.Host
+	if req.Host == "" {
+		req.Host = host
+	}
+	req.Header.Set("User-Agent", "tailrelay-probe")
+	req.Close = true
+
+	if err := req.Write(conn); err != nil {
+		return 0, nil, fmt.Errorf("send request: %w", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("read response: %w", err)
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	return resp.StatusCode, body, nil
+}
+
+// splitAddress splits a dial address, defaulting the port
+func splitAddress(address string, useTLS bool) (string, string, error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return "", "", fmt.Errorf("no address to probe")
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		// No port given
+		host, port = strings.Trim(address, "[]"), "80"
+		if useTLS {
+			port = "443"
+		}
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", "", fmt.Errorf("invalid port %q", port)
+	}
+	if host == "" {
+		return "", "", fmt.Errorf("no host in %q", address)
+	}
+	return host, port, nil
+}
+
+func (report *Report) pass(name string, elapsed time.Duration, detail string) {
+	report.Steps = append(report.Steps, Step{Name: name, OK: true, DurationMs: millis(elapsed), Detail: detail})
+}
+
+func (report *Report) fail(name string, elapsed time.Duration, err error) {
+	report.Steps = append(report.Steps, Step{Name: name, DurationMs: millis(elapsed), Error: err.Error()})
+}
+
+func (report *Report) skip(name, reason string) {
+	report.Steps = append(report.Steps, Step{Name: name, OK: true, Skipped: true, Detail: reason})
+}
+
+// skipRest marks the steps after a failed one as skipped
+func (report *Report) skipRest(failed string) {
+	steps := []string{StepDNS, StepTCP, StepTLS, StepHTTP}
+	for i, name := range steps {
+		if name != failed {
+			continue
+		}
+		for _, rest := range steps[i+1:] {
+			report.Steps = append(report.Steps, Step{Name: rest, Skipped: true, Detail: "skipped after " + failed + " failed"})
+		}
+	}
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
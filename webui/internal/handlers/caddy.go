@@ -2,48 +2,147 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/sudocarlos/tailrelay-webui/internal/caddy"
+	"github.com/sudocarlos/tailrelay-webui/internal/certs"
 	"github.com/sudocarlos/tailrelay-webui/internal/config"
+	"github.com/sudocarlos/tailrelay-webui/internal/ports"
 	"github.com/sudocarlos/tailrelay-webui/internal/tailscale"
 )
 
+// insecureSkipVerifyWarning is returned whenever a saved proxy skips upstream certificate checks
+const insecureSkipVerifyWarning = "Upstream TLS certificate verification is disabled for this proxy; traffic to its upstreams can be intercepted"
+
+// caddyConflictMessage is returned when Caddy's config kept changing underneath a save
+const caddyConflictMessage = "Caddy config was changed by someone else; reload and try again"
+
 // CaddyHandler handles Caddy-related requests
 type CaddyHandler struct {
 	cfg       *config.Config
 	templates *template.Template
 	manager   *caddy.Manager
 	tsClient  *tailscale.Client
+	ports     *ports.Registry
+	pins      *pinMonitor
+	health    *healthMonitor
 }
 
-// NewCaddyHandler creates a new Caddy handler
-func NewCaddyHandler(cfg *config.Config, templates *template.Template) *CaddyHandler {
+// NewCaddyHandler creates a new Caddy handler. Caddy sends identitySecret
+// on tailnet identity checks.
+func NewCaddyHandler(cfg *config.Config, templates *template.Template, identitySecret string) *CaddyHandler {
 	// Use Caddy API instead of file-based config
-	// Pass empty string for server name to enable auto-discovery
-	manager := caddy.NewManager(
-		caddy.DefaultAdminAPI,
+	manager := newCaddyManager(cfg)
+	// Caddy runs in the same container and calls the web UI directly
+	manager.SetIdentityEndpoint(identityDialAddress(cfg.Server), identitySecret)
+	manager.SetStateDir(cfg.Paths.StateDir, []string{
+		cfg.Auth.TokenFile,
+		cfg.Auth.IdentitySecretFile(),
+		cfg.Paths.SocatRelayConfig,
+		cfg.Paths.CaddyProxyConfig,
 		cfg.Paths.CaddyServerMap,
-	)
+		cfg.Paths.BackupDir,
+		cfg.Paths.CertificatesDir,
+	})
 
 	return &CaddyHandler{
 		cfg:       cfg,
 		templates: templates,
 		manager:   manager,
 		tsClient:  tailscale.NewClient(),
+		pins:      &pinMonitor{statuses: make(map[string]pinStatus)},
+		health:    &healthMonitor{results: make(map[string]activeHealth)},
 	}
 }
 
+// identityDialAddress is where Caddy reaches the web UI for identity checks:
+// the host it listens on, or loopback when it listens on every address
+func identityDialAddress(server config.ServerConfig) string {
+	host := server.Host
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+		if ip != nil && ip.To4() == nil {
+			host = "::1"
+		}
+	}
+	return net.JoinHostPort(host, strconv.Itoa(server.Port))
+}
+
+// newCaddyManager creates a Caddy manager for the configured admin endpoint
+func newCaddyManager(cfg *config.Config) *caddy.Manager {
+	manager := caddy.NewManager(cfg.CaddyAdmin.Address, cfg.Paths.CaddyServerMap)
+	manager.SetAdminOrigin(cfg.CaddyAdmin.Origin)
+	return manager
+}
+
+// SetPortRegistry sets the registry proxy ports are checked against
+func (h *CaddyHandler) SetPortRegistry(registry *ports.Registry) {
+	h.ports = registry
+}
+
+// reservePort checks a proxy's port with the port registry, assigning one
+// when it has none. The caller must release the reservation once saved.
+func (h *CaddyHandler) reservePort(proxy *config.CaddyProxy) (func(), error) {
+	if h.ports == nil {
+		return func() {}, nil
+	}
+	claim := ports.Claim{
+		Owner: ports.OwnerProxy,
+		ID:    proxy.ID,
+		Name:  fmt.Sprintf("proxy %s", proxy.Hostname),
+	}
+	port, release, err := h.ports.Reserve(claim, proxy.Port)
+	if err != nil {
+		return nil, err
+	}
+	proxy.Port = port
+	return release, nil
+}
+
+// basicAuthUserView is a basic auth account as the API returns it
+type basicAuthUserView struct {
+	Username string `json:"username"`
+}
+
+// proxyView is a proxy as the API returns it. Password hashes stay in the
+// metadata file so a UI session can't take them away to crack offline.
+type proxyView struct {
+	config.CaddyProxy
+	BasicAuth []basicAuthUserView `json:"basic_auth,omitempty"`
+}
+
+// newProxyView strips what the API must not return from a proxy
+func newProxyView(proxy config.CaddyProxy) proxyView {
+	view := proxyView{CaddyProxy: proxy}
+	for _, user := range proxy.BasicAuth {
+		view.BasicAuth = append(view.BasicAuth, basicAuthUserView{Username: user.Username})
+	}
+	view.CaddyProxy.BasicAuth = nil
+	return view
+}
+
+// newProxyViews strips what the API must not return from each proxy
+func newProxyViews(proxies []config.CaddyProxy) []proxyView {
+	views := make([]proxyView, 0, len(proxies))
+	for _, proxy := range proxies {
+		views = append(views, newProxyView(proxy))
+	}
+	return views
+}
+
 // MigrateExistingProxies migrates existing Caddy proxies to metadata storage
 func (h *CaddyHandler) MigrateExistingProxies() error {
 	return h.manager.MigrateExistingProxies()
@@ -54,6 +153,258 @@ func (h *CaddyHandler) InitializeAutostart() error {
 	return h.manager.InitializeAutostart()
 }
 
+// Manager returns the Caddy manager shared with other handlers
+func (h *CaddyHandler) Manager() *caddy.Manager {
+	return h.manager
+}
+
+// WaitForCaddy blocks until Caddy's admin API is ready or the configured
+// timeout passes
+func (h *CaddyHandler) WaitForCaddy() error {
+	timeout, err := time.ParseDuration(h.cfg.CaddyAdmin.ReadyTimeout)
+	if err != nil || timeout <= 0 {
+		return fmt.Errorf("invalid caddy_admin ready_timeout %q", h.cfg.CaddyAdmin.ReadyTimeout)
+	}
+	return h.manager.WaitReady(timeout)
+}
+
+// StartReconciler starts the background drift check if it is enabled
+func (h *CaddyHandler) StartReconciler() error {
+	if !h.cfg.Reconcile.Enabled {
+		return nil
+	}
+	interval, err := time.ParseDuration(h.cfg.Reconcile.Interval)
+	if err != nil || interval <= 0 {
+		return fmt.Errorf("invalid reconcile interval %q", h.cfg.Reconcile.Interval)
+	}
+	h.manager.StartReconciler(interval, h.cfg.Reconcile.AutoHeal, nil)
+	return nil
+}
+
+// APIDrift returns a drift report comparing proxy metadata with live Caddy config
+func (h *CaddyHandler) APIDrift(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report, err := h.manager.DetectDrift()
+	if err != nil {
+		log.Printf("Error detecting drift: %v", err)
+		http.Error(w, "Failed to check Caddy config", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// APIForeign lists Caddy routes managed outside the web UI; they are
+// read-only until adopted
+func (h *CaddyHandler) APIForeign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	routes, err := h.manager.ForeignRoutes()
+	if err != nil {
+		log.Printf("Error listing foreign routes: %v", err)
+		http.Error(w, "Failed to read Caddy config", http.StatusInternalServerError)
+		return
+	}
+
+	// Foreign routes show basic auth usernames like managed proxies do
+	type foreignRouteView struct {
+		Key    string    `json:"key"`
+		Server string    `json:"server"`
+		Proxy  proxyView `json:"proxy"`
+	}
+	response := make([]foreignRouteView, 0, len(routes))
+	for _, route := range routes {
+		response = append(response, foreignRouteView{
+			Key:    route.Key,
+			Server: route.Server,
+			Proxy:  newProxyView(route.Proxy),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Adopt brings a foreign Caddy route under the web UI's management
+func (h *CaddyHandler) Adopt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Key string `json:"key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.Key == "" {
+		http.Error(w, "Route key is required", http.StatusBadRequest)
+		return
+	}
+
+	proxy, err := h.manager.AdoptRoute(request.Key, h.reservePort)
+	if err != nil {
+		log.Printf("Error adopting route: %v", err)
+		switch {
+		case ports.IsConflict(err):
+			writePortError(w, err)
+		case errors.Is(err, caddy.ErrForeignRouteNotFound):
+			http.Error(w, "Route not found; it may have changed, refresh and try again", http.StatusNotFound)
+		case caddy.IsConflict(err):
+			http.Error(w, caddyConflictMessage, http.StatusConflict)
+		default:
+			http.Error(w, fmt.Sprintf("Failed to adopt route: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":  "success",
+		"message": "Route adopted successfully",
+		"proxy":   newProxyView(*proxy),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// APIExport downloads the proxies as a Caddyfile (?format=caddyfile, the
+// default) or as the JSON metadata (?format=json). Basic auth password hashes
+// are left out unless a Caddyfile export sets ?password_hashes=true.
+func (h *CaddyHandler) APIExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "caddyfile":
+		caddyfile, err := h.manager.ExportCaddyfile(parseBool(r.URL.Query().Get("password_hashes")))
+		if err != nil {
+			log.Printf("Error exporting Caddyfile: %v", err)
+			http.Error(w, "Failed to export proxies", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="Caddyfile"`)
+		w.Write([]byte(caddyfile))
+	case "json":
+		proxies, err := h.manager.ListProxies()
+		if err != nil {
+			log.Printf("Error loading proxies: %v", err)
+			http.Error(w, "Failed to export proxies", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="caddy_proxies.json"`)
+		json.NewEncoder(w).Encode(map[string]interface{}{"proxies": newProxyViews(proxies)})
+	default:
+		http.Error(w, fmt.Sprintf("Unsupported format %q", format), http.StatusBadRequest)
+	}
+}
+
+// Import parses an uploaded Caddyfile into proxies. With preview=true it only
+// reports what would be created; otherwise every new, valid proxy whose port
+// is free is created.
+func (h *CaddyHandler) Import(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := readCaddyfileUpload(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if parseBool(r.URL.Query().Get("preview")) || parseBool(r.FormValue("preview")) {
+		preview, err := h.manager.PreviewCaddyfileImport(data, h.reservePort)
+		if err != nil {
+			writeImportError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "success",
+			"preview": preview,
+		})
+		return
+	}
+
+	preview, created, err := h.manager.ImportCaddyfile(data, h.reservePort)
+	if err != nil {
+		writeImportError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"message": fmt.Sprintf("Imported %d proxies", len(created)),
+		"proxies": newProxyViews(created),
+		"preview": preview,
+	})
+}
+
+// writeImportError reports a failed import: a Caddyfile Caddy can't adapt is
+// the client's fault, anything else is ours
+func writeImportError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, caddy.ErrInvalidCaddyfile):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case caddy.IsConflict(err):
+		log.Printf("Error importing Caddyfile: %v", err)
+		http.Error(w, caddyConflictMessage, http.StatusConflict)
+	default:
+		log.Printf("Error importing Caddyfile: %v", err)
+		http.Error(w, "Failed to import Caddyfile", http.StatusInternalServerError)
+	}
+}
+
+// readCaddyfileUpload reads a Caddyfile sent as a "caddyfile" multipart file
+// or form field, or as the raw request body
+func readCaddyfileUpload(r *http.Request) (string, error) {
+	const maxCaddyfileSize = 1 << 20
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(maxCaddyfileSize); err != nil {
+			return "", fmt.Errorf("failed to parse form data")
+		}
+		if file, _, err := r.FormFile("caddyfile"); err == nil {
+			defer file.Close()
+			data, err := io.ReadAll(io.LimitReader(file, maxCaddyfileSize))
+			if err != nil {
+				return "", fmt.Errorf("failed to read Caddyfile")
+			}
+			return string(data), nil
+		}
+		if value := r.FormValue("caddyfile"); value != "" {
+			return value, nil
+		}
+		return "", fmt.Errorf("caddyfile is required")
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxCaddyfileSize))
+	if err != nil {
+		return "", fmt.Errorf("failed to read Caddyfile")
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		return "", fmt.Errorf("caddyfile is required")
+	}
+	return string(data), nil
+}
+
 // List renders the Caddy proxy management page
 func (h *CaddyHandler) List(w http.ResponseWriter, r *http.Request) {
 	proxies, err := h.manager.ListProxies()
@@ -108,15 +459,35 @@ func (h *CaddyHandler) Create(w http.ResponseWriter, r *http.Request) {
 
 	proxy.Hostname = caddy.NormalizeHostname(proxy.Hostname)
 
+	// Credentials are only accepted through the basic auth endpoints so
+	// plaintext or unverified hashes can't slip in with the proxy
+	proxy.BasicAuth = nil
+
 	// Set default enabled state
 	if !proxy.Enabled {
 		proxy.Enabled = true
 	}
 
+	release, err := h.reservePort(&proxy)
+	if err != nil {
+		writePortError(w, err)
+		return
+	}
+	defer release()
+
+	if err := h.manager.ValidateProxy(proxy); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid proxy: %v", err), http.StatusBadRequest)
+		return
+	}
+
 	// Add proxy via API (no reload needed - API handles it instantly)
 	createdProxy, err := h.manager.AddProxy(proxy)
 	if err != nil {
 		log.Printf("Error adding proxy: %v", err)
+		if caddy.IsConflict(err) {
+			http.Error(w, caddyConflictMessage, http.StatusConflict)
+			return
+		}
 		http.Error(w, "Failed to add proxy", http.StatusInternalServerError)
 		return
 	}
@@ -124,7 +495,10 @@ func (h *CaddyHandler) Create(w http.ResponseWriter, r *http.Request) {
 	response := map[string]interface{}{
 		"status":  "success",
 		"message": "Proxy created successfully",
-		"proxy":   createdProxy,
+		"proxy":   newProxyView(*createdProxy),
+	}
+	if proxy.TLSInsecureSkipVerify {
+		response["warning"] = insecureSkipVerifyWarning
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -151,9 +525,35 @@ func (h *CaddyHandler) Update(w http.ResponseWriter, r *http.Request) {
 
 	proxy.Hostname = caddy.NormalizeHostname(proxy.Hostname)
 
+	// Keep stored credentials; they are managed through the basic auth endpoints
+	if existing, err := h.manager.GetProxy(proxy.ID); err == nil {
+		proxy.BasicAuth = existing.BasicAuth
+		// The edit form doesn't carry settings the UI can't model; an explicit
+		// "extra" (including {} to drop them) replaces them
+		if proxy.Extra == nil {
+			proxy.Extra = existing.Extra
+		}
+	}
+
+	release, err := h.reservePort(&proxy)
+	if err != nil {
+		writePortError(w, err)
+		return
+	}
+	defer release()
+
+	if err := h.manager.ValidateProxy(proxy); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid proxy: %v", err), http.StatusBadRequest)
+		return
+	}
+
 	// Update proxy via API (no reload needed - API handles it instantly)
 	if err := h.manager.UpdateProxy(proxy); err != nil {
 		log.Printf("Error updating proxy: %v", err)
+		if caddy.IsConflict(err) {
+			http.Error(w, caddyConflictMessage, http.StatusConflict)
+			return
+		}
 		http.Error(w, "Failed to update proxy", http.StatusInternalServerError)
 		return
 	}
@@ -161,7 +561,10 @@ func (h *CaddyHandler) Update(w http.ResponseWriter, r *http.Request) {
 	response := map[string]interface{}{
 		"status":  "success",
 		"message": "Proxy updated successfully",
-		"proxy":   proxy,
+		"proxy":   newProxyView(proxy),
+	}
+	if proxy.TLSInsecureSkipVerify {
+		response["warning"] = insecureSkipVerifyWarning
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -184,6 +587,10 @@ func (h *CaddyHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	// Delete proxy via API (no reload needed - API handles it instantly)
 	if err := h.manager.DeleteProxy(proxyID); err != nil {
 		log.Printf("Error deleting proxy: %v", err)
+		if caddy.IsConflict(err) {
+			http.Error(w, caddyConflictMessage, http.StatusConflict)
+			return
+		}
 		http.Error(w, "Failed to delete proxy", http.StatusInternalServerError)
 		return
 	}
@@ -222,6 +629,10 @@ func (h *CaddyHandler) Toggle(w http.ResponseWriter, r *http.Request) {
 	// Toggle proxy via API (no reload needed - API handles it instantly)
 	if err := h.manager.ToggleProxy(request.ID, request.Enabled); err != nil {
 		log.Printf("Error toggling proxy: %v", err)
+		if caddy.IsConflict(err) {
+			http.Error(w, caddyConflictMessage, http.StatusConflict)
+			return
+		}
 		http.Error(w, "Failed to toggle proxy", http.StatusInternalServerError)
 		return
 	}
@@ -272,18 +683,43 @@ func (h *CaddyHandler) APIList(w http.ResponseWriter, r *http.Request) {
 
 	running, _ := h.manager.GetStatus()
 
-	response := make([]struct {
-		config.CaddyProxy
-		Running bool `json:"running"`
-	}, 0, len(proxies))
+	var health map[string][]caddy.UpstreamHealth
+	if running {
+		if health, err = h.manager.GetProxyHealth(proxies); err != nil {
+			log.Printf("Error getting upstream health: %v", err)
+		}
+	}
+
+	type proxyStatus struct {
+		proxyView
+		Running   bool                   `json:"running"`
+		Healthy   bool                   `json:"healthy"`
+		Upstreams []caddy.UpstreamHealth `json:"upstream_health"`
+		// Set when the upstream no longer presents the pinned certificate
+		TLSPinChanged bool `json:"tls_pin_changed,omitempty"`
+	}
+
+	response := make([]proxyStatus, 0, len(proxies))
 
 	for _, proxy := range proxies {
-		response = append(response, struct {
-			config.CaddyProxy
-			Running bool `json:"running"`
-		}{
-			CaddyProxy: proxy,
-			Running:    running,
+		upstreams := health[proxy.ID]
+		h.applyActiveHealth(proxy.ID, upstreams)
+		healthy := false
+		for _, upstream := range upstreams {
+			if upstream.Healthy {
+				healthy = true
+				break
+			}
+		}
+
+		pin, _ := h.pins.get(proxy.ID)
+
+		response = append(response, proxyStatus{
+			proxyView:     newProxyView(proxy),
+			Running:       running && proxy.Enabled,
+			Healthy:       healthy,
+			Upstreams:     upstreams,
+			TLSPinChanged: pin.Changed && pin.Pinned == proxy.TLSPinnedSHA256,
 		})
 	}
 
@@ -307,7 +743,125 @@ func (h *CaddyHandler) APIGet(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(proxy)
+	json.NewEncoder(w).Encode(newProxyView(*proxy))
+}
+
+// APIBasicAuthList returns the basic auth usernames configured on a proxy
+func (h *CaddyHandler) APIBasicAuthList(w http.ResponseWriter, r *http.Request) {
+	proxyID := r.URL.Query().Get("id")
+	if proxyID == "" {
+		http.Error(w, "Proxy ID is required", http.StatusBadRequest)
+		return
+	}
+
+	proxy, err := h.manager.GetProxy(proxyID)
+	if err != nil {
+		log.Printf("Error getting proxy: %v", err)
+		http.Error(w, "Proxy not found", http.StatusNotFound)
+		return
+	}
+
+	users := make([]string, 0, len(proxy.BasicAuth))
+	for _, user := range proxy.BasicAuth {
+		users = append(users, user.Username)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":    proxy.ID,
+		"users": users,
+	})
+}
+
+// SetBasicAuthUser adds a basic auth user to a proxy or changes its password
+func (h *CaddyHandler) SetBasicAuthUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if request.ID == "" || request.Username == "" || request.Password == "" {
+		http.Error(w, "Proxy ID, username and password are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.manager.SetBasicAuthUser(request.ID, request.Username, request.Password); err != nil {
+		log.Printf("Error setting basic auth user: %v", err)
+		if errors.Is(err, caddy.ErrInvalidBasicAuthUser) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if caddy.IsConflict(err) {
+			http.Error(w, caddyConflictMessage, http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to set basic auth user", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]string{
+		"status":  "success",
+		"message": "Basic auth user saved successfully",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DeleteBasicAuthUser removes a basic auth user from a proxy
+func (h *CaddyHandler) DeleteBasicAuthUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if request.ID == "" || request.Username == "" {
+		http.Error(w, "Proxy ID and username are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.manager.DeleteBasicAuthUser(request.ID, request.Username); err != nil {
+		log.Printf("Error deleting basic auth user: %v", err)
+		if errors.Is(err, caddy.ErrBasicAuthUserNotFound) {
+			http.Error(w, "Basic auth user not found", http.StatusNotFound)
+			return
+		}
+		if caddy.IsConflict(err) {
+			http.Error(w, caddyConflictMessage, http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to delete basic auth user", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]string{
+		"status":  "success",
+		"message": "Basic auth user deleted successfully",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
 func (h *CaddyHandler) parseProxyFromRequest(r *http.Request) (config.CaddyProxy, error) {
@@ -320,6 +874,9 @@ func (h *CaddyHandler) parseProxyFromRequest(r *http.Request) (config.CaddyProxy
 	if err := json.NewDecoder(r.Body).Decode(&proxy); err != nil {
 		return config.CaddyProxy{}, fmt.Errorf("invalid request body")
 	}
+	if err := caddy.ValidateExtra(proxy.Extra); err != nil {
+		return config.CaddyProxy{}, err
+	}
 
 	return proxy, nil
 }
@@ -329,11 +886,38 @@ func (h *CaddyHandler) parseProxyFromMultipart(r *http.Request) (config.CaddyPro
 		return config.CaddyProxy{}, fmt.Errorf("failed to parse form data")
 	}
 
+	// Start from the stored proxy on update so fields the form doesn't carry
+	// (path rules, an existing cert, ...) survive the edit
 	proxy := config.CaddyProxy{}
-	proxy.ID = r.FormValue("id")
-	proxy.Hostname = r.FormValue("hostname")
-	proxy.Target = r.FormValue("target")
-	proxy.TLSCertFile = r.FormValue("tls_cert_file")
+	if id := r.FormValue("id"); id != "" {
+		if existing, err := h.manager.GetProxy(id); err == nil {
+			proxy = *existing
+		}
+		proxy.ID = id
+	}
+	pinnedCertFile := proxy.TLSCertFile
+
+	if value, ok := formValue(r, "hostname"); ok {
+		proxy.Hostname = value
+	}
+	if value, ok := formValue(r, "target"); ok {
+		proxy.Target = value
+	}
+	if value, ok := formValue(r, "tls_cert_file"); ok {
+		proxy.TLSCertFile = value
+	}
+	if value, ok := formValue(r, "tls_server_name"); ok {
+		proxy.TLSServerName = strings.TrimSpace(value)
+	}
+	if value, ok := formValue(r, "protocol"); ok {
+		proxy.Protocol = strings.TrimSpace(value)
+	}
+	if value, ok := formValue(r, "tls_handshake_timeout"); ok {
+		proxy.TLSHandshakeTimeout = strings.TrimSpace(value)
+	}
+	if value, ok := formValue(r, "frontend_tls_mode"); ok {
+		proxy.FrontendTLSMode = strings.TrimSpace(value)
+	}
 
 	if portStr := r.FormValue("port"); portStr != "" {
 		port, err := strconv.Atoi(portStr)
@@ -343,10 +927,110 @@ func (h *CaddyHandler) parseProxyFromMultipart(r *http.Request) (config.CaddyPro
 		proxy.Port = port
 	}
 
-	proxy.Enabled = parseBool(r.FormValue("enabled"))
-	proxy.TrustedProxies = parseBool(r.FormValue("trusted_proxies"))
-	proxy.TLS = parseBool(r.FormValue("tls"))
-	proxy.Autostart = parseBool(r.FormValue("autostart"))
+	if value, ok := formValue(r, "enabled"); ok {
+		proxy.Enabled = parseBool(value)
+	}
+	if value, ok := formValue(r, "trusted_proxies"); ok {
+		proxy.TrustedProxies = parseBool(value)
+	}
+	if value, ok := formValue(r, "tls"); ok {
+		proxy.TLS = parseBool(value)
+	}
+	if value, ok := formValue(r, "tls_insecure_skip_verify"); ok {
+		proxy.TLSInsecureSkipVerify = parseBool(value)
+	}
+	if value, ok := formValue(r, "autostart"); ok {
+		proxy.Autostart = parseBool(value)
+	}
+	if value, ok := formValue(r, "preserve_host"); ok {
+		proxy.PreserveHost = parseBool(value)
+	}
+	if value, ok := formValue(r, "header_rules"); ok {
+		proxy.HeaderRules = nil
+		proxy.CustomHeaders = nil
+		if strings.TrimSpace(value) != "" {
+			if err := json.Unmarshal([]byte(value), &proxy.HeaderRules); err != nil {
+				return config.CaddyProxy{}, fmt.Errorf("invalid header_rules: %v", err)
+			}
+		}
+	}
+
+	if value, ok := formValue(r, "upstreams"); ok {
+		proxy.Upstreams = splitList(value)
+	}
+	if value, ok := formValue(r, "allow_cidrs"); ok {
+		proxy.AllowCIDRs = splitList(value)
+	}
+	if value, ok := formValue(r, "deny_cidrs"); ok {
+		proxy.DenyCIDRs = splitList(value)
+	}
+	if value, ok := formValue(r, "kind"); ok {
+		proxy.Kind = strings.TrimSpace(value)
+	}
+	if value, ok := formValue(r, "redirect"); ok {
+		proxy.Redirect = nil
+		if strings.TrimSpace(value) != "" {
+			proxy.Redirect = &config.CaddyRedirect{}
+			if err := json.Unmarshal([]byte(value), proxy.Redirect); err != nil {
+				return config.CaddyProxy{}, fmt.Errorf("invalid redirect: %v", err)
+			}
+		}
+	}
+	if value, ok := formValue(r, "static_response"); ok {
+		proxy.StaticResponse = nil
+		if strings.TrimSpace(value) != "" {
+			proxy.StaticResponse = &config.CaddyStaticResponse{}
+			if err := json.Unmarshal([]byte(value), proxy.StaticResponse); err != nil {
+				return config.CaddyProxy{}, fmt.Errorf("invalid static_response: %v", err)
+			}
+		}
+	}
+	if value, ok := formValue(r, "file_server"); ok {
+		proxy.FileServer = nil
+		if strings.TrimSpace(value) != "" {
+			proxy.FileServer = &config.CaddyFileServer{}
+			if err := json.Unmarshal([]byte(value), proxy.FileServer); err != nil {
+				return config.CaddyProxy{}, fmt.Errorf("invalid file_server: %v", err)
+			}
+		}
+	}
+	if value, ok := formValue(r, "tailnet_identity"); ok {
+		proxy.TailnetIdentity = nil
+		if strings.TrimSpace(value) != "" {
+			proxy.TailnetIdentity = &config.CaddyIdentityPolicy{}
+			if err := json.Unmarshal([]byte(value), proxy.TailnetIdentity); err != nil {
+				return config.CaddyProxy{}, fmt.Errorf("invalid tailnet_identity: %v", err)
+			}
+		}
+	}
+	if value, ok := formValue(r, "health_checks"); ok {
+		proxy.HealthChecks = nil
+		if strings.TrimSpace(value) != "" {
+			proxy.HealthChecks = &config.CaddyHealthChecks{}
+			if err := json.Unmarshal([]byte(value), proxy.HealthChecks); err != nil {
+				return config.CaddyProxy{}, fmt.Errorf("invalid health_checks: %v", err)
+			}
+		}
+	}
+	if value, ok := formValue(r, "load_balancing"); ok {
+		proxy.LoadBalancing = nil
+		if strings.TrimSpace(value) != "" {
+			proxy.LoadBalancing = &config.CaddyLoadBalancing{}
+			if err := json.Unmarshal([]byte(value), proxy.LoadBalancing); err != nil {
+				return config.CaddyProxy{}, fmt.Errorf("invalid load_balancing: %v", err)
+			}
+		}
+	}
+
+	// Path rules are sent as a JSON array since they don't flatten into form fields
+	if value, ok := formValue(r, "path_rules"); ok {
+		proxy.PathRules = nil
+		if strings.TrimSpace(value) != "" {
+			if err := json.Unmarshal([]byte(value), &proxy.PathRules); err != nil {
+				return config.CaddyProxy{}, fmt.Errorf("invalid path_rules: %v", err)
+			}
+		}
+	}
 
 	// Handle remove TLS cert flag
 	if parseBool(r.FormValue("remove_tls_cert")) {
@@ -358,25 +1042,168 @@ func (h *CaddyHandler) parseProxyFromMultipart(r *http.Request) (config.CaddyPro
 		defer file.Close()
 
 		// Backend validation for cert file extension
-		fileName := strings.ToLower(fileHeader.Filename)
-		validExt := strings.HasSuffix(fileName, ".pem") ||
-			strings.HasSuffix(fileName, ".crt") ||
-			strings.HasSuffix(fileName, ".cer")
-		if !validExt {
+		if !hasCertExtension(fileHeader.Filename) {
 			return config.CaddyProxy{}, fmt.Errorf("invalid certificate file type: must be .pem, .crt, or .cer")
 		}
 
-		certPath, err := h.saveTLSCertFile(proxy.Target, file, fileHeader)
+		data, err := readCertUpload(file)
+		if err != nil {
+			return config.CaddyProxy{}, err
+		}
+		if _, err := certs.ParseCertificates(data); err != nil {
+			return config.CaddyProxy{}, fmt.Errorf("invalid certificate: %w", err)
+		}
+
+		certPath, err := h.saveTLSCertFile(proxy.Target, ".cert", 0644, data)
 		if err != nil {
 			return config.CaddyProxy{}, err
 		}
 		proxy.TLSCertFile = certPath
 	}
 
+	// A pin only describes the trust pool it was saved with
+	if value, ok := formValue(r, "tls_pinned_sha256"); ok {
+		proxy.TLSPinnedSHA256 = strings.TrimSpace(value)
+	} else if proxy.TLSCertFile != pinnedCertFile {
+		proxy.TLSPinnedSHA256 = ""
+	}
+
+	// Client certificate and key for upstream mTLS
+	if parseBool(r.FormValue("remove_tls_client_cert")) {
+		proxy.TLSClientCertFile = ""
+		proxy.TLSClientKeyFile = ""
+	}
+
+	clientCert, clientCertHeader, err := r.FormFile("tls_client_cert_upload")
+	if err == nil {
+		defer clientCert.Close()
+
+		if !hasCertExtension(clientCertHeader.Filename) {
+			return config.CaddyProxy{}, fmt.Errorf("invalid client certificate file type: must be .pem, .crt, or .cer")
+		}
+
+		data, err := readCertUpload(clientCert)
+		if err != nil {
+			return config.CaddyProxy{}, err
+		}
+		if _, err := certs.ParseCertificates(data); err != nil {
+			return config.CaddyProxy{}, fmt.Errorf("invalid client certificate: %w", err)
+		}
+
+		certPath, err := h.saveTLSCertFile(proxy.Target, ".client.crt", 0644, data)
+		if err != nil {
+			return config.CaddyProxy{}, err
+		}
+		proxy.TLSClientCertFile = certPath
+	}
+
+	clientKey, clientKeyHeader, err := r.FormFile("tls_client_key_upload")
+	if err == nil {
+		defer clientKey.Close()
+
+		fileName := strings.ToLower(clientKeyHeader.Filename)
+		if !strings.HasSuffix(fileName, ".pem") && !strings.HasSuffix(fileName, ".key") {
+			return config.CaddyProxy{}, fmt.Errorf("invalid client key file type: must be .pem or .key")
+		}
+
+		data, err := readCertUpload(clientKey)
+		if err != nil {
+			return config.CaddyProxy{}, err
+		}
+		if err := certs.ValidatePrivateKey(data); err != nil {
+			return config.CaddyProxy{}, fmt.Errorf("invalid client key: %w", err)
+		}
+
+		// Private keys are only readable by the owner
+		keyPath, err := h.saveTLSCertFile(proxy.Target, ".client.key", 0600, data)
+		if err != nil {
+			return config.CaddyProxy{}, err
+		}
+		proxy.TLSClientKeyFile = keyPath
+	}
+
+	// Certificate and key served to clients in the load_files frontend TLS mode
+	if parseBool(r.FormValue("remove_frontend_tls_cert")) {
+		proxy.FrontendTLSCertFile = ""
+		proxy.FrontendTLSKeyFile = ""
+	}
+
+	frontendCert, frontendCertHeader, err := r.FormFile("frontend_tls_cert_upload")
+	if err == nil {
+		defer frontendCert.Close()
+
+		if !hasCertExtension(frontendCertHeader.Filename) {
+			return config.CaddyProxy{}, fmt.Errorf("invalid frontend certificate file type: must be .pem, .crt, or .cer")
+		}
+
+		data, err := readCertUpload(frontendCert)
+		if err != nil {
+			return config.CaddyProxy{}, err
+		}
+		if _, err := certs.ParseCertificates(data); err != nil {
+			return config.CaddyProxy{}, fmt.Errorf("invalid frontend certificate: %w", err)
+		}
+
+		certPath, err := h.saveFrontendCertFile(proxy.Hostname, ".crt", 0644, data)
+		if err != nil {
+			return config.CaddyProxy{}, err
+		}
+		proxy.FrontendTLSCertFile = certPath
+	}
+
+	frontendKey, frontendKeyHeader, err := r.FormFile("frontend_tls_key_upload")
+	if err == nil {
+		defer frontendKey.Close()
+
+		fileName := strings.ToLower(frontendKeyHeader.Filename)
+		if !strings.HasSuffix(fileName, ".pem") && !strings.HasSuffix(fileName, ".key") {
+			return config.CaddyProxy{}, fmt.Errorf("invalid frontend key file type: must be .pem or .key")
+		}
+
+		data, err := readCertUpload(frontendKey)
+		if err != nil {
+			return config.CaddyProxy{}, err
+		}
+		if err := certs.ValidatePrivateKey(data); err != nil {
+			return config.CaddyProxy{}, fmt.Errorf("invalid frontend key: %w", err)
+		}
+
+		keyPath, err := h.saveFrontendCertFile(proxy.Hostname, ".key", 0600, data)
+		if err != nil {
+			return config.CaddyProxy{}, err
+		}
+		proxy.FrontendTLSKeyFile = keyPath
+	}
+
 	return proxy, nil
 }
 
-func (h *CaddyHandler) saveTLSCertFile(target string, file multipart.File, header *multipart.FileHeader) (string, error) {
+// hasCertExtension reports whether an uploaded file name looks like a certificate
+func hasCertExtension(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasSuffix(name, ".pem") ||
+		strings.HasSuffix(name, ".crt") ||
+		strings.HasSuffix(name, ".cer")
+}
+
+// maxCertUploadSize bounds uploaded certificates and keys
+const maxCertUploadSize = 1 << 20
+
+// readCertUpload reads an uploaded certificate or key
+func readCertUpload(file multipart.File) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(file, maxCertUploadSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read upload: %w", err)
+	}
+	if len(data) > maxCertUploadSize {
+		return nil, fmt.Errorf("file too large: maximum size is 1MB")
+	}
+	return data, nil
+}
+
+// saveTLSCertFile stores an uploaded certificate or key in the certificates
+// dir, named after the target with the given extension and permissions
+func (h *CaddyHandler) saveTLSCertFile(target, ext string, perm os.FileMode, data []byte) (string, error) {
 	if target == "" {
 		return "", fmt.Errorf("target is required for cert upload")
 	}
@@ -400,36 +1227,50 @@ func (h *CaddyHandler) saveTLSCertFile(target string, file multipart.File, heade
 		return "", fmt.Errorf("invalid target host")
 	}
 
-	nameBase := sanitizeName(host)
-	fileName := fmt.Sprintf("%s-%s.cert", nameBase, port)
-
-	certDir := h.cfg.Paths.CertificatesDir
-	if certDir == "" {
-		certDir = "/data"
+	fullPath, err := h.newCertFilePath(host, port, ext)
+	if err != nil {
+		return "", err
 	}
 
-	if err := os.MkdirAll(certDir, 0755); err != nil {
-		return "", fmt.Errorf("create cert dir: %w", err)
+	if err := os.WriteFile(fullPath, data, perm); err != nil {
+		return "", fmt.Errorf("write cert file: %w", err)
 	}
 
-	fullPath := filepath.Join(certDir, fileName)
-	fullPath = ensureUniqueFile(fullPath)
+	return fullPath, nil
+}
+
+// saveFrontendCertFile stores an uploaded certificate or key a proxy serves
+// to its clients, named after the proxy's hostname
+func (h *CaddyHandler) saveFrontendCertFile(hostname, ext string, perm os.FileMode, data []byte) (string, error) {
+	hostname = caddy.NormalizeHostname(hostname)
+	if hostname == "" {
+		return "", fmt.Errorf("hostname is required for cert upload")
+	}
 
-	out, err := os.Create(fullPath)
+	fullPath, err := h.newCertFilePath(hostname, "frontend", ext)
 	if err != nil {
-		return "", fmt.Errorf("create cert file: %w", err)
+		return "", err
 	}
-	defer out.Close()
 
-	if _, err := io.Copy(out, file); err != nil {
+	if err := os.WriteFile(fullPath, data, perm); err != nil {
 		return "", fmt.Errorf("write cert file: %w", err)
 	}
 
-	if header != nil && header.Filename != "" {
-		_ = header
+	return fullPath, nil
+}
+
+// newCertFilePath returns an unused path in the certificates dir for a file
+// named after an upstream's host and port
+func (h *CaddyHandler) newCertFilePath(host, port, ext string) (string, error) {
+	nameBase := sanitizeName(host)
+	fileName := fmt.Sprintf("%s-%s%s", nameBase, port, ext)
+
+	certDir := certificatesDir(h.cfg)
+	if err := os.MkdirAll(certDir, 0755); err != nil {
+		return "", fmt.Errorf("create cert dir: %w", err)
 	}
 
-	return fullPath, nil
+	return ensureUniqueFile(filepath.Join(certDir, fileName)), nil
 }
 
 func sanitizeName(input string) string {
@@ -455,6 +1296,33 @@ func ensureUniqueFile(path string) string {
 	}
 }
 
+// formValue returns a multipart form value and whether the field was sent at all
+func formValue(r *http.Request, key string) (string, bool) {
+	if r.MultipartForm == nil {
+		return "", false
+	}
+	values, ok := r.MultipartForm.Value[key]
+	if !ok || len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+// splitList splits a comma or newline separated form value, dropping blanks
+func splitList(value string) []string {
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == '\n'
+	})
+
+	var items []string
+	for _, field := range fields {
+		if field = strings.TrimSpace(field); field != "" {
+			items = append(items, field)
+		}
+	}
+	return items
+}
+
 func parseBool(value string) bool {
 	value = strings.ToLower(strings.TrimSpace(value))
 	return value == "true" || value == "1" || value == "on" || value == "yes"
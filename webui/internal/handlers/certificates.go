@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/caddy"
+	"github.com/sudocarlos/tailrelay-webui/internal/certs"
+	"github.com/sudocarlos/tailrelay-webui/internal/config"
+	"github.com/sudocarlos/tailrelay-webui/internal/tailscale"
+)
+
+// certExpiryCheckInterval is how often stored certificates are checked for
+// upcoming expiry
+const certExpiryCheckInterval = 24 * time.Hour
+
+// CertificateHandler manages the files in the certificates dir
+type CertificateHandler struct {
+	caddyMgr *caddy.Manager
+	tsCerts  *tailscale.CertManager
+	store    *certs.Store
+}
+
+// NewCertificateHandler creates a new certificate handler
+func NewCertificateHandler(cfg *config.Config, caddyMgr *caddy.Manager, tsCerts *tailscale.CertManager) *CertificateHandler {
+	return &CertificateHandler{
+		caddyMgr: caddyMgr,
+		tsCerts:  tsCerts,
+		store:    certs.NewStore(certificatesDir(cfg)),
+	}
+}
+
+// certificatesDir is where uploaded and pinned certificates are stored
+func certificatesDir(cfg *config.Config) string {
+	if cfg.Paths.CertificatesDir == "" {
+		return "/data"
+	}
+	return cfg.Paths.CertificatesDir
+}
+
+// references lists which proxy settings use which stored files, along with
+// the Tailscale HTTPS certificates kept there
+func (h *CertificateHandler) references() (certs.References, error) {
+	proxies, err := h.caddyMgr.ListProxies()
+	if err != nil {
+		return nil, fmt.Errorf("load proxies: %w", err)
+	}
+	refs := make(certs.References)
+	for _, proxy := range proxies {
+		ref := certs.Reference{ProxyID: proxy.ID, Hostname: proxy.Hostname}
+		for field, path := range map[string]string{
+			"tls_cert_file":        proxy.TLSCertFile,
+			"tls_client_cert_file": proxy.TLSClientCertFile,
+			"tls_client_key_file":  proxy.TLSClientKeyFile,
+
+			"frontend_tls_cert_file": proxy.FrontendTLSCertFile,
+			"frontend_tls_key_file":  proxy.FrontendTLSKeyFile,
+		} {
+			ref.Field = field
+			refs.Add(path, ref)
+		}
+	}
+
+	statuses, _ := h.tsCerts.Statuses()
+	for _, status := range statuses {
+		ref := certs.Reference{Hostname: status.Domain, Field: "tailscale_https"}
+		refs.Add(status.CertFile, ref)
+		refs.Add(status.KeyFile, ref)
+	}
+	return refs, nil
+}
+
+// APIList lists the stored certificates and keys with their expiry and the
+// proxies that use them
+func (h *CertificateHandler) APIList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	refs, err := h.references()
+	if err != nil {
+		log.Printf("Error loading certificate references: %v", err)
+		http.Error(w, "Failed to load proxies", http.StatusInternalServerError)
+		return
+	}
+	entries, err := h.store.List(refs)
+	if err != nil {
+		log.Printf("Error listing certificates: %v", err)
+		http.Error(w, "Failed to list certificates", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// Delete removes a stored file no proxy references
+func (h *CertificateHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		http.Error(w, "Certificate name is required", http.StatusBadRequest)
+		return
+	}
+
+	refs, err := h.references()
+	if err != nil {
+		log.Printf("Error loading certificate references: %v", err)
+		http.Error(w, "Failed to load proxies", http.StatusInternalServerError)
+		return
+	}
+	if err := h.store.Delete(name, refs); err != nil {
+		log.Printf("Error deleting certificate %s: %v", name, err)
+		switch {
+		case errors.Is(err, certs.ErrNotFound):
+			http.Error(w, "Certificate not found", http.StatusNotFound)
+		case errors.Is(err, certs.ErrInUse):
+			http.Error(w, "Certificate is used by a proxy; remove it from the proxy first", http.StatusConflict)
+		default:
+			http.Error(w, "Failed to delete certificate", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Printf("Deleted certificate %s", name)
+	response := map[string]string{
+		"status":  "success",
+		"message": "Certificate deleted successfully",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// InternalCA downloads the root certificate of Caddy's internal CA, for
+// clients of proxies in the internal frontend TLS mode to trust
+func (h *CertificateHandler) InternalCA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	root, err := h.caddyMgr.InternalCARoot()
+	if err != nil {
+		log.Printf("Error getting internal CA root: %v", err)
+		http.Error(w, "Caddy's internal CA is not available; it starts once a proxy uses the internal TLS mode", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Header().Set("Content-Disposition", `attachment; filename="tailrelay-internal-ca.crt"`)
+	w.Write([]byte(root))
+}
+
+// StartExpiryCheck logs a warning for stored certificates that are expired
+// or expire within certs.ExpiryWarning, now and then daily
+func (h *CertificateHandler) StartExpiryCheck() {
+	go func() {
+		for {
+			h.checkExpiry()
+			time.Sleep(certExpiryCheckInterval)
+		}
+	}()
+}
+
+func (h *CertificateHandler) checkExpiry() {
+	refs, err := h.references()
+	if err != nil {
+		log.Printf("Warning: certificate expiry check failed: %v", err)
+		return
+	}
+	entries, err := h.store.List(refs)
+	if err != nil {
+		log.Printf("Warning: certificate expiry check failed: %v", err)
+		return
+	}
+
+	for _, entry := range entries {
+		switch entry.Status {
+		case certs.StatusExpired:
+			log.Printf("Warning: certificate %s expired on %s (used by %d proxy setting(s))",
+				entry.Name, entry.NotAfter.Format("2006-01-02"), len(entry.ReferencedBy))
+		case certs.StatusExpiring:
+			log.Printf("Warning: certificate %s expires on %s (used by %d proxy setting(s))",
+				entry.Name, entry.NotAfter.Format("2006-01-02"), len(entry.ReferencedBy))
+		}
+	}
+}
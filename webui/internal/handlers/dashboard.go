@@ -20,12 +20,13 @@ type DashboardHandler struct {
 	tsClient  *tailscale.Client
 }
 
-// NewDashboardHandler creates a new dashboard handler
-func NewDashboardHandler(cfg *config.Config, templates *template.Template) *DashboardHandler {
+// NewDashboardHandler creates a new dashboard handler that reports on the
+// given Caddy manager
+func NewDashboardHandler(cfg *config.Config, templates *template.Template, caddyMgr *caddy.Manager) *DashboardHandler {
 	return &DashboardHandler{
 		cfg:       cfg,
 		templates: templates,
-		caddyMgr:  caddy.NewManager(caddy.DefaultAdminAPI, cfg.Paths.CaddyServerMap),
+		caddyMgr:  caddyMgr,
 		tsClient:  tailscale.NewClient(),
 	}
 }
@@ -110,6 +111,7 @@ func (h *DashboardHandler) APIStatus(w http.ResponseWriter, r *http.Request) {
 			},
 			"caddy": map[string]interface{}{
 				"proxies": proxyCount,
+				"api":     h.caddyMgr.APIBreaker(),
 			},
 			"socat": map[string]interface{}{
 				"relays": relayCount,
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/caddy"
+	"github.com/sudocarlos/tailrelay-webui/internal/config"
+	"github.com/sudocarlos/tailrelay-webui/internal/diagnostics"
+	"github.com/sudocarlos/tailrelay-webui/internal/socat"
+)
+
+// maxProbeTimeout caps the timeout a probe request may ask for
+const maxProbeTimeout = time.Minute
+
+// DiagnosticsHandler probes upstreams from inside the container
+type DiagnosticsHandler struct {
+	cfg      *config.Config
+	caddyMgr *caddy.Manager
+}
+
+// NewDiagnosticsHandler creates a new diagnostics handler
+func NewDiagnosticsHandler(cfg *config.Config, caddyMgr *caddy.Manager) *DiagnosticsHandler {
+	return &DiagnosticsHandler{
+		cfg:      cfg,
+		caddyMgr: caddyMgr,
+	}
+}
+
+// probeRequest selects what to probe: a proxy's upstreams, a relay's target
+// or an ad hoc target
+type probeRequest struct {
+	ProxyID string          `json:"proxy_id,omitempty"`
+	RelayID string          `json:"relay_id,omitempty"`
+	Target  json.RawMessage `json:"target,omitempty"` // An adHocProbeTarget
+
+	TLS     bool   `json:"tls,omitempty"`     // Relays only: handshake with the target
+	HTTP    bool   `json:"http,omitempty"`    // Relays only: send an HTTP request
+	Path    string `json:"path,omitempty"`    // Request path for the HTTP step
+	Timeout string `json:"timeout,omitempty"` // Per target, e.g. "10s"
+}
+
+// adHocProbeTarget is the part of a diagnostics.Target a caller may set
+// directly. File paths only come from stored proxies, so the probe can't be
+// used to find out which files exist in the container.
+type adHocProbeTarget struct {
+	Address            string `json:"address"`
+	TLS                bool   `json:"tls"`
+	ServerName         string `json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+	HTTP               bool   `json:"http"`
+	Path               string `json:"path,omitempty"`
+	Host               string `json:"host,omitempty"`
+}
+
+// decodeAdHocTarget reads an ad hoc target, rejecting any other field
+func decodeAdHocTarget(raw json.RawMessage) (diagnostics.Target, error) {
+	var target adHocProbeTarget
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&target); err != nil {
+		return diagnostics.Target{}, err
+	}
+	return diagnostics.Target{
+		Address:            target.Address,
+		TLS:                target.TLS,
+		ServerName:         target.ServerName,
+		InsecureSkipVerify: target.InsecureSkipVerify,
+		HTTP:               target.HTTP,
+		Path:               target.Path,
+		Host:               target.Host,
+	}, nil
+}
+
+// Probe runs DNS, TCP, TLS and HTTP checks against the upstreams of a proxy
+// or relay and returns a timing report per upstream
+func (h *DiagnosticsHandler) Probe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request probeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	timeout := diagnostics.DefaultTimeout
+	if request.Timeout != "" {
+		parsed, err := time.ParseDuration(request.Timeout)
+		if err != nil || parsed <= 0 || parsed > maxProbeTimeout {
+			http.Error(w, fmt.Sprintf("Invalid timeout; use a duration up to %s", maxProbeTimeout), http.StatusBadRequest)
+			return
+		}
+		timeout = parsed
+	}
+
+	var targets []diagnostics.Target
+	switch {
+	case request.ProxyID != "":
+		proxy, err := h.caddyMgr.GetProxy(request.ProxyID)
+		if err != nil {
+			http.Error(w, "Proxy not found", http.StatusNotFound)
+			return
+		}
+		targets = proxyProbeTargets(*proxy, request.Path)
+		if len(targets) == 0 {
+			http.Error(w, "Proxy has no upstream to test", http.StatusBadRequest)
+			return
+		}
+	case request.RelayID != "":
+		relay, err := socat.GetRelay(h.cfg.Paths.SocatRelayConfig, request.RelayID)
+		if err != nil {
+			http.Error(w, "Relay not found", http.StatusNotFound)
+			return
+		}
+		targets = []diagnostics.Target{{
+			Address: net.JoinHostPort(relay.TargetHost, strconv.Itoa(relay.TargetPort)),
+			TLS:     request.TLS,
+			HTTP:    request.HTTP,
+			Path:    request.Path,
+		}}
+	case len(request.Target) > 0 && string(request.Target) != "null":
+		target, err := decodeAdHocTarget(request.Target)
+		if err != nil {
+			http.Error(w, "Invalid target; only address, tls, server_name, insecure_skip_verify, http, path and host may be set", http.StatusBadRequest)
+			return
+		}
+		targets = []diagnostics.Target{target}
+	default:
+		http.Error(w, "A proxy_id, relay_id or target is required", http.StatusBadRequest)
+		return
+	}
+
+	reports := make([]*diagnostics.Report, 0, len(targets))
+	ok := true
+	for _, target := range targets {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		report := diagnostics.Probe(ctx, target)
+		cancel()
+		if !report.OK {
+			ok = false
+			log.Printf("Probe of %s failed: %s", target.Address, failedStep(report))
+		}
+		reports = append(reports, report)
+	}
+
+	response := map[string]interface{}{
+		"status":  "success",
+		"ok":      ok,
+		"reports": reports,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// proxyProbeTargets builds a probe for each distinct upstream of a proxy,
+// with the TLS settings Caddy uses to reach it
+func proxyProbeTargets(proxy config.CaddyProxy, path string) []diagnostics.Target {
+	// An HTTP/1.1 request tells nothing about h2c and gRPC upstreams
+	sendHTTP := proxy.Protocol != config.ProxyProtocolH2C && proxy.Protocol != config.ProxyProtocolGRPC
+
+	var targets []diagnostics.Target
+	seen := make(map[string]bool)
+	for _, address := range caddy.ProxyTargets(proxy) {
+		if seen[address] {
+			continue
+		}
+		seen[address] = true
+		targets = append(targets, diagnostics.Target{
+			Address:            address,
+			TLS:                caddy.UpstreamTLSEnabled(proxy),
+			ServerName:         proxy.TLSServerName,
+			CAFile:             proxy.TLSCertFile,
+			ClientCertFile:     proxy.TLSClientCertFile,
+			ClientKeyFile:      proxy.TLSClientKeyFile,
+			InsecureSkipVerify: proxy.TLSInsecureSkipVerify,
+			HTTP:               sendHTTP,
+			Path:               path,
+		})
+	}
+	return targets
+}
+
+// failedStep describes the step a probe stopped at
+func failedStep(report *diagnostics.Report) string {
+	for _, step := range report.Steps {
+		if !step.OK && !step.Skipped {
+			return fmt.Sprintf("%s: %s", step.Name, step.Error)
+		}
+	}
+	return "unknown step"
+}
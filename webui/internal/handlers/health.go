@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/caddy"
+	"github.com/sudocarlos/tailrelay-webui/internal/config"
+	"github.com/sudocarlos/tailrelay-webui/internal/diagnostics"
+)
+
+// healthTick is how often the health monitor looks for active checks that are due
+const healthTick = 5 * time.Second
+
+// Caddy's defaults for active health checks
+const (
+	defaultHealthInterval = 30 * time.Second
+	defaultHealthTimeout  = 5 * time.Second
+)
+
+// activeHealth is the latest active check of one proxy upstream
+type activeHealth struct {
+	Healthy   bool
+	Error     string
+	CheckedAt time.Time
+}
+
+// healthMonitor keeps the latest active check per proxy upstream. Caddy runs
+// the same checks but doesn't report their results over its admin API.
+type healthMonitor struct {
+	mu      sync.Mutex
+	results map[string]activeHealth // By healthKey
+}
+
+func healthKey(proxyID, address string) string {
+	return proxyID + " " + address
+}
+
+func (m *healthMonitor) get(proxyID, address string) (activeHealth, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result, ok := m.results[healthKey(proxyID, address)]
+	return result, ok
+}
+
+// applyActiveHealth adds active check results to a proxy's upstream health.
+// An upstream is only healthy when it passes both passive and active checks.
+func (h *CaddyHandler) applyActiveHealth(proxyID string, upstreams []caddy.UpstreamHealth) {
+	for i := range upstreams {
+		result, ok := h.health.get(proxyID, upstreams[i].Address)
+		if !ok {
+			continue
+		}
+		healthy := result.Healthy
+		upstreams[i].ActiveHealthy = &healthy
+		upstreams[i].ActiveError = result.Error
+		upstreams[i].Healthy = upstreams[i].Healthy && healthy
+	}
+}
+
+// StartHealthMonitor runs each proxy's active health check in the background
+// at the proxy's interval, logging a warning when an upstream starts failing
+func (h *CaddyHandler) StartHealthMonitor() {
+	go func() {
+		for {
+			if err := h.checkHealth(context.Background()); err != nil {
+				log.Printf("Warning: upstream health check failed: %v", err)
+			}
+			time.Sleep(healthTick)
+		}
+	}()
+}
+
+// checkHealth runs the active checks that are due and drops results of
+// proxies and upstreams that no longer have one
+func (h *CaddyHandler) checkHealth(ctx context.Context) error {
+	proxies, err := h.manager.ListProxies()
+	if err != nil {
+		return fmt.Errorf("load proxies: %w", err)
+	}
+
+	h.health.mu.Lock()
+	previous := h.health.results
+	h.health.mu.Unlock()
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]activeHealth)
+	)
+	for _, proxy := range proxies {
+		if !proxy.Enabled || proxy.HealthChecks == nil || proxy.HealthChecks.URI == "" {
+			continue
+		}
+		hc := *proxy.HealthChecks
+		interval := parseDurationOr(hc.Interval, defaultHealthInterval)
+		timeout := parseDurationOr(hc.Timeout, defaultHealthTimeout)
+
+		for _, target := range proxyProbeTargets(proxy, hc.URI) {
+			key := healthKey(proxy.ID, target.Address)
+			if last, ok := previous[key]; ok && time.Since(last.CheckedAt) < interval {
+				results[key] = last
+				continue
+			}
+
+			wg.Add(1)
+			go func(proxy config.CaddyProxy, target diagnostics.Target) {
+				defer wg.Done()
+
+				checkCtx, cancel := context.WithTimeout(ctx, timeout)
+				err := activeCheckError(diagnostics.Probe(checkCtx, target), hc)
+				cancel()
+
+				result := activeHealth{Healthy: err == nil, CheckedAt: time.Now()}
+				if err != nil {
+					result.Error = err.Error()
+				}
+
+				// Warn once when an upstream starts failing rather than on every check
+				last, checked := previous[key]
+				if err != nil && (!checked || last.Healthy) {
+					log.Printf("Warning: upstream %s of proxy %s (%s) failed its health check: %v",
+						target.Address, proxy.ID, proxy.Hostname, err)
+				}
+
+				mu.Lock()
+				results[key] = result
+				mu.Unlock()
+			}(proxy, target)
+		}
+	}
+	wg.Wait()
+
+	h.health.mu.Lock()
+	h.health.results = results
+	h.health.mu.Unlock()
+	return nil
+}
+
+// activeCheckError applies Caddy's active health check rules to a probe: the
+// upstream must answer with a 2xx status, or expect_status when set, and a
+// body matching expect_body. h2c and gRPC upstreams are only checked for
+// reachability, as the probe sends them no HTTP request.
+func activeCheckError(report *diagnostics.Report, hc config.CaddyHealthChecks) error {
+	if !report.OK {
+		return fmt.Errorf("%s", failedStep(report))
+	}
+	if report.HTTPStatus == 0 {
+		return nil
+	}
+
+	if hc.ExpectStatus > 0 {
+		if !statusMatches(report.HTTPStatus, hc.ExpectStatus) {
+			return fmt.Errorf("status %d, expected %d", report.HTTPStatus, hc.ExpectStatus)
+		}
+	} else if report.HTTPStatus < 200 || report.HTTPStatus >= 300 {
+		return fmt.Errorf("status %d, expected 2xx", report.HTTPStatus)
+	}
+
+	if hc.ExpectBody != "" {
+		pattern, err := regexp.Compile(hc.ExpectBody)
+		if err != nil {
+			return fmt.Errorf("invalid expect_body: %v", err)
+		}
+		if !pattern.Match(report.HTTPBody) {
+			return fmt.Errorf("body doesn't match %q", hc.ExpectBody)
+		}
+	}
+	return nil
+}
+
+// statusMatches matches a status like Caddy does: expected is either a full
+// code or a single digit standing for its class, e.g. 2 for any 2xx
+func statusMatches(actual, expected int) bool {
+	if expected < 100 {
+		return actual >= expected*100 && actual < (expected+1)*100
+	}
+	return actual == expected
+}
+
+// parseDurationOr parses a duration, falling back when it is empty or invalid
+func parseDurationOr(value string, fallback time.Duration) time.Duration {
+	if parsed, err := time.ParseDuration(value); err == nil && parsed > 0 {
+		return parsed
+	}
+	return fallback
+}
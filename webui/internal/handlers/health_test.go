@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/config"
+	"github.com/sudocarlos/tailrelay-webui/internal/diagnostics"
+)
+
+func TestActiveCheckError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			w.Write([]byte(`{"status":"ok"}`))
+		case "/teapot":
+			w.WriteHeader(http.StatusTeapot)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	tests := []struct {
+		name    string
+		hc      config.CaddyHealthChecks
+		healthy bool
+	}{
+		{"2xx by default", config.CaddyHealthChecks{URI: "/healthz"}, true},
+		{"not found", config.CaddyHealthChecks{URI: "/missing"}, false},
+		{"expected status", config.CaddyHealthChecks{URI: "/teapot", ExpectStatus: 418}, true},
+		{"expected status class", config.CaddyHealthChecks{URI: "/teapot", ExpectStatus: 4}, true},
+		{"other status", config.CaddyHealthChecks{URI: "/healthz", ExpectStatus: 204}, false},
+		{"matching body", config.CaddyHealthChecks{URI: "/healthz", ExpectBody: `"status":\s*"ok"`}, true},
+		{"other body", config.CaddyHealthChecks{URI: "/healthz", ExpectBody: "degraded"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := diagnostics.Probe(context.Background(), diagnostics.Target{Address: address, HTTP: true, Path: tt.hc.URI})
+			err := activeCheckError(report, tt.hc)
+			if (err == nil) != tt.healthy {
+				t.Errorf("err = %v, want healthy %v", err, tt.healthy)
+			}
+		})
+	}
+}
+
+func TestActiveCheckUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	address := strings.TrimPrefix(server.URL, "http://")
+	server.Close()
+
+	report := diagnostics.Probe(context.Background(), diagnostics.Target{Address: address, HTTP: true, Path: "/healthz"})
+	if err := activeCheckError(report, config.CaddyHealthChecks{URI: "/healthz"}); err == nil || !strings.HasPrefix(err.Error(), "tcp:") {
+		t.Errorf("err = %v, want the failed tcp step", err)
+	}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/caddy"
+	"github.com/sudocarlos/tailrelay-webui/internal/config"
+	"github.com/sudocarlos/tailrelay-webui/internal/tailscale"
+)
+
+// IdentityHandler answers Caddy's tailnet identity checks
+type IdentityHandler struct {
+	localAPI *tailscale.LocalAPIClient
+	secret   string // Caddy sends it in caddy.IdentitySecretHeader
+}
+
+// NewIdentityHandler creates a new identity handler that only answers
+// requests carrying secret
+func NewIdentityHandler(secret string) *IdentityHandler {
+	return &IdentityHandler{
+		localAPI: tailscale.NewLocalAPIClient(tailscale.DefaultLocalAPISocket),
+		secret:   secret,
+	}
+}
+
+// Verify resolves the caller through Tailscale whois and checks it against
+// the policy encoded in the query string. Only Caddy, connecting from the
+// same host with the identity secret, may call it; the result is 200 with
+// identity headers or 403.
+func (h *IdentityHandler) Verify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !isLocalRequest(r) || !h.hasSecret(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	remote := r.Header.Get(caddy.IdentityRemoteAddrHeader)
+	if remote == "" {
+		http.Error(w, "Missing remote address", http.StatusBadRequest)
+		return
+	}
+
+	who, err := h.localAPI.WhoIs(r.Context(), remote)
+	if err != nil {
+		log.Printf("Identity lookup failed for %s: %v", remote, err)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	policy := caddy.ParseIdentityPolicy(r.URL.Query())
+	if !identityAllowed(policy, who) {
+		log.Printf("Identity %s (%s) denied by proxy policy", who.UserProfile.LoginName, who.Node.Name)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Tagged nodes have no user behind them, so only report the login when present
+	if who.UserProfile.LoginName != "" {
+		w.Header().Set(caddy.IdentityUserLoginHeader, who.UserProfile.LoginName)
+	}
+	if who.UserProfile.DisplayName != "" {
+		w.Header().Set(caddy.IdentityUserNameHeader, who.UserProfile.DisplayName)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// hasSecret reports whether the request carries the identity secret
+func (h *IdentityHandler) hasSecret(r *http.Request) bool {
+	got := r.Header.Get(caddy.IdentitySecretHeader)
+	return h.secret != "" && subtle.ConstantTimeCompare([]byte(got), []byte(h.secret)) == 1
+}
+
+// identityAllowed reports whether a whois result matches any allow list.
+// An enabled policy with no lists admits any tailnet identity.
+func identityAllowed(policy config.CaddyIdentityPolicy, who *tailscale.WhoIsResponse) bool {
+	if len(policy.AllowUsers) == 0 && len(policy.AllowNodes) == 0 && len(policy.AllowTags) == 0 {
+		return true
+	}
+
+	for _, user := range policy.AllowUsers {
+		if who.UserProfile.LoginName != "" && strings.EqualFold(user, who.UserProfile.LoginName) {
+			return true
+		}
+	}
+
+	fqdn := strings.TrimSuffix(who.Node.Name, ".")
+	shortName, _, _ := strings.Cut(fqdn, ".")
+	for _, node := range policy.AllowNodes {
+		node = strings.TrimSuffix(node, ".")
+		if strings.EqualFold(node, fqdn) || strings.EqualFold(node, shortName) || strings.EqualFold(node, who.Node.ComputedName) {
+			return true
+		}
+	}
+
+	for _, tag := range policy.AllowTags {
+		if !strings.HasPrefix(tag, "tag:") {
+			tag = "tag:" + tag
+		}
+		for _, nodeTag := range who.Node.Tags {
+			if tag == nodeTag {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isLocalRequest reports whether the request came from this host: over
+// loopback, or from the address it arrived on when the web UI listens on a
+// specific one
+func isLocalRequest(r *http.Request) bool {
+	ip := addrIP(r.RemoteAddr)
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+	local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && ip.Equal(addrIP(local.String()))
+}
+
+// addrIP parses the IP of a host:port address
+func addrIP(addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/caddy"
+	"github.com/sudocarlos/tailrelay-webui/internal/config"
+	"github.com/sudocarlos/tailrelay-webui/internal/tailscale"
+)
+
+const testIdentitySecret = "test-identity-secret"
+
+// fakeWhoIs serves tailscaled's whois endpoint on a unix socket, answering
+// every lookup with who. It returns the socket path and a lookup counter.
+func fakeWhoIs(t *testing.T, who tailscale.WhoIsResponse) (string, *int32) {
+	t.Helper()
+
+	// Unix socket paths are short, so avoid the long t.TempDir path
+	dir, err := os.MkdirTemp("", "ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "tailscaled.sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lookups int32
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/localapi/v0/whois" {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(&lookups, 1)
+		json.NewEncoder(w).Encode(who)
+	})}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	return socket, &lookups
+}
+
+func newTestIdentityHandler(socket string) *IdentityHandler {
+	return &IdentityHandler{
+		localAPI: tailscale.NewLocalAPIClient(socket),
+		secret:   testIdentitySecret,
+	}
+}
+
+// verifyRequest builds a verify request as Caddy sends it
+func verifyRequest(query string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, caddy.IdentityVerifyPath+query, nil)
+	r.RemoteAddr = "127.0.0.1:50000"
+	r.Header.Set(caddy.IdentityRemoteAddrHeader, "127.0.0.1:40000")
+	r.Header.Set(caddy.IdentitySecretHeader, testIdentitySecret)
+	return r
+}
+
+func TestVerifyPolicy(t *testing.T) {
+	var who tailscale.WhoIsResponse
+	who.UserProfile.LoginName = "alice@example.com"
+	who.UserProfile.DisplayName = "Alice"
+	who.Node.Name = "laptop.tail1234.ts.net."
+	who.Node.ComputedName = "laptop"
+	who.Node.Tags = []string{"tag:admin"}
+
+	socket, _ := fakeWhoIs(t, who)
+	h := newTestIdentityHandler(socket)
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+	}{
+		{"any identity", "", http.StatusOK},
+		{"allowed login", "?users=bob@example.com,Alice@Example.com", http.StatusOK},
+		{"denied login", "?users=bob@example.com", http.StatusForbidden},
+		{"allowed short node", "?nodes=laptop", http.StatusOK},
+		{"allowed node fqdn", "?nodes=laptop.tail1234.ts.net", http.StatusOK},
+		{"denied node", "?nodes=desktop", http.StatusForbidden},
+		{"allowed tag", "?tags=tag:admin", http.StatusOK},
+		{"allowed tag without prefix", "?tags=admin", http.StatusOK},
+		{"denied tag", "?tags=tag:server", http.StatusForbidden},
+		{"any list matches", "?users=bob@example.com&tags=admin", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.Verify(w, verifyRequest(tt.query))
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if got := w.Header().Get(caddy.IdentityUserLoginHeader); got != "alice@example.com" {
+				t.Errorf("%s = %q", caddy.IdentityUserLoginHeader, got)
+			}
+			if got := w.Header().Get(caddy.IdentityUserNameHeader); got != "Alice" {
+				t.Errorf("%s = %q", caddy.IdentityUserNameHeader, got)
+			}
+		})
+	}
+}
+
+func TestVerifyTaggedNodeHasNoLogin(t *testing.T) {
+	var who tailscale.WhoIsResponse
+	who.Node.Name = "server.tail1234.ts.net."
+	who.Node.Tags = []string{"tag:server"}
+
+	socket, _ := fakeWhoIs(t, who)
+	h := newTestIdentityHandler(socket)
+
+	w := httptest.NewRecorder()
+	h.Verify(w, verifyRequest("?users=alice@example.com"))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("user policy: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	w = httptest.NewRecorder()
+	h.Verify(w, verifyRequest("?tags=server"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("tag policy: status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get(caddy.IdentityUserLoginHeader); got != "" {
+		t.Errorf("%s = %q, want none", caddy.IdentityUserLoginHeader, got)
+	}
+}
+
+func TestVerifyRejectsCallersOtherThanCaddy(t *testing.T) {
+	var who tailscale.WhoIsResponse
+	who.UserProfile.LoginName = "alice@example.com"
+
+	socket, lookups := fakeWhoIs(t, who)
+	h := newTestIdentityHandler(socket)
+
+	tests := []struct {
+		name  string
+		alter func(r *http.Request)
+	}{
+		{"missing secret", func(r *http.Request) { r.Header.Del(caddy.IdentitySecretHeader) }},
+		{"wrong secret", func(r *http.Request) { r.Header.Set(caddy.IdentitySecretHeader, "guess") }},
+		{"secret prefix", func(r *http.Request) { r.Header.Set(caddy.IdentitySecretHeader, testIdentitySecret[:4]) }},
+		{"not loopback", func(r *http.Request) { r.RemoteAddr = "100.64.0.2:50000" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := verifyRequest("")
+			tt.alter(r)
+			w := httptest.NewRecorder()
+			h.Verify(w, r)
+
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusForbidden)
+			}
+			if w.Header().Get(caddy.IdentityUserLoginHeader) != "" {
+				t.Error("identity headers set on a rejected request")
+			}
+		})
+	}
+
+	if n := atomic.LoadInt32(lookups); n != 0 {
+		t.Errorf("whois called %d times for rejected requests", n)
+	}
+}
+
+func TestVerifyAcceptsSameHostAddress(t *testing.T) {
+	var who tailscale.WhoIsResponse
+	who.UserProfile.LoginName = "alice@example.com"
+
+	socket, _ := fakeWhoIs(t, who)
+	h := newTestIdentityHandler(socket)
+
+	// Caddy dials the address the web UI listens on, so the request comes
+	// from that address rather than loopback
+	r := verifyRequest("")
+	r.RemoteAddr = "192.168.1.10:50000"
+	local := &net.TCPAddr{IP: net.ParseIP("192.168.1.10"), Port: 8021}
+	r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, local))
+	w := httptest.NewRecorder()
+	h.Verify(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestIdentityDialAddress(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"", "127.0.0.1:8021"},
+		{"0.0.0.0", "127.0.0.1:8021"},
+		{"::", "[::1]:8021"},
+		{"127.0.0.1", "127.0.0.1:8021"},
+		{"192.168.1.10", "192.168.1.10:8021"},
+		{"fd7a:115c:a1e0::1", "[fd7a:115c:a1e0::1]:8021"},
+		{"webui.internal", "webui.internal:8021"},
+	}
+	for _, tt := range tests {
+		if got := identityDialAddress(config.ServerConfig{Host: tt.host, Port: 8021}); got != tt.want {
+			t.Errorf("host %q: got %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyWithoutConfiguredSecret(t *testing.T) {
+	socket, _ := fakeWhoIs(t, tailscale.WhoIsResponse{})
+	h := newTestIdentityHandler(socket)
+	h.secret = ""
+
+	r := verifyRequest("")
+	r.Header.Set(caddy.IdentitySecretHeader, "")
+	w := httptest.NewRecorder()
+	h.Verify(w, r)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/caddy"
+	"github.com/sudocarlos/tailrelay-webui/internal/certs"
+	"github.com/sudocarlos/tailrelay-webui/internal/config"
+	"github.com/sudocarlos/tailrelay-webui/internal/diagnostics"
+)
+
+// pinCheckInterval is how often pinned upstream certificates are re-checked
+const pinCheckInterval = 30 * time.Minute
+
+// pinStatus compares a proxy's pinned upstream certificate with the one the
+// upstream presents now
+type pinStatus struct {
+	ProxyID   string    `json:"proxy_id"`
+	Target    string    `json:"target"`
+	Pinned    string    `json:"pinned_sha256"`
+	Presented string    `json:"presented_sha256,omitempty"`
+	Changed   bool      `json:"changed"`
+	Error     string    `json:"error,omitempty"` // Set when the upstream couldn't be reached
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// pinMonitor keeps the latest pin check per proxy
+type pinMonitor struct {
+	mu       sync.Mutex
+	statuses map[string]pinStatus
+}
+
+func (m *pinMonitor) get(proxyID string) (pinStatus, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status, ok := m.statuses[proxyID]
+	return status, ok
+}
+
+func (m *pinMonitor) record(status pinStatus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.statuses[status.ProxyID] = status
+}
+
+func (m *pinMonitor) all() []pinStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	statuses := make([]pinStatus, 0, len(m.statuses))
+	for _, status := range m.statuses {
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// pinRequest selects the upstream to fetch a certificate from: a stored
+// proxy, or a target typed into the proxy form before it is saved
+type pinRequest struct {
+	ProxyID    string `json:"proxy_id,omitempty"`
+	Target     string `json:"target,omitempty"`
+	ServerName string `json:"server_name,omitempty"`
+
+	Fingerprint string `json:"fingerprint,omitempty"` // Leaf SHA-256 the user confirmed; pin only
+}
+
+// pinTarget resolves a pin request to the upstream it names. The proxy is
+// nil when the request names a target directly.
+func (h *CaddyHandler) pinTarget(request pinRequest) (diagnostics.Target, *config.CaddyProxy, error) {
+	if request.Target != "" {
+		return diagnostics.Target{Address: strings.TrimSpace(request.Target), ServerName: request.ServerName}, nil, nil
+	}
+	if request.ProxyID == "" {
+		return diagnostics.Target{}, nil, fmt.Errorf("a proxy_id or target is required")
+	}
+	proxy, err := h.manager.GetProxy(request.ProxyID)
+	if err != nil {
+		return diagnostics.Target{}, nil, fmt.Errorf("proxy not found")
+	}
+	if proxy.Target == "" {
+		return diagnostics.Target{}, nil, fmt.Errorf("proxy has no upstream")
+	}
+	return proxyPinTarget(*proxy), proxy, nil
+}
+
+// proxyPinTarget is the upstream a proxy's pin is checked against
+func proxyPinTarget(proxy config.CaddyProxy) diagnostics.Target {
+	return diagnostics.Target{
+		Address:        proxy.Target,
+		ServerName:     proxy.TLSServerName,
+		ClientCertFile: proxy.TLSClientCertFile,
+		ClientKeyFile:  proxy.TLSClientKeyFile,
+	}
+}
+
+// FetchUpstreamCert shows the certificate chain an upstream presents, so its
+// fingerprint can be confirmed before it is pinned
+func (h *CaddyHandler) FetchUpstreamCert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request pinRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	target, _, err := h.pinTarget(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	chain, err := diagnostics.FetchChain(r.Context(), target)
+	if err != nil {
+		log.Printf("Error fetching certificate from %s: %v", target.Address, err)
+		http.Error(w, fmt.Sprintf("Failed to fetch certificate: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":       "success",
+		"target":       target.Address,
+		"fingerprint":  certs.Fingerprint(chain[0]),
+		"certificates": certs.Describe(chain),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// PinUpstreamCert fetches an upstream's chain again and, if its leaf still
+// matches the confirmed fingerprint, saves the chain in the certificates dir
+// as a trust pool. Given a proxy_id the proxy is switched to that trust
+// pool; given a target the saved file is returned for the proxy form.
+func (h *CaddyHandler) PinUpstreamCert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request pinRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	fingerprint := normalizeFingerprint(request.Fingerprint)
+	if fingerprint == "" {
+		http.Error(w, "Confirmed fingerprint is required", http.StatusBadRequest)
+		return
+	}
+	target, proxy, err := h.pinTarget(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	chain, err := diagnostics.FetchChain(r.Context(), target)
+	if err != nil {
+		log.Printf("Error fetching certificate from %s: %v", target.Address, err)
+		http.Error(w, fmt.Sprintf("Failed to fetch certificate: %v", err), http.StatusBadGateway)
+		return
+	}
+	if presented := certs.Fingerprint(chain[0]); presented != fingerprint {
+		log.Printf("Warning: %s presented certificate %s, not the confirmed %s", target.Address, presented, fingerprint)
+		http.Error(w, "The upstream now presents a different certificate; fetch it again and re-check the fingerprint", http.StatusConflict)
+		return
+	}
+
+	certPath, err := h.savePinnedChain(target.Address, chain)
+	if err != nil {
+		log.Printf("Error saving pinned certificate: %v", err)
+		http.Error(w, "Failed to save certificate", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":        "success",
+		"message":       "Certificate saved",
+		"tls_cert_file": certPath,
+		"fingerprint":   fingerprint,
+	}
+
+	if proxy != nil {
+		proxy.TLS = true
+		proxy.TLSCertFile = certPath
+		proxy.TLSPinnedSHA256 = fingerprint
+		if err := h.manager.UpdateProxy(*proxy); err != nil {
+			log.Printf("Error updating proxy: %v", err)
+			if caddy.IsConflict(err) {
+				http.Error(w, caddyConflictMessage, http.StatusConflict)
+				return
+			}
+			http.Error(w, "Failed to update proxy", http.StatusInternalServerError)
+			return
+		}
+		h.pins.record(pinStatus{
+			ProxyID:   proxy.ID,
+			Target:    target.Address,
+			Pinned:    fingerprint,
+			Presented: fingerprint,
+			CheckedAt: time.Now(),
+		})
+		log.Printf("Pinned certificate %s for proxy %s (%s)", fingerprint, proxy.ID, target.Address)
+		response["message"] = "Certificate pinned"
+		response["proxy"] = proxy
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// APIPins lists the last check of every pinned proxy; ?refresh=true checks
+// them now
+func (h *CaddyHandler) APIPins(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if parseBool(r.URL.Query().Get("refresh")) {
+		if err := h.checkPins(r.Context()); err != nil {
+			log.Printf("Error checking pinned certificates: %v", err)
+			http.Error(w, "Failed to check pinned certificates", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.pins.all())
+}
+
+// StartPinMonitor re-checks pinned upstream certificates in the background,
+// logging a warning when an upstream presents a different one
+func (h *CaddyHandler) StartPinMonitor() {
+	go func() {
+		for {
+			if err := h.checkPins(context.Background()); err != nil {
+				log.Printf("Warning: pinned certificate check failed: %v", err)
+			}
+			time.Sleep(pinCheckInterval)
+		}
+	}()
+}
+
+// checkPins compares every pinned proxy's upstream certificate with its pin
+func (h *CaddyHandler) checkPins(ctx context.Context) error {
+	proxies, err := h.manager.ListProxies()
+	if err != nil {
+		return fmt.Errorf("load proxies: %w", err)
+	}
+
+	statuses := make(map[string]pinStatus)
+	for _, proxy := range proxies {
+		if proxy.TLSPinnedSHA256 == "" || proxy.Target == "" {
+			continue
+		}
+		status := pinStatus{
+			ProxyID:   proxy.ID,
+			Target:    proxy.Target,
+			Pinned:    proxy.TLSPinnedSHA256,
+			CheckedAt: time.Now(),
+		}
+
+		chain, err := diagnostics.FetchChain(ctx, proxyPinTarget(proxy))
+		if err != nil {
+			status.Error = err.Error()
+		} else {
+			status.Presented = certs.Fingerprint(chain[0])
+			status.Changed = status.Presented != status.Pinned
+		}
+
+		// Warn once per new certificate rather than on every check
+		previous, _ := h.pins.get(proxy.ID)
+		if status.Changed && previous.Presented != status.Presented {
+			log.Printf("Warning: upstream %s of proxy %s (%s) presents certificate %s, but %s is pinned; re-pin it if the change is expected",
+				proxy.Target, proxy.ID, proxy.Hostname, status.Presented, status.Pinned)
+		}
+		statuses[proxy.ID] = status
+	}
+
+	h.pins.mu.Lock()
+	h.pins.statuses = statuses
+	h.pins.mu.Unlock()
+	return nil
+}
+
+// savePinnedChain writes a fetched chain to the certificates dir
+func (h *CaddyHandler) savePinnedChain(address string, chain []*x509.Certificate) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = address, "443"
+	}
+	certPath, err := h.newCertFilePath(host, port, ".pinned.pem")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(certPath, certs.EncodeChain(chain), 0644); err != nil {
+		return "", fmt.Errorf("write cert file: %w", err)
+	}
+	return certPath, nil
+}
+
+// normalizeFingerprint accepts SHA-256 fingerprints with or without colons
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fingerprint), ":", ""))
+}
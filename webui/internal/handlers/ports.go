@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/caddy"
+	"github.com/sudocarlos/tailrelay-webui/internal/config"
+	"github.com/sudocarlos/tailrelay-webui/internal/ports"
+	"github.com/sudocarlos/tailrelay-webui/internal/socat"
+)
+
+// NewPortRegistry creates the port registry shared by the relay and proxy
+// handlers. It knows the ports of relays, proxies, live Caddy servers, the
+// web UI and a local Caddy admin API.
+func NewPortRegistry(cfg *config.Config, caddyMgr *caddy.Manager) *ports.Registry {
+	fixed := []ports.Claim{{
+		Port:  cfg.Server.Port,
+		Owner: ports.OwnerWebUI,
+		Name:  fmt.Sprintf("the web UI (:%d)", cfg.Server.Port),
+	}}
+	if port, ok := localAdminPort(cfg.CaddyAdmin.Address); ok {
+		fixed = append(fixed, ports.Claim{
+			Port:  port,
+			Owner: ports.OwnerCaddyAdmin,
+			Name:  fmt.Sprintf("the Caddy admin API (:%d)", port),
+		})
+	}
+
+	return ports.NewRegistry(cfg.Ports.AutoAssignStart, cfg.Ports.AutoAssignEnd,
+		func() ([]ports.Claim, error) {
+			return fixed, nil
+		},
+		func() ([]ports.Claim, error) {
+			relays, err := socat.LoadRelays(cfg.Paths.SocatRelayConfig)
+			if err != nil {
+				return nil, fmt.Errorf("load relays: %w", err)
+			}
+			claims := make([]ports.Claim, 0, len(relays))
+			for _, relay := range relays {
+				claims = append(claims, ports.Claim{
+					Port:  relay.ListenPort,
+					Owner: ports.OwnerRelay,
+					ID:    relay.ID,
+					Name:  fmt.Sprintf("relay %s (:%d -> %s:%d)", relay.ID, relay.ListenPort, relay.TargetHost, relay.TargetPort),
+				})
+			}
+			return claims, nil
+		},
+		func() ([]ports.Claim, error) {
+			proxies, err := caddyMgr.ListProxies()
+			if err != nil {
+				return nil, fmt.Errorf("load proxies: %w", err)
+			}
+			claims := make([]ports.Claim, 0, len(proxies))
+			for _, proxy := range proxies {
+				claims = append(claims, ports.Claim{
+					Port:  proxy.Port,
+					Owner: ports.OwnerProxy,
+					ID:    proxy.ID,
+					Name:  fmt.Sprintf("proxy %s (%s:%d)", proxy.ID, proxy.Hostname, proxy.Port),
+				})
+			}
+			return claims, nil
+		},
+		func() ([]ports.Claim, error) {
+			// Caddy being down shouldn't block relays; the bind check still runs
+			listens, err := caddyMgr.ListenPorts()
+			if err != nil {
+				log.Printf("Warning: could not read Caddy listen ports: %v", err)
+				return nil, nil
+			}
+			claims := make([]ports.Claim, 0, len(listens))
+			for port, server := range listens {
+				claims = append(claims, ports.Claim{
+					Port:  port,
+					Owner: ports.OwnerCaddyServer,
+					Name:  fmt.Sprintf("Caddy server %s (:%d)", server, port),
+				})
+			}
+			return claims, nil
+		},
+	)
+}
+
+// localAdminPort returns the port of a Caddy admin address on this host
+func localAdminPort(address string) (int, bool) {
+	endpoint, err := caddy.ParseAdminAddress(address)
+	if err != nil || endpoint.SocketPath != "" {
+		return 0, false
+	}
+	parsed, err := url.Parse(endpoint.BaseURL)
+	if err != nil {
+		return 0, false
+	}
+	switch parsed.Hostname() {
+	case "localhost", "":
+	default:
+		if ip := net.ParseIP(parsed.Hostname()); ip == nil || !(ip.IsLoopback() || ip.IsUnspecified()) {
+			return 0, false
+		}
+	}
+	port, err := strconv.Atoi(parsed.Port())
+	if err != nil {
+		return 0, false
+	}
+	return port, true
+}
+
+// writePortError reports a failed port reservation
+func writePortError(w http.ResponseWriter, err error) {
+	log.Printf("Port check failed: %v", err)
+	switch {
+	case ports.IsConflict(err), errors.Is(err, ports.ErrNoFreePort):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ports.ErrInvalidPort):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Failed to check port", http.StatusInternalServerError)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"net/http"
 
 	"github.com/sudocarlos/tailrelay-webui/internal/config"
+	"github.com/sudocarlos/tailrelay-webui/internal/ports"
 	"github.com/sudocarlos/tailrelay-webui/internal/socat"
 )
 
@@ -18,10 +19,12 @@ type SocatHandler struct {
 	cfg       *config.Config
 	templates *template.Template
 	manager   *socat.Manager
+	ports     *ports.Registry
 }
 
-// NewSocatHandler creates a new socat handler
-func NewSocatHandler(cfg *config.Config, templates *template.Template) *SocatHandler {
+// NewSocatHandler creates a new socat handler. Relay ports are checked
+// against the given port registry.
+func NewSocatHandler(cfg *config.Config, templates *template.Template, portRegistry *ports.Registry) *SocatHandler {
 	manager := socat.NewManager(
 		"socat",
 		cfg.Paths.SocatRelayConfig,
@@ -31,9 +34,27 @@ func NewSocatHandler(cfg *config.Config, templates *template.Template) *SocatHan
 		cfg:       cfg,
 		templates: templates,
 		manager:   manager,
+		ports:     portRegistry,
 	}
 }
 
+// reservePort checks a relay's listen port with the port registry,
+// assigning one when it has none. The caller must release the reservation
+// once the relay is saved.
+func (h *SocatHandler) reservePort(relay *config.SocatRelay) (func(), error) {
+	claim := ports.Claim{
+		Owner: ports.OwnerRelay,
+		ID:    relay.ID,
+		Name:  fmt.Sprintf("relay %s", relay.ID),
+	}
+	port, release, err := h.ports.Reserve(claim, relay.ListenPort)
+	if err != nil {
+		return nil, err
+	}
+	relay.ListenPort = port
+	return release, nil
+}
+
 // InitializeAutostart starts all relays with autostart enabled
 func (h *SocatHandler) InitializeAutostart() error {
 	return h.manager.StartAll()
@@ -95,6 +116,13 @@ func (h *SocatHandler) Create(w http.ResponseWriter, r *http.Request) {
 		relay.Enabled = true
 	}
 
+	release, err := h.reservePort(&relay)
+	if err != nil {
+		writePortError(w, err)
+		return
+	}
+	defer release()
+
 	// Add relay
 	if err := socat.AddRelay(h.cfg.Paths.SocatRelayConfig, relay); err != nil {
 		log.Printf("Error adding relay: %v", err)
@@ -145,6 +173,14 @@ func (h *SocatHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check the port before stopping anything
+	release, err := h.reservePort(&relay)
+	if err != nil {
+		writePortError(w, err)
+		return
+	}
+	defer release()
+
 	// Stop if running
 	if existing.PID != 0 {
 		if err := h.manager.StopRelay(existing); err != nil {
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/caddy"
+	"github.com/sudocarlos/tailrelay-webui/internal/certs"
+	"github.com/sudocarlos/tailrelay-webui/internal/config"
+	"github.com/sudocarlos/tailrelay-webui/internal/tailscale"
+)
+
+const (
+	// defaultTailscaleCertRenewBefore is used when renew_before doesn't parse
+	defaultTailscaleCertRenewBefore = 720 * time.Hour
+	// tailscaleCertRetryInterval is how soon a failed check is retried, e.g.
+	// while tailscaled is still starting
+	tailscaleCertRetryInterval = 5 * time.Minute
+)
+
+// TailscaleCertHandler manages the node's Tailscale HTTPS certificates
+type TailscaleCertHandler struct {
+	cfg      *config.Config
+	caddyMgr *caddy.Manager
+	certs    *tailscale.CertManager
+
+	checkMu sync.Mutex // Serialises checks from the API and the background loop
+	loaded  bool       // Whether Caddy has the current certificates; guarded by checkMu
+}
+
+// NewTailscaleCertHandler creates a new Tailscale certificate handler
+func NewTailscaleCertHandler(cfg *config.Config, caddyMgr *caddy.Manager) *TailscaleCertHandler {
+	renewBefore, err := time.ParseDuration(cfg.TailscaleCerts.RenewBefore)
+	if err != nil || renewBefore <= 0 {
+		log.Printf("Warning: invalid tailscale_certs renew_before %q, using %s", cfg.TailscaleCerts.RenewBefore, defaultTailscaleCertRenewBefore)
+		renewBefore = defaultTailscaleCertRenewBefore
+	}
+
+	return &TailscaleCertHandler{
+		cfg:      cfg,
+		caddyMgr: caddyMgr,
+		certs: tailscale.NewCertManager(
+			tailscale.NewLocalAPIClient(tailscale.DefaultLocalAPISocket),
+			certificatesDir(cfg),
+			renewBefore,
+		),
+	}
+}
+
+// Manager returns the underlying certificate manager
+func (h *TailscaleCertHandler) Manager() *tailscale.CertManager {
+	return h.certs
+}
+
+// StartRenewal checks the certificates now and then every check interval,
+// retrying sooner after a failure
+func (h *TailscaleCertHandler) StartRenewal() error {
+	if !h.cfg.TailscaleCerts.Enabled {
+		return nil
+	}
+	interval, err := time.ParseDuration(h.cfg.TailscaleCerts.CheckInterval)
+	if err != nil || interval <= 0 {
+		return fmt.Errorf("invalid tailscale_certs check interval %q", h.cfg.TailscaleCerts.CheckInterval)
+	}
+
+	go func() {
+		for {
+			wait := interval
+			if err := h.check(context.Background()); err != nil {
+				log.Printf("Warning: Tailscale HTTPS certificate check failed: %v", err)
+				if !errors.Is(err, tailscale.ErrHTTPSDisabled) && wait > tailscaleCertRetryInterval {
+					wait = tailscaleCertRetryInterval
+				}
+			}
+			time.Sleep(wait)
+		}
+	}()
+	return nil
+}
+
+// check refreshes the certificates and, when configured, hands new ones to Caddy
+func (h *TailscaleCertHandler) check(ctx context.Context) error {
+	h.checkMu.Lock()
+	defer h.checkMu.Unlock()
+
+	changed, err := h.certs.Check(ctx)
+	if err != nil {
+		return err
+	}
+	if !h.cfg.TailscaleCerts.LoadIntoCaddy || (!changed && h.loaded) {
+		return nil
+	}
+
+	statuses, _ := h.certs.Statuses()
+	if err := h.caddyMgr.SetLoadedCertificates(loadableCertificates(statuses)); err != nil {
+		return fmt.Errorf("load certificates into Caddy: %w", err)
+	}
+	h.loaded = true
+	return nil
+}
+
+// LoadStored puts the certificates already on disk into the config Caddy is
+// given from the first apply on. Otherwise applying before the first check
+// would drop them and Caddy would briefly obtain its own.
+func (h *TailscaleCertHandler) LoadStored() {
+	if !h.cfg.TailscaleCerts.Enabled || !h.cfg.TailscaleCerts.LoadIntoCaddy {
+		return
+	}
+	h.certs.LoadStored()
+	statuses, _ := h.certs.Statuses()
+	h.caddyMgr.SeedLoadedCertificates(loadableCertificates(statuses))
+}
+
+// loadableCertificates lists the certificates Caddy should load
+func loadableCertificates(statuses []tailscale.CertStatus) []caddy.LoadedCertificate {
+	loaded := make([]caddy.LoadedCertificate, 0, len(statuses))
+	for _, status := range statuses {
+		// Let Caddy fetch its own rather than serve an expired certificate
+		if !status.Loaded() || status.Status == certs.StatusExpired {
+			continue
+		}
+		loaded = append(loaded, caddy.LoadedCertificate{
+			CertFile:    status.CertFile,
+			KeyFile:     status.KeyFile,
+			Fingerprint: status.Fingerprint,
+		})
+	}
+	return loaded
+}
+
+// statusResponse is the body of the certificate status endpoints
+func (h *TailscaleCertHandler) statusResponse() map[string]interface{} {
+	statuses, lastErr := h.certs.Statuses()
+	return map[string]interface{}{
+		"status":          "success",
+		"enabled":         h.cfg.TailscaleCerts.Enabled,
+		"load_into_caddy": h.cfg.TailscaleCerts.LoadIntoCaddy,
+		"error":           lastErr,
+		"certificates":    statuses,
+	}
+}
+
+// APIStatus returns the state of each cert domain's certificate
+func (h *TailscaleCertHandler) APIStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.statusResponse())
+}
+
+// Check asks tailscaled for the certificates now instead of waiting for the
+// next scheduled check
+func (h *TailscaleCertHandler) Check(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.cfg.TailscaleCerts.Enabled {
+		http.Error(w, "Tailscale certificate management is disabled", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.check(r.Context()); err != nil {
+		log.Printf("Error checking Tailscale HTTPS certificates: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to check certificates: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.statusResponse())
+}
@@ -0,0 +1,204 @@
+package ports
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+)
+
+// Port owners
+const (
+	OwnerProxy       = "proxy"        // A Caddy proxy in metadata
+	OwnerCaddyServer = "caddy_server" // A port a live Caddy server listens on
+	OwnerRelay       = "relay"        // A socat relay
+	OwnerWebUI       = "webui"        // The web UI's own listener
+	OwnerCaddyAdmin  = "caddy_admin"  // Caddy's admin API
+)
+
+// Claim is a port used by something tailrelay manages or depends on
+type Claim struct {
+	Port  int    `json:"port"`
+	Owner string `json:"owner"`
+	ID    string `json:"id,omitempty"`
+	Name  string `json:"name"` // Shown in conflict errors, e.g. "relay abc (:9735)"
+}
+
+// sameAs reports whether two claims are for the same relay or proxy
+func (c Claim) sameAs(other Claim) bool {
+	return c.ID != "" && c.Owner == other.Owner && c.ID == other.ID
+}
+
+// canShare reports whether c may use a port other already holds. Proxies
+// share ports through Caddy's per-port servers; nothing else can share.
+func (c Claim) canShare(other Claim) bool {
+	return c.Owner == OwnerProxy && (other.Owner == OwnerProxy || other.Owner == OwnerCaddyServer)
+}
+
+// Source lists the ports claimed by one kind of owner
+type Source func() ([]Claim, error)
+
+var (
+	// ErrNoFreePort is returned when auto-assignment finds no usable port
+	ErrNoFreePort = errors.New("no free port in the auto-assign range")
+	// ErrInvalidPort is returned for ports outside 1-65535
+	ErrInvalidPort = errors.New("port out of range")
+)
+
+// ConflictError is returned when a port is claimed by something else or
+// can't be bound
+type ConflictError struct {
+	Port   int
+	Holder *Claim // Nil when the port is taken outside tailrelay
+	Err    error  // Bind error when Holder is nil
+}
+
+func (e *ConflictError) Error() string {
+	if e.Holder != nil {
+		return fmt.Sprintf("port %d is already used by %s", e.Port, e.Holder.Name)
+	}
+	return fmt.Sprintf("port %d is not available: %v", e.Port, e.Err)
+}
+
+// IsConflict reports whether err is a port conflict
+func IsConflict(err error) bool {
+	var conflict *ConflictError
+	return errors.As(err, &conflict)
+}
+
+// Registry decides which ports relays and proxies may use. Claims are read
+// from their sources on every check, so the registry never goes stale;
+// ports handed out by Reserve stay claimed until released, which covers the
+// time between a check and the owner being saved.
+type Registry struct {
+	mu         sync.Mutex
+	sources    []Source
+	pending    map[int][]Claim
+	rangeStart int
+	rangeEnd   int
+}
+
+// NewRegistry creates a registry auto-assigning ports from [start, end]
+func NewRegistry(start, end int, sources ...Source) *Registry {
+	return &Registry{
+		sources:    sources,
+		pending:    make(map[int][]Claim),
+		rangeStart: start,
+		rangeEnd:   end,
+	}
+}
+
+// Claims lists every claimed port, sorted by port
+func (r *Registry) Claims() ([]Claim, error) {
+	var claims []Claim
+	for _, source := range r.sources {
+		found, err := source()
+		if err != nil {
+			return nil, err
+		}
+		claims = append(claims, found...)
+	}
+	sort.SliceStable(claims, func(i, j int) bool { return claims[i].Port < claims[j].Port })
+	return claims, nil
+}
+
+// Reserve checks that claim may use port and holds it until release is
+// called. A port of 0 picks the first free port in the auto-assign range.
+// The returned port is the one reserved.
+func (r *Registry) Reserve(claim Claim, port int) (int, func(), error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	claims, err := r.Claims()
+	if err != nil {
+		return 0, nil, fmt.Errorf("load port claims: %w", err)
+	}
+	for _, held := range r.pending {
+		claims = append(claims, held...)
+	}
+	byPort := make(map[int][]Claim)
+	for _, held := range claims {
+		byPort[held.Port] = append(byPort[held.Port], held)
+	}
+
+	if port == 0 {
+		if port, err = r.firstFree(claim, byPort); err != nil {
+			return 0, nil, err
+		}
+	} else if err := r.check(claim, port, byPort[port]); err != nil {
+		return 0, nil, err
+	}
+
+	claim.Port = port
+	r.pending[port] = append(r.pending[port], claim)
+	release := func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		held := r.pending[port]
+		for i := range held {
+			if held[i] == claim {
+				held = append(held[:i], held[i+1:]...)
+				break
+			}
+		}
+		if len(held) == 0 {
+			delete(r.pending, port)
+		} else {
+			r.pending[port] = held
+		}
+	}
+	return port, release, nil
+}
+
+// check decides whether claim may use a port with the given holders
+func (r *Registry) check(claim Claim, port int, holders []Claim) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("%w: %d", ErrInvalidPort, port)
+	}
+
+	inUse := false // Something we know about is listening, so binding would fail
+	for i := range holders {
+		holder := holders[i]
+		switch {
+		case claim.sameAs(holder):
+			inUse = true
+		case claim.canShare(holder):
+			inUse = true
+		default:
+			return &ConflictError{Port: port, Holder: &holder}
+		}
+	}
+	if inUse {
+		return nil
+	}
+
+	if err := checkBindable(port); err != nil {
+		return &ConflictError{Port: port, Err: err}
+	}
+	return nil
+}
+
+// firstFree returns the first port in the auto-assign range nothing claims
+// and that can be bound
+func (r *Registry) firstFree(claim Claim, byPort map[int][]Claim) (int, error) {
+	for port := r.rangeStart; port <= r.rangeEnd && port > 0; port++ {
+		if len(byPort[port]) > 0 {
+			continue
+		}
+		if r.check(claim, port, nil) == nil {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("%w (%d-%d)", ErrNoFreePort, r.rangeStart, r.rangeEnd)
+}
+
+// checkBindable tries to listen on a port on all interfaces, as relays and
+// Caddy servers do
+func checkBindable(port int) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	return listener.Close()
+}
@@ -0,0 +1,258 @@
+package tailscale
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/certs"
+)
+
+// ErrHTTPSDisabled is returned when tailscaled offers no cert domains, which
+// means HTTPS certificates are turned off for the tailnet
+var ErrHTTPSDisabled = errors.New("HTTPS certificates are not enabled for this tailnet")
+
+// CertStatus is the state of the HTTPS certificate for one cert domain
+type CertStatus struct {
+	Domain      string     `json:"domain"`
+	CertFile    string     `json:"cert_file,omitempty"`
+	KeyFile     string     `json:"key_file,omitempty"`
+	Fingerprint string     `json:"fingerprint,omitempty"` // Leaf SHA-256
+	NotBefore   *time.Time `json:"not_before,omitempty"`
+	NotAfter    *time.Time `json:"not_after,omitempty"`
+	Status      string     `json:"status,omitempty"`     // ok, expiring or expired
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"` // When the files last changed
+	CheckedAt   time.Time  `json:"checked_at"`
+	Error       string     `json:"error,omitempty"` // Why the last request to tailscaled failed
+}
+
+// Loaded reports whether the status has a certificate on disk
+func (s CertStatus) Loaded() bool {
+	return s.CertFile != "" && s.Fingerprint != ""
+}
+
+// CertManager requests HTTPS certificates for the node's cert domains from
+// tailscaled and keeps them as files in a directory
+type CertManager struct {
+	localAPI    *LocalAPIClient
+	dir         string
+	renewBefore time.Duration
+
+	mu       sync.Mutex
+	statuses map[string]CertStatus
+	lastErr  string // Why the last check couldn't list the cert domains
+}
+
+// NewCertManager creates a manager that stores certificates in dir and asks
+// tailscaled for a new one when less than renewBefore is left
+func NewCertManager(localAPI *LocalAPIClient, dir string, renewBefore time.Duration) *CertManager {
+	return &CertManager{
+		localAPI:    localAPI,
+		dir:         dir,
+		renewBefore: renewBefore,
+		statuses:    make(map[string]CertStatus),
+	}
+}
+
+// Statuses returns the last known state of every cert domain, sorted by
+// domain, and the error of the last check if it failed as a whole
+func (m *CertManager) Statuses() ([]CertStatus, string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	statuses := make([]CertStatus, 0, len(m.statuses))
+	for _, status := range m.statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Domain < statuses[j].Domain })
+	return statuses, m.lastErr
+}
+
+// LoadStored reports the certificates already on disk, so they can be used
+// before tailscaled has been asked. Domains a check has seen are left alone.
+func (m *CertManager) LoadStored() {
+	matches, _ := filepath.Glob(filepath.Join(m.dir, "tailscale-*.crt"))
+	for _, certFile := range matches {
+		domain := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(certFile), "tailscale-"), ".crt")
+		if stored, keyFile := m.certPaths(domain); stored == certFile {
+			status := CertStatus{Domain: domain}
+			if err := status.inspect(certFile, keyFile); err != nil {
+				log.Printf("Warning: ignoring stored Tailscale HTTPS certificate %s: %v", certFile, err)
+				continue
+			}
+
+			m.mu.Lock()
+			if _, ok := m.statuses[domain]; !ok {
+				m.statuses[domain] = status
+			}
+			m.mu.Unlock()
+		}
+	}
+}
+
+// Check asks tailscaled for the current certificate of every cert domain,
+// writing the files when they changed. It reports whether any did.
+func (m *CertManager) Check(ctx context.Context) (bool, error) {
+	status, err := m.localAPI.Status(ctx)
+	if err == nil && len(status.CertDomains) == 0 {
+		err = ErrHTTPSDisabled
+	}
+	if err != nil {
+		m.mu.Lock()
+		m.lastErr = err.Error()
+		m.mu.Unlock()
+		return false, err
+	}
+
+	m.mu.Lock()
+	previous := m.statuses
+	m.mu.Unlock()
+
+	changed := false
+	statuses := make(map[string]CertStatus, len(status.CertDomains))
+	for _, domain := range status.CertDomains {
+		domainStatus, domainChanged := m.checkDomain(ctx, domain, previous[domain])
+		statuses[domain] = domainStatus
+		changed = changed || domainChanged
+	}
+
+	m.mu.Lock()
+	m.statuses = statuses
+	m.lastErr = ""
+	m.mu.Unlock()
+	return changed, nil
+}
+
+// checkDomain fetches one domain's certificate. If tailscaled can't provide
+// it the files already on disk are still reported.
+func (m *CertManager) checkDomain(ctx context.Context, domain string, previous CertStatus) (CertStatus, bool) {
+	certFile, keyFile := m.certPaths(domain)
+	status := CertStatus{Domain: domain, CheckedAt: time.Now()}
+
+	changed := false
+	certPEM, keyPEM, err := m.localAPI.CertPair(ctx, domain, m.renewBefore)
+	if err == nil {
+		changed, err = writeCertPair(certFile, keyFile, certPEM, keyPEM)
+	}
+	if err != nil {
+		status.Error = err.Error()
+		if previous.Error != status.Error {
+			log.Printf("Warning: failed to get Tailscale HTTPS certificate for %s: %v", domain, err)
+		}
+	}
+
+	if err := status.inspect(certFile, keyFile); err != nil {
+		if status.Error == "" {
+			status.Error = err.Error()
+		}
+		return status, changed
+	}
+	if changed {
+		log.Printf("Stored Tailscale HTTPS certificate for %s, valid until %s", domain, status.NotAfter.Format("2006-01-02"))
+	}
+	if status.Status != certs.StatusOK && status.Status != previous.Status {
+		log.Printf("Warning: Tailscale HTTPS certificate for %s is %s (expires %s)", domain, status.Status, status.NotAfter.Format("2006-01-02"))
+	}
+	return status, changed
+}
+
+// inspect fills in the status from the stored files
+func (s *CertStatus) inspect(certFile, keyFile string) error {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("read cert file: %w", err)
+	}
+	chain, err := certs.ParseCertificates(data)
+	if err != nil {
+		return fmt.Errorf("parse cert file: %w", err)
+	}
+	if _, err := os.Stat(keyFile); err != nil {
+		return fmt.Errorf("key file: %w", err)
+	}
+	info, err := os.Stat(certFile)
+	if err != nil {
+		return fmt.Errorf("cert file: %w", err)
+	}
+
+	leaf := chain[0]
+	updatedAt := info.ModTime()
+	s.CertFile = certFile
+	s.KeyFile = keyFile
+	s.Fingerprint = certs.Fingerprint(leaf)
+	s.NotBefore = &leaf.NotBefore
+	s.NotAfter = &leaf.NotAfter
+	s.Status = certs.ExpiryStatus(leaf.NotAfter, time.Now())
+	s.UpdatedAt = &updatedAt
+	return nil
+}
+
+// certPaths is where a domain's certificate and key are stored
+func (m *CertManager) certPaths(domain string) (string, string) {
+	name := "tailscale-" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, strings.ToLower(domain))
+	return filepath.Join(m.dir, name+".crt"), filepath.Join(m.dir, name+".key")
+}
+
+// writeCertPair replaces the stored pair when the certificate differs,
+// reporting whether it did
+func writeCertPair(certFile, keyFile string, certPEM, keyPEM []byte) (bool, error) {
+	if _, err := certs.ParseCertificates(certPEM); err != nil {
+		return false, fmt.Errorf("parse certificate: %w", err)
+	}
+	if err := certs.ValidatePrivateKey(keyPEM); err != nil {
+		return false, fmt.Errorf("parse private key: %w", err)
+	}
+	current, _ := os.ReadFile(certFile)
+	if bytes.Equal(current, certPEM) {
+		return false, nil
+	}
+
+	// The key goes first so the cert never points at a stale one
+	if err := writeFileAtomic(keyFile, keyPEM, 0600); err != nil {
+		return false, fmt.Errorf("write key file: %w", err)
+	}
+	if err := writeFileAtomic(certFile, certPEM, 0644); err != nil {
+		return false, fmt.Errorf("write cert file: %w", err)
+	}
+	return true, nil
+}
+
+// writeFileAtomic writes through a temporary file so readers such as Caddy
+// never see a partial file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,174 @@
+package tailscale
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sudocarlos/tailrelay-webui/internal/certs"
+)
+
+const testCertDomain = "node.tail1234.ts.net"
+
+func newTestCertManager(t *testing.T, api *fakeLocalAPI) *CertManager {
+	t.Helper()
+	return NewCertManager(startFakeLocalAPI(t, api), t.TempDir(), 30*24*time.Hour)
+}
+
+func TestCheckWritesCertificate(t *testing.T) {
+	certPEM, keyPEM, pair := testPair(t, testCertDomain, time.Now().Add(90*24*time.Hour))
+	api := &fakeLocalAPI{certDomains: []string{testCertDomain}}
+	api.setPair(testCertDomain, pair)
+	m := newTestCertManager(t, api)
+
+	changed, err := m.Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("first check reported no change")
+	}
+
+	statuses, lastErr := m.Statuses()
+	if lastErr != "" || len(statuses) != 1 {
+		t.Fatalf("statuses = %+v, error %q", statuses, lastErr)
+	}
+	status := statuses[0]
+	if !status.Loaded() || status.Status != certs.StatusOK || status.Error != "" {
+		t.Errorf("status = %+v", status)
+	}
+
+	if data, err := os.ReadFile(status.CertFile); err != nil || !bytes.Equal(data, certPEM) {
+		t.Errorf("cert file = %s, %v", data, err)
+	}
+	if data, err := os.ReadFile(status.KeyFile); err != nil || !bytes.Equal(data, keyPEM) {
+		t.Errorf("key file = %s, %v", data, err)
+	}
+	if info, err := os.Stat(status.KeyFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("key file mode = %v, %v; want 0600", info.Mode().Perm(), err)
+	}
+}
+
+func TestCheckUnchangedCertificate(t *testing.T) {
+	_, _, pair := testPair(t, testCertDomain, time.Now().Add(90*24*time.Hour))
+	api := &fakeLocalAPI{certDomains: []string{testCertDomain}}
+	api.setPair(testCertDomain, pair)
+	m := newTestCertManager(t, api)
+
+	if _, err := m.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := m.Statuses()
+
+	changed, err := m.Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Error("check of the same certificate reported a change")
+	}
+	after, _ := m.Statuses()
+	if after[0].Fingerprint != before[0].Fingerprint || !after[0].UpdatedAt.Equal(*before[0].UpdatedAt) {
+		t.Errorf("stored certificate changed: %+v -> %+v", before[0], after[0])
+	}
+}
+
+func TestCheckWritesRenewedCertificate(t *testing.T) {
+	_, _, oldPair := testPair(t, testCertDomain, time.Now().Add(10*24*time.Hour))
+	api := &fakeLocalAPI{certDomains: []string{testCertDomain}}
+	api.setPair(testCertDomain, oldPair)
+	m := newTestCertManager(t, api)
+
+	if _, err := m.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := m.Statuses()
+	if before[0].Status != certs.StatusExpiring {
+		t.Errorf("status before renewal = %q, want %q", before[0].Status, certs.StatusExpiring)
+	}
+
+	newCert, _, newPair := testPair(t, testCertDomain, time.Now().Add(90*24*time.Hour))
+	api.setPair(testCertDomain, newPair)
+
+	changed, err := m.Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("renewal reported no change")
+	}
+	after, _ := m.Statuses()
+	if after[0].Fingerprint == before[0].Fingerprint || after[0].Status != certs.StatusOK {
+		t.Errorf("status after renewal = %+v", after[0])
+	}
+	if data, _ := os.ReadFile(after[0].CertFile); !bytes.Equal(data, newCert) {
+		t.Error("cert file doesn't hold the renewed certificate")
+	}
+	for _, minValidity := range api.minValidity {
+		if minValidity != "720h0m0s" {
+			t.Errorf("min_validity = %q, want the renew-before window", minValidity)
+		}
+	}
+}
+
+func TestCheckKeepsStoredCertificateOnError(t *testing.T) {
+	_, _, pair := testPair(t, testCertDomain, time.Now().Add(90*24*time.Hour))
+	api := &fakeLocalAPI{certDomains: []string{testCertDomain}}
+	api.setPair(testCertDomain, pair)
+	m := newTestCertManager(t, api)
+
+	if _, err := m.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	api.setPair(testCertDomain, []byte("garbage"))
+
+	changed, err := m.Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses, _ := m.Statuses()
+	if changed || !statuses[0].Loaded() || statuses[0].Error == "" {
+		t.Errorf("changed = %v, status = %+v; want the stored certificate with an error", changed, statuses[0])
+	}
+}
+
+func TestCheckWithoutCertDomains(t *testing.T) {
+	m := newTestCertManager(t, &fakeLocalAPI{})
+
+	changed, err := m.Check(context.Background())
+	if !errors.Is(err, ErrHTTPSDisabled) {
+		t.Fatalf("err = %v, want ErrHTTPSDisabled", err)
+	}
+	if changed {
+		t.Error("reported a change")
+	}
+	if _, lastErr := m.Statuses(); lastErr != ErrHTTPSDisabled.Error() {
+		t.Errorf("last error = %q", lastErr)
+	}
+}
+
+func TestLoadStored(t *testing.T) {
+	_, _, pair := testPair(t, testCertDomain, time.Now().Add(90*24*time.Hour))
+	api := &fakeLocalAPI{certDomains: []string{testCertDomain}}
+	api.setPair(testCertDomain, pair)
+	m := newTestCertManager(t, api)
+	if _, err := m.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	checked, _ := m.Statuses()
+
+	// A restarted manager finds the files without asking tailscaled
+	restarted := NewCertManager(NewLocalAPIClient("/nonexistent.sock"), m.dir, m.renewBefore)
+	restarted.LoadStored()
+
+	statuses, _ := restarted.Statuses()
+	if len(statuses) != 1 {
+		t.Fatalf("statuses = %+v", statuses)
+	}
+	if !statuses[0].Loaded() || statuses[0].Domain != testCertDomain || statuses[0].Fingerprint != checked[0].Fingerprint {
+		t.Errorf("status = %+v", statuses[0])
+	}
+}
@@ -0,0 +1,168 @@
+package tailscale
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultLocalAPISocket is where tailscaled serves its LocalAPI in the container
+	DefaultLocalAPISocket = "/var/run/tailscale/tailscaled.sock"
+	// localAPIHost is the fixed host name tailscaled expects on LocalAPI requests
+	localAPIHost = "local-tailscaled.sock"
+	// certRequestTimeout bounds cert requests, which may wait on an ACME order
+	certRequestTimeout = 2 * time.Minute
+)
+
+// LocalAPIClient talks to tailscaled's LocalAPI over its unix socket
+type LocalAPIClient struct {
+	SocketPath string
+	HTTPClient *http.Client
+}
+
+// NewLocalAPIClient creates a LocalAPI client for the given socket path
+func NewLocalAPIClient(socketPath string) *LocalAPIClient {
+	if socketPath == "" {
+		socketPath = DefaultLocalAPISocket
+	}
+
+	return &LocalAPIClient{
+		SocketPath: socketPath,
+		HTTPClient: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+// WhoIsResponse is the subset of tailscaled's whois reply used by the web UI
+type WhoIsResponse struct {
+	Node struct {
+		Name         string   `json:"Name"` // MagicDNS FQDN with trailing dot
+		ComputedName string   `json:"ComputedName"`
+		Tags         []string `json:"Tags"`
+	} `json:"Node"`
+	UserProfile struct {
+		LoginName   string `json:"LoginName"`
+		DisplayName string `json:"DisplayName"`
+	} `json:"UserProfile"`
+}
+
+// WhoIs resolves the tailnet identity behind an IP or IP:port. With
+// userspace networking, pass the loopback IP:port the connection arrived
+// from and tailscaled maps it back to the peer.
+func (c *LocalAPIClient) WhoIs(ctx context.Context, addr string) (*WhoIsResponse, error) {
+	var who WhoIsResponse
+	if err := c.get(ctx, "/localapi/v0/whois?addr="+url.QueryEscape(addr), &who); err != nil {
+		return nil, fmt.Errorf("whois %s: %w", addr, err)
+	}
+	return &who, nil
+}
+
+// StatusResponse is the subset of tailscaled's status reply used by the web UI
+type StatusResponse struct {
+	BackendState string `json:"BackendState"`
+	Self         struct {
+		DNSName string `json:"DNSName"` // MagicDNS FQDN with trailing dot
+	} `json:"Self"`
+	CertDomains []string `json:"CertDomains"` // Names this node can get HTTPS certs for
+}
+
+// Status returns tailscaled's view of this node, without peers
+func (c *LocalAPIClient) Status(ctx context.Context) (*StatusResponse, error) {
+	var status StatusResponse
+	if err := c.get(ctx, "/localapi/v0/status?peers=false", &status); err != nil {
+		return nil, fmt.Errorf("status: %w", err)
+	}
+	return &status, nil
+}
+
+// CertPair fetches the HTTPS certificate chain and private key for one of
+// the node's cert domains. tailscaled serves a cached cert and only orders a
+// new one when less than minValidity is left (or, with zero, when it decides
+// to renew on its own).
+func (c *LocalAPIClient) CertPair(ctx context.Context, domain string, minValidity time.Duration) (certPEM, keyPEM []byte, err error) {
+	path := "/localapi/v0/cert/" + url.PathEscape(domain) + "?type=pair"
+	if minValidity > 0 {
+		path += "&min_validity=" + url.QueryEscape(minValidity.String())
+	}
+
+	httpClient := *c.HTTPClient
+	httpClient.Timeout = certRequestTimeout
+	slow := &LocalAPIClient{SocketPath: c.SocketPath, HTTPClient: &httpClient}
+	body, err := slow.do(ctx, http.MethodGet, path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cert %s: %w", domain, err)
+	}
+
+	// The reply is the key followed by the chain, both PEM
+	var certBuf, keyBuf bytes.Buffer
+	for rest := body; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if strings.Contains(block.Type, "PRIVATE KEY") {
+			pem.Encode(&keyBuf, block)
+		} else {
+			pem.Encode(&certBuf, block)
+		}
+	}
+	if certBuf.Len() == 0 || keyBuf.Len() == 0 {
+		return nil, nil, fmt.Errorf("cert %s: reply holds no certificate and key pair", domain)
+	}
+	return certBuf.Bytes(), keyBuf.Bytes(), nil
+}
+
+// get performs a LocalAPI GET request and decodes the JSON reply into out
+func (c *LocalAPIClient) get(ctx context.Context, path string, out interface{}) error {
+	body, err := c.do(ctx, http.MethodGet, path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// do performs a LocalAPI request and returns the raw response body
+func (c *LocalAPIClient) do(ctx context.Context, method, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, "http://"+localAPIHost+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Sec-Tailscale", "localapi")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("LocalAPI error %d: %s", resp.StatusCode, string(body))
+	}
+
+	return body, nil
+}
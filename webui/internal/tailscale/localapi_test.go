@@ -0,0 +1,188 @@
+package tailscale
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLocalAPI serves the status and cert endpoints of tailscaled's LocalAPI
+// on a unix socket
+type fakeLocalAPI struct {
+	mu          sync.Mutex
+	certDomains []string
+	pairs       map[string][]byte // Reply to a cert request, by domain
+	minValidity []string          // min_validity of each cert request
+}
+
+// startFakeLocalAPI serves api and returns a client for it
+func startFakeLocalAPI(t *testing.T, api *fakeLocalAPI) *LocalAPIClient {
+	t.Helper()
+
+	// Unix socket paths are short, so avoid the long t.TempDir path
+	dir, err := os.MkdirTemp("", "ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "tailscaled.sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: api}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	return NewLocalAPIClient(socket)
+}
+
+func (api *fakeLocalAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if r.Header.Get("Sec-Tailscale") != "localapi" {
+		http.Error(w, "missing Sec-Tailscale header", http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/localapi/v0/status":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"BackendState": "Running",
+			"CertDomains":  api.certDomains,
+		})
+	case strings.HasPrefix(r.URL.Path, "/localapi/v0/cert/"):
+		api.minValidity = append(api.minValidity, r.URL.Query().Get("min_validity"))
+		pair, ok := api.pairs[strings.TrimPrefix(r.URL.Path, "/localapi/v0/cert/")]
+		if !ok || r.URL.Query().Get("type") != "pair" {
+			http.Error(w, "no cert", http.StatusInternalServerError)
+			return
+		}
+		w.Write(pair)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// setPair changes the reply to cert requests for a domain
+func (api *fakeLocalAPI) setPair(domain string, pair []byte) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.pairs == nil {
+		api.pairs = make(map[string][]byte)
+	}
+	api.pairs[domain] = pair
+}
+
+// testPair creates a self-signed certificate for domain and returns its
+// PEM certificate and key, and the pair as tailscaled replies with it
+func testPair(t *testing.T, domain string, notAfter time.Time) (certPEM, keyPEM, pair []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, append(append([]byte(nil), keyPEM...), certPEM...)
+}
+
+func TestStatus(t *testing.T) {
+	client := startFakeLocalAPI(t, &fakeLocalAPI{certDomains: []string{"node.tail1234.ts.net"}})
+
+	status, err := client.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.BackendState != "Running" || len(status.CertDomains) != 1 || status.CertDomains[0] != "node.tail1234.ts.net" {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestCertPairSplitsKeyAndChain(t *testing.T) {
+	const domain = "node.tail1234.ts.net"
+	certPEM, keyPEM, pair := testPair(t, domain, time.Now().Add(90*24*time.Hour))
+	intermediatePEM, _, _ := testPair(t, "intermediate", time.Now().Add(365*24*time.Hour))
+
+	api := &fakeLocalAPI{}
+	api.setPair(domain, append(pair, intermediatePEM...))
+	client := startFakeLocalAPI(t, api)
+
+	gotCert, gotKey, err := client.CertPair(context.Background(), domain, 30*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(append([]byte(nil), certPEM...), intermediatePEM...); !bytes.Equal(gotCert, want) {
+		t.Errorf("cert PEM doesn't hold the leaf followed by the intermediate:\n%s", gotCert)
+	}
+	if !bytes.Equal(gotKey, keyPEM) {
+		t.Errorf("key PEM = %s, want %s", gotKey, keyPEM)
+	}
+	if len(api.minValidity) != 1 || api.minValidity[0] != "720h0m0s" {
+		t.Errorf("min_validity = %v, want [720h0m0s]", api.minValidity)
+	}
+}
+
+func TestCertPairRejectsIncompleteReply(t *testing.T) {
+	const domain = "node.tail1234.ts.net"
+	certPEM, keyPEM, _ := testPair(t, domain, time.Now().Add(90*24*time.Hour))
+
+	for name, reply := range map[string][]byte{
+		"cert only": certPEM,
+		"key only":  keyPEM,
+		"not PEM":   []byte("not a certificate"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &fakeLocalAPI{}
+			api.setPair(domain, reply)
+			client := startFakeLocalAPI(t, api)
+
+			if _, _, err := client.CertPair(context.Background(), domain, 0); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestCertPairReportsLocalAPIErrors(t *testing.T) {
+	client := startFakeLocalAPI(t, &fakeLocalAPI{})
+
+	_, _, err := client.CertPair(context.Background(), "unknown.tail1234.ts.net", 0)
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("err = %v, want the LocalAPI status", err)
+	}
+}
@@ -24,6 +24,10 @@ type Server struct {
 	socatH     *handlers.SocatHandler
 	backupH    *handlers.BackupHandler
 	logsH      *handlers.Handler
+	identityH  *handlers.IdentityHandler
+	diagH      *handlers.DiagnosticsHandler
+	certH      *handlers.CertificateHandler
+	tsCertH    *handlers.TailscaleCertHandler
 	staticFS   fs.FS
 	templateFS fs.FS
 }
@@ -44,12 +48,22 @@ func NewServer(cfg *config.Config, authToken string, staticFS, templateFS fs.FS)
 	}
 
 	// Create handlers
-	dashboardH := handlers.NewDashboardHandler(cfg, tmpl)
+	identitySecret, err := config.LoadOrGenerateToken(cfg.Auth.IdentitySecretFile())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load identity secret: %w", err)
+	}
+	caddyH := handlers.NewCaddyHandler(cfg, tmpl, identitySecret)
+	dashboardH := handlers.NewDashboardHandler(cfg, tmpl, caddyH.Manager())
 	tailscaleH := handlers.NewTailscaleHandler(cfg, tmpl, authMW)
-	caddyH := handlers.NewCaddyHandler(cfg, tmpl)
-	socatH := handlers.NewSocatHandler(cfg, tmpl)
+	portRegistry := handlers.NewPortRegistry(cfg, caddyH.Manager())
+	caddyH.SetPortRegistry(portRegistry)
+	socatH := handlers.NewSocatHandler(cfg, tmpl, portRegistry)
 	backupH := handlers.NewBackupHandler(cfg, tmpl)
 	logsH := handlers.NewHandler(tmpl)
+	identityH := handlers.NewIdentityHandler(identitySecret)
+	diagH := handlers.NewDiagnosticsHandler(cfg, caddyH.Manager())
+	tsCertH := handlers.NewTailscaleCertHandler(cfg, caddyH.Manager())
+	certH := handlers.NewCertificateHandler(cfg, caddyH.Manager(), tsCertH.Manager())
 
 	return &Server{
 		cfg:        cfg,
@@ -61,6 +75,10 @@ func NewServer(cfg *config.Config, authToken string, staticFS, templateFS fs.FS)
 		socatH:     socatH,
 		backupH:    backupH,
 		logsH:      logsH,
+		identityH:  identityH,
+		diagH:      diagH,
+		certH:      certH,
+		tsCertH:    tsCertH,
 		staticFS:   staticFS,
 		templateFS: templateFS,
 	}, nil
@@ -68,6 +86,15 @@ func NewServer(cfg *config.Config, authToken string, staticFS, templateFS fs.FS)
 
 // Start starts the HTTP server
 func (s *Server) Start() error {
+	// Discovery and autostart need Caddy, so give it time to come up
+	log.Printf("Waiting for Caddy admin API...")
+	if err := s.caddyH.WaitForCaddy(); err != nil {
+		log.Printf("Warning: %v; continuing without it", err)
+	}
+
+	// Keep stored Tailscale certificates in Caddy's config from the first apply
+	s.tsCertH.LoadStored()
+
 	// Migrate existing Caddy proxies to metadata storage
 	log.Printf("Migrating existing Caddy proxies to metadata storage...")
 	if err := s.caddyH.MigrateExistingProxies(); err != nil {
@@ -86,6 +113,25 @@ func (s *Server) Start() error {
 		log.Printf("Warning: failed to start autostart proxies: %v", err)
 	}
 
+	// Keep watching for Caddy config drifting away from metadata
+	if err := s.caddyH.StartReconciler(); err != nil {
+		log.Printf("Warning: failed to start Caddy reconciler: %v", err)
+	}
+
+	// Warn when an upstream stops presenting its pinned certificate
+	s.caddyH.StartPinMonitor()
+
+	// Run active upstream health checks, whose results Caddy doesn't report
+	s.caddyH.StartHealthMonitor()
+
+	// Warn about stored certificates nearing expiry
+	s.certH.StartExpiryCheck()
+
+	// Keep the node's Tailscale HTTPS certificates current
+	if err := s.tsCertH.StartRenewal(); err != nil {
+		log.Printf("Warning: failed to start Tailscale certificate renewal: %v", err)
+	}
+
 	mux := s.setupRoutes()
 
 	addr := fmt.Sprintf("%s:%d", s.cfg.Server.Host, s.cfg.Server.Port)
@@ -103,6 +149,8 @@ func (s *Server) setupRoutes() *http.ServeMux {
 	mux.HandleFunc("/logout", s.handleLogout)
 	mux.Handle("/api/tailscale/login", http.HandlerFunc(s.tailscaleH.Login))
 	mux.Handle("/api/tailscale/poll", http.HandlerFunc(s.tailscaleH.PollStatus))
+	// Called by Caddy over loopback; the handler rejects any other caller
+	mux.Handle("/api/identity/verify", http.HandlerFunc(s.identityH.Verify))
 
 	// Static files
 	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(s.staticFS))))
@@ -128,6 +176,17 @@ func (s *Server) setupRoutes() *http.ServeMux {
 	mux.Handle("/api/caddy/reload", s.authMW.RequireAuth(http.HandlerFunc(s.caddyH.Reload)))
 	mux.Handle("/api/caddy/proxies", s.authMW.RequireAuth(http.HandlerFunc(s.caddyH.APIList)))
 	mux.Handle("/api/caddy/proxy", s.authMW.RequireAuth(http.HandlerFunc(s.caddyH.APIGet)))
+	mux.Handle("/api/caddy/export", s.authMW.RequireAuth(http.HandlerFunc(s.caddyH.APIExport)))
+	mux.Handle("/api/caddy/import", s.authMW.RequireAuth(http.HandlerFunc(s.caddyH.Import)))
+	mux.Handle("/api/caddy/foreign", s.authMW.RequireAuth(http.HandlerFunc(s.caddyH.APIForeign)))
+	mux.Handle("/api/caddy/adopt", s.authMW.RequireAuth(http.HandlerFunc(s.caddyH.Adopt)))
+	mux.Handle("/api/caddy/drift", s.authMW.RequireAuth(http.HandlerFunc(s.caddyH.APIDrift)))
+	mux.Handle("/api/caddy/tls/fetch", s.authMW.RequireAuth(http.HandlerFunc(s.caddyH.FetchUpstreamCert)))
+	mux.Handle("/api/caddy/tls/pin", s.authMW.RequireAuth(http.HandlerFunc(s.caddyH.PinUpstreamCert)))
+	mux.Handle("/api/caddy/tls/pins", s.authMW.RequireAuth(http.HandlerFunc(s.caddyH.APIPins)))
+	mux.Handle("/api/caddy/auth", s.authMW.RequireAuth(http.HandlerFunc(s.caddyH.APIBasicAuthList)))
+	mux.Handle("/api/caddy/auth/set", s.authMW.RequireAuth(http.HandlerFunc(s.caddyH.SetBasicAuthUser)))
+	mux.Handle("/api/caddy/auth/delete", s.authMW.RequireAuth(http.HandlerFunc(s.caddyH.DeleteBasicAuthUser)))
 
 	// Socat routes
 	mux.Handle("/socat", s.authMW.RequireAuth(http.HandlerFunc(s.handleSPARedirect)))
@@ -157,6 +216,16 @@ func (s *Server) setupRoutes() *http.ServeMux {
 	mux.Handle("/api/logs/stream", s.authMW.RequireAuth(http.HandlerFunc(s.logsH.LogsStreamHandler)))
 	mux.Handle("/api/logs/level", s.authMW.RequireAuth(http.HandlerFunc(s.logsH.LogsLevelHandler)))
 
+	// Certificate routes
+	mux.Handle("/api/certificates", s.authMW.RequireAuth(http.HandlerFunc(s.certH.APIList)))
+	mux.Handle("/api/certificates/delete", s.authMW.RequireAuth(http.HandlerFunc(s.certH.Delete)))
+	mux.Handle("/api/certificates/internal-ca", s.authMW.RequireAuth(http.HandlerFunc(s.certH.InternalCA)))
+	mux.Handle("/api/tailscale/certs", s.authMW.RequireAuth(http.HandlerFunc(s.tsCertH.APIStatus)))
+	mux.Handle("/api/tailscale/certs/check", s.authMW.RequireAuth(http.HandlerFunc(s.tsCertH.Check)))
+
+	// Diagnostics routes
+	mux.Handle("/api/diagnostics/probe", s.authMW.RequireAuth(http.HandlerFunc(s.diagH.Probe)))
+
 	return mux
 }
 